@@ -4,14 +4,36 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // embeds the timezone database, the scratch image has none
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/configs"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/envoy"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/events"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/metrics"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/admin"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/forwardauth"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/proxy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -20,58 +42,417 @@ func main() {
 		log.Fatalf("error on config file loading: %s", err.Error())
 	}
 
+	logLevel, err := logging.ParseLevel(conf.LogLevel)
+	if err != nil {
+		log.Fatalf("error on log level parsing: %s", err.Error())
+	}
+
+	logger, err := logging.NewLogger(os.Stdout, logging.Options{
+		Format:          conf.LogFormat,
+		Level:           logLevel,
+		AllowedSampling: conf.LogAllowedSampling,
+	})
+	if err != nil {
+		log.Fatalf("error on logger setup: %s", err.Error())
+	}
+	// every component logs by the default logger unless given another one
+	slog.SetDefault(logger)
+
+	quotaLocation, err := time.LoadLocation(conf.QuotaTimezone)
+	if err != nil {
+		log.Fatalf("error on quota timezone loading: %s", err.Error())
+	}
+
+	limitRules, err := limiter.ParseLimitRules(conf.DefaultLimitRules)
+	if err != nil {
+		log.Fatalf("error on limit rules parsing: %s", err.Error())
+	}
+
+	scheduleLocation, err := time.LoadLocation(conf.ScheduleTimezone)
+	if err != nil {
+		log.Fatalf("error on schedule timezone loading: %s", err.Error())
+	}
+
+	limitSchedule, err := limiter.ParseLimitSchedule(conf.LimitSchedule, scheduleLocation)
+	if err != nil {
+		log.Fatalf("error on limit schedule parsing: %s", err.Error())
+	}
+
+	priorityShares, err := limiter.ParsePriorityShares(conf.GlobalPriorityShares)
+	if err != nil {
+		log.Fatalf("error on priority shares parsing: %s", err.Error())
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if conf.TracingEndpoint != "" {
+		shutdown, err := setupTracing(context.Background(), conf.TracingEndpoint)
+		if err != nil {
+			log.Fatalf("error on tracing setup: %s", err.Error())
+		}
+		defer shutdown(context.Background())
+	}
+
 	redis := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", conf.DBHost, conf.DBPort),
 		Password: conf.DBPassword,
 	})
 
-	repository := database.NewRedisLimiterRepository(context.Background(), redis)
+	limiterMetrics := metrics.NewMetrics()
+	repository := metrics.NewInstrumentedRepository(
+		database.NewRedisLimiterRepository(context.Background(), redis),
+		limiterMetrics,
+	)
 	repository.SaveApiKey(limiter.APIKey{
-		ID:          "goexpert-key",
-		MaxRequests: 5,
+		ID:           "goexpert-key",
+		MaxRequests:  5,
+		MonthlyQuota: 100000,
+		Priority:     limiter.PRIORITY_HIGH,
 	})
 
+	auditor, err := setupAudit(conf, redis)
+	if err != nil {
+		log.Fatalf("error on audit setup: %s", err.Error())
+	}
+
+	eventDispatcher, fileSink, err := setupEvents(conf, redis, auditor)
+	if err != nil {
+		log.Fatalf("error on events setup: %s", err.Error())
+	}
+	defer func() {
+		// the file is only closed once the events queued are written
+		eventDispatcher.Close(context.Background())
+		if fileSink != nil {
+			fileSink.Close()
+		}
+	}()
+
+	accessList := limiter.NewAccessList(repository)
+	accessList.Reload()
+	if conf.AccessListRefresh > 0 {
+		go accessList.Watch(context.Background(), time.Second*time.Duration(conf.AccessListRefresh))
+	}
+
+	usageRetention := limiter.UsageRetention{
+		Minute: time.Second * time.Duration(conf.UsageMinuteRetention),
+		Hour:   time.Second * time.Duration(conf.UsageHourRetention),
+	}
+
 	ratelimiterIP := limiter.NewLimiter(
 		limiter.LimiterConfig{
 			ClientCheckType:       limiter.CHECK_IP_ONLY,
 			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			BlockEscalationFactor: conf.BlockEscalationFactor,
+			MaxClientBlockTime:    time.Second * time.Duration(conf.MaxClientBlockTime),
+			OffenseLookback:       time.Second * time.Duration(conf.OffenseLookback),
 			MaxIPRequests:         conf.DefaultRequestsLimit,
 			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+			Rules:                 limitRules,
+			DailyQuota:            conf.DefaultDailyQuota,
+			MonthlyQuota:          conf.DefaultMonthlyQuota,
+			QuotaLocation:         quotaLocation,
+			Schedule:              limitSchedule,
+			UsageRetention:        usageRetention,
 		},
 		repository,
-	)
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
+
+	// ratelimiterIPShadow evaluates a tighter IP limit on dry run, next to the enforcing one
+	ratelimiterIPShadow := limiter.NewLimiter(
+		limiter.LimiterConfig{
+			Name:                  "ip-shadow",
+			DryRun:                true,
+			ClientCheckType:       limiter.CHECK_IP_ONLY,
+			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			MaxIPRequests:         conf.ShadowRequestsLimit,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		},
+		repository,
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
 
 	ratelimiterApiKey := limiter.NewLimiter(
 		limiter.LimiterConfig{
 			ClientCheckType:       limiter.CHECK_API_KEY_ONLY,
 			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			BlockEscalationFactor: conf.BlockEscalationFactor,
+			MaxClientBlockTime:    time.Second * time.Duration(conf.MaxClientBlockTime),
+			OffenseLookback:       time.Second * time.Duration(conf.OffenseLookback),
 			MaxIPRequests:         conf.DefaultRequestsLimit,
 			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+			Rules:                 limitRules,
+			DailyQuota:            conf.DefaultDailyQuota,
+			MonthlyQuota:          conf.DefaultMonthlyQuota,
+			QuotaLocation:         quotaLocation,
+			Schedule:              limitSchedule,
+			KeyNotFoundSpike:      conf.APIKeyNotFoundSpike,
+			KeyNotFoundWindow:     time.Second * time.Duration(conf.APIKeyNotFoundWindow),
+			UsageRetention:        usageRetention,
 		},
 		repository,
-	)
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
 
 	ratelimiterBoth := limiter.NewLimiter(
 		limiter.LimiterConfig{
 			ClientCheckType:       limiter.CHECK_IP_OR_API_KEY,
 			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			BlockEscalationFactor: conf.BlockEscalationFactor,
+			MaxClientBlockTime:    time.Second * time.Duration(conf.MaxClientBlockTime),
+			OffenseLookback:       time.Second * time.Duration(conf.OffenseLookback),
+			MaxIPRequests:         conf.DefaultRequestsLimit,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+			Rules:                 limitRules,
+			DailyQuota:            conf.DefaultDailyQuota,
+			MonthlyQuota:          conf.DefaultMonthlyQuota,
+			QuotaLocation:         quotaLocation,
+			Schedule:              limitSchedule,
+			KeyNotFoundSpike:      conf.APIKeyNotFoundSpike,
+			KeyNotFoundWindow:     time.Second * time.Duration(conf.APIKeyNotFoundWindow),
+			UsageRetention:        usageRetention,
+		},
+		repository,
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
+
+	ratelimiterIPAndApiKey := limiter.NewLimiter(
+		limiter.LimiterConfig{
+			ClientCheckType:       limiter.CHECK_IP_AND_API_KEY,
+			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			BlockEscalationFactor: conf.BlockEscalationFactor,
+			MaxClientBlockTime:    time.Second * time.Duration(conf.MaxClientBlockTime),
+			OffenseLookback:       time.Second * time.Duration(conf.OffenseLookback),
 			MaxIPRequests:         conf.DefaultRequestsLimit,
 			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+			MaxAPIKeyIPRequests:   conf.APIKeyIPRequestsLimit,
+			Rules:                 limitRules,
+			DailyQuota:            conf.DefaultDailyQuota,
+			MonthlyQuota:          conf.DefaultMonthlyQuota,
+			QuotaLocation:         quotaLocation,
+			Schedule:              limitSchedule,
+			KeyNotFoundSpike:      conf.APIKeyNotFoundSpike,
+			KeyNotFoundWindow:     time.Second * time.Duration(conf.APIKeyNotFoundWindow),
+			UsageRetention:        usageRetention,
+		},
+		repository,
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
+
+	ratelimiterReports := limiter.NewLimiter(
+		limiter.LimiterConfig{
+			Name:                  "reports",
+			ClientCheckType:       limiter.CHECK_GLOBAL,
+			MaxGlobalRequests:     conf.ReportsRequestsLimit,
+			PriorityShares:        priorityShares,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+			UsageRetention:        usageRetention,
 		},
 		repository,
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher)
+
+	// each route scaled by its own latency gets its own controller
+	adaptiveController := limiter.NewAdaptiveController(
+		limiter.AdaptiveConfig{
+			Name:          "/adaptive",
+			MinLimit:      conf.AdaptiveMinLimit,
+			MaxLimit:      conf.AdaptiveMaxLimit,
+			TargetLatency: time.Millisecond * time.Duration(conf.AdaptiveTargetLatency),
+			MaxErrorRate:  conf.AdaptiveMaxErrorRate,
+			Window:        time.Second * time.Duration(conf.AdaptiveWindow),
+		},
 	)
+	limiterMetrics.ObserveAdaptive(adaptiveController)
+	ratelimiterAdaptive := limiter.NewLimiter(
+		limiter.LimiterConfig{
+			Name:                  "adaptive",
+			ClientCheckType:       limiter.CHECK_IP_ONLY,
+			ClientBlockTime:       time.Second * time.Duration(conf.DefaultClientBlockTime),
+			MaxIPRequests:         conf.AdaptiveMaxLimit,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		},
+		repository,
+	).WithAccessList(accessList).WithObserver(limiterMetrics).WithEvents(eventDispatcher).WithAdaptiveLimit(adaptiveController)
+
+	concurrencyReports := limiter.NewConcurrencyLimiter(
+		limiter.ConcurrencyConfig{
+			Name:              "reports",
+			ClientCheckType:   limiter.CHECK_IP_OR_API_KEY,
+			MaxInFlight:       conf.ConcurrencyLimit,
+			MaxGlobalInFlight: conf.GlobalConcurrencyLimit,
+			LeaseTTL:          time.Second * time.Duration(conf.ConcurrencyLease),
+		},
+		repository,
+	).WithAccessList(accessList)
 
 	mux := http.NewServeMux()
 	// mux.Handle("/", middleware.NewLimiterMiddleware(ratelimiterBoth).Limit(http.HandlerFunc(handler)))
-	mux.Handle("/ip", middleware.NewLimiterMiddleware(ratelimiterIP).Limit(http.HandlerFunc(handler)))
+	ipHandler := middleware.NewLimiterMiddleware(ratelimiterIP).Limit(http.HandlerFunc(handler))
+	if conf.ShadowRequestsLimit > 0 {
+		ipHandler = middleware.NewLimiterMiddleware(ratelimiterIPShadow).Limit(ipHandler)
+	}
+	mux.Handle("/ip", ipHandler)
 	mux.Handle("/apikey", middleware.NewLimiterMiddleware(ratelimiterApiKey).Limit(http.HandlerFunc(handler)))
 	mux.Handle("/ip-apikey", middleware.NewLimiterMiddleware(ratelimiterBoth).Limit(http.HandlerFunc(handler)))
+	mux.Handle("/search", middleware.NewLimiterMiddleware(ratelimiterBoth).WithCost(middleware.FixedCost(2)).Limit(http.HandlerFunc(handler)))
+	mux.Handle("/batch", middleware.NewLimiterMiddleware(ratelimiterBoth).WithCost(middleware.HeaderCost("X-Batch-Size", 1)).Limit(http.HandlerFunc(handler)))
+	mux.Handle("/batch-wait", middleware.NewLimiterMiddleware(ratelimiterBoth).
+		WithCost(middleware.HeaderCost("X-Batch-Size", 1)).
+		WithWait(time.Millisecond*time.Duration(conf.WaitMaxDelay), conf.WaitMaxQueue).
+		Limit(http.HandlerFunc(handler)))
+	mux.Handle("/reports", middleware.NewLimiterMiddleware(ratelimiterBoth).Limit(
+		middleware.NewLimiterMiddleware(ratelimiterReports).Limit(
+			middleware.NewConcurrencyMiddleware(concurrencyReports).Limit(http.HandlerFunc(handler)),
+		),
+	))
+	// health checks share the /reports capacity, but are the last ones refused
+	mux.Handle("/health", middleware.NewLimiterMiddleware(ratelimiterReports).
+		WithPriority(middleware.FixedPriority(limiter.PRIORITY_CRITICAL)).
+		Limit(http.HandlerFunc(handler)))
+	mux.Handle("/adaptive", middleware.NewLimiterMiddleware(ratelimiterAdaptive).Limit(http.HandlerFunc(handler)))
+	mux.Handle("/ip-and-apikey", middleware.NewLimiterMiddleware(ratelimiterIPAndApiKey).Limit(http.HandlerFunc(handler)))
+	// decision only endpoint of nginx auth_request and Traefik ForwardAuth, checking the original request they forward
+	mux.Handle("/auth", forwardauth.NewHandler(
+		forwardauth.Policy{Pattern: "/reports", Limiters: []*middleware.LimiterMiddleware{
+			middleware.NewLimiterMiddleware(ratelimiterBoth),
+			middleware.NewLimiterMiddleware(ratelimiterReports),
+		}},
+		forwardauth.Policy{Pattern: "/", Limiters: []*middleware.LimiterMiddleware{
+			middleware.NewLimiterMiddleware(ratelimiterBoth),
+		}},
+	))
 
-	log.Println("server running on port 8080")
-	err = http.ListenAndServe(":8080", mux)
+	adminMux := http.NewServeMux()
+	admin.NewAccessHandler(accessList, auditor).Register(adminMux)
+	admin.NewKeysHandler(repository, auditor).Register(adminMux)
+	adminLimiters := map[string]*limiter.Limiter{
+		"default":   ratelimiterBoth,
+		"ip-shadow": ratelimiterIPShadow,
+		"reports":   ratelimiterReports,
+		"adaptive":  ratelimiterAdaptive,
+	}
+	admin.NewClientsHandler(adminLimiters, auditor).Register(adminMux)
+	admin.NewUsageHandler(adminLimiters).Register(adminMux)
+	admin.NewAuditHandler(auditor).Register(adminMux)
+	adminMux.Handle("GET /metrics", promhttp.HandlerFor(limiterMetrics.Registry, promhttp.HandlerOpts{}))
+	adminTokens, err := admin.ParseTokens(conf.AdminTokens)
+	if err != nil {
+		log.Fatalf("Error parsing admin tokens: %v", err)
+	}
+	if len(adminTokens) == 0 {
+		slog.Warn("admin server disabled, no admin tokens set")
+	} else {
+		go func() {
+			slog.Info("admin server running", slog.String("port", conf.AdminPort))
+			err := http.ListenAndServe(":"+conf.AdminPort, admin.NewAuthenticator(adminTokens...).Require(adminMux))
+			if err != nil {
+				log.Fatalf("Error starting admin server: %v", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if conf.RLSPort != "" {
+		// Envoy rate limit service, descriptors carrying an API Key are limited by it, the others by IP
+		rlsServer := grpc.NewServer()
+		envoy.NewRateLimitService(
+			envoy.Policy{Domain: conf.RLSDomain, Match: map[string]string{envoy.API_KEY_KEY: ""}, Limiter: ratelimiterApiKey},
+			envoy.Policy{Domain: conf.RLSDomain, Match: map[string]string{envoy.IP_KEY: ""}, Limiter: ratelimiterIP},
+		).Register(rlsServer)
+		go func() {
+			slog.Info("rate limit service running", slog.String("port", conf.RLSPort))
+			if err := serveGRPC(ctx, ":"+conf.RLSPort, rlsServer); err != nil {
+				log.Fatalf("Error starting rate limit service: %v", err)
+			}
+		}()
+	}
+
+	var handler http.Handler = mux
+	if conf.ProxyRoutes != "" {
+		// proxy mode, the routes are forwarded to the upstreams instead of the handlers above
+		routes, err := proxy.ParseRoutes(conf.ProxyRoutes)
+		if err != nil {
+			log.Fatalf("error on proxy routes parsing: %s", err.Error())
+		}
+		for _, route := range routes {
+			route.WithLimiters(middleware.NewLimiterMiddleware(ratelimiterBoth))
+		}
+
+		handler = proxy.NewProxy(routes...).Handler()
+		go proxy.NewHealthChecker(routes...).
+			WithPath(conf.ProxyHealthPath).
+			WithInterval(time.Second * time.Duration(conf.ProxyHealthInterval)).
+			Watch(ctx)
+	}
+
+	slog.Info("server running", slog.String("port", "8080"), slog.Bool("proxy", conf.ProxyRoutes != ""))
+	err = proxy.ListenAndServe(ctx, &http.Server{Addr: ":8080", Handler: handler}, proxy.DEFAULT_SHUTDOWN_TIMEOUT)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
+	slog.Info("server stopped")
+}
+
+// serveGRPC serves until ctx is done, then stops the server gracefully
+func serveGRPC(ctx context.Context, addr string, server *grpc.Server) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	return server.Serve(listener)
+}
+
+// setupAudit returns the auditor recording on the configured store
+func setupAudit(conf *configs.Config, redisClient *redis.Client) (*audit.Auditor, error) {
+	switch conf.AuditStore {
+	case "", "redis":
+		return audit.NewAuditor(audit.NewRedisStreamStore(redisClient, conf.AuditStream)), nil
+	case "file":
+		return audit.NewAuditor(audit.NewFileStore(conf.AuditFile)), nil
+	default:
+		return nil, fmt.Errorf("invalid audit store %q, must be redis or file", conf.AuditStore)
+	}
+}
+
+// setupEvents returns the dispatcher sending the limiter events to the configured sinks,
+// the blocks being recorded on the audit trail as they happen. The file sink, nil if the events are not written to a file,
+// must be closed once the dispatcher is
+func setupEvents(conf *configs.Config, redisClient *redis.Client, auditor *audit.Auditor) (*events.Dispatcher, *events.FileSink, error) {
+	var sinks []events.Sink
+	if conf.EventsWebhookURL != "" {
+		sinks = append(sinks, events.NewWebhookSink(conf.EventsWebhookURL, conf.EventsWebhookSecret))
+	}
+	if conf.EventsRedisChannel != "" {
+		sinks = append(sinks, events.NewRedisSink(redisClient, conf.EventsRedisChannel))
+	}
+	var fileSink *events.FileSink
+	if conf.EventsFile != "" {
+		var err error
+		fileSink, err = events.NewFileSink(conf.EventsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, fileSink)
+	}
+	dispatcher := events.NewDispatcher(events.DEFAULT_BUFFER_SIZE, sinks...).WithSyncSinks(audit.NewEventSink(auditor))
+	return dispatcher, fileSink, nil
+}
+
+// setupTracing exports the spans to the OTLP/HTTP collector on endpoint,
+// returning the function flushing the spans left on shutdown
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "ratelimiter"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
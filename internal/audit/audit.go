@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// Actions recorded on the audit trail
+const (
+	ACTION_API_KEY_CREATED  = "api_key.created"
+	ACTION_API_KEY_UPDATED  = "api_key.updated"
+	ACTION_API_KEY_REVOKED  = "api_key.revoked"
+	ACTION_CLIENT_UNBLOCKED = "client.unblocked"
+	ACTION_CLIENT_BLOCKED   = "client.blocked"
+	ACTION_ACCESS_SAVED     = "access.saved"
+	ACTION_ACCESS_DELETED   = "access.deleted"
+)
+
+// ACTOR_LIMITER_PREFIX is prefixed to the limiter name on the actor of the enforcement actions
+const ACTOR_LIMITER_PREFIX = "limiter:"
+
+// DEFAULT_QUERY_LIMIT is how many entries a query returns if the filter has no limit
+const DEFAULT_QUERY_LIMIT = 100
+
+var ErrInvalidEntry = errors.New("the audit entry must have an action, an actor and a target")
+
+// Entry is an action recorded on the audit trail
+type Entry struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+
+	// Actor is who took the action, as an admin, or the limiter on enforcement actions
+	Actor string `json:"actor"`
+
+	// Target is the API Key, the client or the access list value the action was taken on
+	Target string `json:"target"`
+
+	// Limiter is the limiter the client was blocked or unblocked on
+	Limiter string `json:"limiter,omitempty"`
+
+	At time.Time `json:"at"`
+
+	// Before and After are the target values before and after the action, empty if there were none
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Filter selects the entries of a query, its empty fields selecting every entry
+type Filter struct {
+	Target string
+	Action string
+
+	// From and To bound the entry times, From included and To excluded
+	From time.Time
+	To   time.Time
+
+	// Limit is the most entries returned, DEFAULT_QUERY_LIMIT if 0
+	Limit int
+}
+
+// Matches reports whether the entry is selected by the filter
+func (f Filter) Matches(entry Entry) bool {
+	return (f.Target == "" || entry.Target == f.Target) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.From.IsZero() || !entry.At.Before(f.From)) &&
+		(f.To.IsZero() || entry.At.Before(f.To))
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DEFAULT_QUERY_LIMIT
+	}
+	return f.Limit
+}
+
+// Store keeps the audit entries, entries are never changed nor removed
+type Store interface {
+	Append(ctx context.Context, entry Entry) error
+
+	// Query returns the entries selected by the filter, the latest first
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+}
+
+// Auditor records the actions on the audit trail
+type Auditor struct {
+	Store Store
+	Clock limiter.Clock
+}
+
+func NewAuditor(store Store) *Auditor {
+	return &Auditor{
+		Store: store,
+		Clock: limiter.SystemClock{},
+	}
+}
+
+// WithClock sets the clock the entries are timed by
+func (a *Auditor) WithClock(clock limiter.Clock) *Auditor {
+	a.Clock = clock
+	return a
+}
+
+// Record appends the action, taken on the target by the actor, to the audit trail.
+// before and after are the target values, nil if there were none, as before a creation
+func (a *Auditor) Record(ctx context.Context, entry Entry, before, after any) error {
+	if entry.Action == "" || entry.Actor == "" || entry.Target == "" {
+		return ErrInvalidEntry
+	}
+
+	var err error
+	if entry.Before, err = marshalValue(before); err != nil {
+		return err
+	}
+	if entry.After, err = marshalValue(after); err != nil {
+		return err
+	}
+
+	if entry.ID == "" {
+		entry.ID = newEntryID()
+	}
+	if entry.At.IsZero() {
+		entry.At = a.Clock.Now()
+	}
+	return a.Store.Append(ctx, entry)
+}
+
+// Query returns the entries selected by the filter, the latest first
+func (a *Auditor) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return a.Store.Query(ctx, filter)
+}
+
+func marshalValue(value any) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+func newEntryID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
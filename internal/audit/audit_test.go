@@ -0,0 +1,182 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+	Clock *limiter.FakeClock
+	Start time.Time
+	Redis *redis.Client
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
+
+func (suite *AuditTestSuite) SetupTest() {
+	suite.Start = time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+	suite.Clock = limiter.NewFakeClock(suite.Start)
+	suite.Redis = redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "redis-passw0rd",
+	})
+	suite.Redis.Del(context.Background(), "audit-test")
+}
+
+func (suite *AuditTestSuite) TearDownTest() {
+	suite.Redis.Del(context.Background(), "audit-test")
+	suite.Redis.Close()
+}
+
+// record records an entry a minute after the previous one
+func (suite *AuditTestSuite) record(auditor *audit.Auditor, action, target string, before, after any) {
+	suite.Clock.Advance(time.Minute)
+	suite.NoError(auditor.Record(context.Background(), audit.Entry{
+		Action: action,
+		Actor:  "admin@example.com",
+		Target: target,
+	}, before, after))
+}
+
+// assertStore records on the store and checks the entries returned by the queries
+func (suite *AuditTestSuite) assertStore(store audit.Store) {
+	auditor := audit.NewAuditor(store).WithClock(suite.Clock)
+	ctx := context.Background()
+
+	suite.record(auditor, audit.ACTION_API_KEY_CREATED, "key-1", nil, map[string]int{"max_requests": 5})
+	suite.record(auditor, audit.ACTION_API_KEY_UPDATED, "key-1", map[string]int{"max_requests": 5}, map[string]int{"max_requests": 10})
+	suite.record(auditor, audit.ACTION_API_KEY_CREATED, "key-2", nil, map[string]int{"max_requests": 1})
+	suite.record(auditor, audit.ACTION_API_KEY_REVOKED, "key-1", map[string]int{"max_requests": 10}, nil)
+
+	entries, err := auditor.Query(ctx, audit.Filter{})
+	suite.NoError(err)
+	suite.Len(entries, 4)
+	suite.Equal(audit.ACTION_API_KEY_REVOKED, entries[0].Action)
+	suite.Equal(audit.ACTION_API_KEY_CREATED, entries[3].Action)
+	suite.Equal("admin@example.com", entries[3].Actor)
+	suite.True(suite.Start.Add(time.Minute).Equal(entries[3].At))
+	suite.Nil(entries[3].Before)
+	suite.JSONEq(`{"max_requests":5}`, string(entries[3].After))
+	suite.NotEmpty(entries[3].ID)
+
+	entries, err = auditor.Query(ctx, audit.Filter{Target: "key-1"})
+	suite.NoError(err)
+	suite.Len(entries, 3)
+
+	entries, err = auditor.Query(ctx, audit.Filter{Target: "key-1", Action: audit.ACTION_API_KEY_UPDATED})
+	suite.NoError(err)
+	suite.Len(entries, 1)
+	suite.JSONEq(`{"max_requests":5}`, string(entries[0].Before))
+	suite.JSONEq(`{"max_requests":10}`, string(entries[0].After))
+
+	entries, err = auditor.Query(ctx, audit.Filter{
+		From: suite.Start.Add(2 * time.Minute),
+		To:   suite.Start.Add(4 * time.Minute),
+	})
+	suite.NoError(err)
+	suite.Len(entries, 2)
+	suite.Equal("key-2", entries[0].Target)
+	suite.Equal(audit.ACTION_API_KEY_UPDATED, entries[1].Action)
+
+	entries, err = auditor.Query(ctx, audit.Filter{Limit: 1})
+	suite.NoError(err)
+	suite.Len(entries, 1)
+	suite.Equal(audit.ACTION_API_KEY_REVOKED, entries[0].Action)
+}
+
+func (suite *AuditTestSuite) TestAuditor_Record_InvalidEntry() {
+	auditor := audit.NewAuditor(audit.NewFileStore(filepath.Join(suite.T().TempDir(), "audit.jsonl")))
+	err := auditor.Record(context.Background(), audit.Entry{Action: audit.ACTION_API_KEY_CREATED, Target: "key-1"}, nil, nil)
+	suite.Equal(audit.ErrInvalidEntry, err)
+}
+
+func (suite *AuditTestSuite) TestFilter_Matches() {
+	entry := audit.Entry{Action: audit.ACTION_CLIENT_UNBLOCKED, Target: "192.168.0.1", At: suite.Start}
+
+	suite.True(audit.Filter{}.Matches(entry))
+	suite.True(audit.Filter{Target: "192.168.0.1", Action: audit.ACTION_CLIENT_UNBLOCKED}.Matches(entry))
+	suite.True(audit.Filter{From: suite.Start, To: suite.Start.Add(time.Second)}.Matches(entry))
+	suite.False(audit.Filter{Target: "192.168.0.2"}.Matches(entry))
+	suite.False(audit.Filter{Action: audit.ACTION_CLIENT_BLOCKED}.Matches(entry))
+	suite.False(audit.Filter{From: suite.Start.Add(time.Second)}.Matches(entry))
+	suite.False(audit.Filter{To: suite.Start}.Matches(entry))
+}
+
+func (suite *AuditTestSuite) TestFileStore() {
+	suite.assertStore(audit.NewFileStore(filepath.Join(suite.T().TempDir(), "audit.jsonl")))
+}
+
+func (suite *AuditTestSuite) TestFileStore_Query_Missing() {
+	entries, err := audit.NewFileStore(filepath.Join(suite.T().TempDir(), "audit.jsonl")).Query(context.Background(), audit.Filter{})
+	suite.NoError(err)
+	suite.Empty(entries)
+}
+
+func (suite *AuditTestSuite) TestRedisStreamStore() {
+	suite.assertStore(audit.NewRedisStreamStore(suite.Redis, "audit-test"))
+}
+
+func (suite *AuditTestSuite) TestRedisStreamStore_Query_Paging() {
+	store := audit.NewRedisStreamStore(suite.Redis, "audit-test")
+	auditor := audit.NewAuditor(store).WithClock(suite.Clock)
+	for i := 0; i < 1200; i++ {
+		suite.record(auditor, audit.ACTION_API_KEY_UPDATED, fmt.Sprintf("key-%d", i%3), nil, nil)
+	}
+
+	entries, err := auditor.Query(context.Background(), audit.Filter{Target: "key-0", Limit: 1000})
+	suite.NoError(err)
+	suite.Len(entries, 400)
+	suite.Equal("key-0", entries[399].Target)
+	suite.True(suite.Start.Add(time.Minute).Equal(entries[399].At))
+}
+
+func (suite *AuditTestSuite) TestEventSink_Send() {
+	store := audit.NewFileStore(filepath.Join(suite.T().TempDir(), "audit.jsonl"))
+	sink := audit.NewEventSink(audit.NewAuditor(store).WithClock(suite.Clock))
+	ctx := context.Background()
+
+	suite.NoError(sink.Send(ctx, limiter.Event{
+		Type:         limiter.EVENT_CLIENT_BLOCKED,
+		Limiter:      "default",
+		ClientID:     "192.168.0.1",
+		APIKey:       "goex****",
+		APIKeyID:     "goexpert-key",
+		Dimension:    limiter.DIMENSION_API_KEY,
+		PenaltyLevel: 2,
+		At:           suite.Start,
+		ExpiresAt:    suite.Start.Add(time.Minute),
+	}))
+	suite.NoError(sink.Send(ctx, limiter.Event{
+		Type:     limiter.EVENT_CLIENT_UNBLOCKED,
+		Limiter:  "default",
+		ClientID: "192.168.0.1",
+		At:       suite.Start.Add(time.Minute),
+	}))
+
+	entries, err := store.Query(ctx, audit.Filter{})
+	suite.NoError(err)
+	suite.Len(entries, 1)
+	suite.Equal(audit.ACTION_CLIENT_BLOCKED, entries[0].Action)
+	suite.Equal("limiter:default", entries[0].Actor)
+	suite.Equal("goexpert-key", entries[0].Target)
+	suite.Equal("default", entries[0].Limiter)
+	suite.True(suite.Start.Equal(entries[0].At))
+
+	var block audit.Block
+	suite.NoError(json.Unmarshal(entries[0].After, &block))
+	suite.Equal(2, block.PenaltyLevel)
+	suite.Equal(limiter.DIMENSION_API_KEY, block.Dimension)
+	suite.True(suite.Start.Add(time.Minute).Equal(block.ExpiresAt))
+}
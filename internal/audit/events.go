@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// Block is a client block as recorded on the audit trail
+type Block struct {
+	ClientID     string    `json:"client_id,omitempty"`
+	APIKey       string    `json:"api_key,omitempty"`
+	Dimension    string    `json:"dimension,omitempty"`
+	PenaltyLevel int       `json:"penalty_level"`
+	DryRun       bool      `json:"dry_run,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// EventSink records the enforcement blocks of the limiter events on the audit trail,
+// as a sync events sink, so that no block is dropped unrecorded. Every other event is ignored
+type EventSink struct {
+	Auditor *Auditor
+}
+
+func NewEventSink(auditor *Auditor) *EventSink {
+	return &EventSink{
+		Auditor: auditor,
+	}
+}
+
+func (s *EventSink) Send(ctx context.Context, event limiter.Event) error {
+	if event.Type != limiter.EVENT_CLIENT_BLOCKED {
+		return nil
+	}
+
+	entry := Entry{
+		Action:  ACTION_CLIENT_BLOCKED,
+		Actor:   ACTOR_LIMITER_PREFIX + event.Limiter,
+		Target:  blockTarget(event),
+		Limiter: event.Limiter,
+		At:      event.At,
+	}
+	after := Block{
+		ClientID:     event.ClientID,
+		APIKey:       event.APIKeyID,
+		Dimension:    event.Dimension,
+		PenaltyLevel: event.PenaltyLevel,
+		DryRun:       event.DryRun,
+		ExpiresAt:    event.ExpiresAt,
+	}
+	return s.Auditor.Record(ctx, entry, nil, after)
+}
+
+// blockTarget returns the client that was blocked, as an IP, an API Key or `<API Key>@<IP>`
+func blockTarget(event limiter.Event) string {
+	switch event.Dimension {
+	case limiter.DIMENSION_API_KEY:
+		return event.APIKeyID
+	case limiter.DIMENSION_API_KEY_IP:
+		return event.APIKeyID + "@" + event.ClientID
+	default:
+		return event.ClientID
+	}
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DEFAULT_STREAM is the Redis stream the entries are appended to if the store has no stream
+const DEFAULT_STREAM = "audit"
+
+// queryPage is how many stream entries are read at once by a query
+const queryPage = 500
+
+// RedisStreamStore appends the entries to a Redis stream, whose entry IDs are the entry times.
+// The stream is never trimmed
+type RedisStreamStore struct {
+	Redis  *redis.Client
+	Stream string
+}
+
+func NewRedisStreamStore(redisClient *redis.Client, stream string) *RedisStreamStore {
+	if stream == "" {
+		stream = DEFAULT_STREAM
+	}
+	return &RedisStreamStore{
+		Redis:  redisClient,
+		Stream: stream,
+	}
+}
+
+func (s *RedisStreamStore) Append(ctx context.Context, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	args := &redis.XAddArgs{
+		Stream: s.Stream,
+		ID:     fmt.Sprintf("%d-*", entry.At.UnixMilli()),
+		Values: map[string]any{"entry": value},
+	}
+	err = s.Redis.XAdd(ctx, args).Err()
+	if err != nil && strings.Contains(err.Error(), "equal or smaller") {
+		// an entry of a later time was appended by another instance, the stream ID is kept increasing
+		args.ID = "*"
+		err = s.Redis.XAdd(ctx, args).Err()
+	}
+	return err
+}
+
+func (s *RedisStreamStore) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	// stream IDs are never older than their entries, so the entries from filter.From are all past its ID
+	start := "-"
+	if !filter.From.IsZero() {
+		start = fmt.Sprintf("%d", filter.From.UnixMilli())
+	}
+
+	var entries []Entry
+	end := "+"
+	for {
+		messages, err := s.Redis.XRevRangeN(ctx, s.Stream, end, start, queryPage).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			var entry Entry
+			value, _ := message.Values["entry"].(string)
+			if err := json.Unmarshal([]byte(value), &entry); err != nil {
+				return nil, err
+			}
+			if filter.Matches(entry) {
+				entries = append(entries, entry)
+				if len(entries) == filter.limit() {
+					return entries, nil
+				}
+			}
+		}
+
+		if len(messages) < queryPage {
+			return entries, nil
+		}
+		end = "(" + messages[len(messages)-1].ID
+	}
+}
@@ -3,12 +3,58 @@ package configs
 import "github.com/spf13/viper"
 
 type Config struct {
-	DefaultLimitType       int    `mapstructure:"DEFAULT_LIMIT_TYPE"`
-	DefaultRequestsLimit   int    `mapstructure:"DEFAULT_REQUESTS_LIMIT"`
-	DefaultClientBlockTime int    `mapstructure:"DEFAULT_CLIENT_BLOCK_TIME"`
-	DBHost                 string `mapstructure:"DB_HOST"`
-	DBPort                 string `mapstructure:"DB_PORT"`
-	DBPassword             string `mapstructure:"DB_PASSWORD"`
+	DefaultLimitType       int     `mapstructure:"DEFAULT_LIMIT_TYPE"`
+	DefaultRequestsLimit   int     `mapstructure:"DEFAULT_REQUESTS_LIMIT"`
+	DefaultClientBlockTime int     `mapstructure:"DEFAULT_CLIENT_BLOCK_TIME"`
+	BlockEscalationFactor  int     `mapstructure:"BLOCK_ESCALATION_FACTOR"`
+	MaxClientBlockTime     int     `mapstructure:"MAX_CLIENT_BLOCK_TIME"`
+	OffenseLookback        int     `mapstructure:"OFFENSE_LOOKBACK"`
+	DefaultLimitRules      string  `mapstructure:"DEFAULT_LIMIT_RULES"`
+	APIKeyIPRequestsLimit  int     `mapstructure:"API_KEY_IP_REQUESTS_LIMIT"`
+	ReportsRequestsLimit   int     `mapstructure:"REPORTS_REQUESTS_LIMIT"`
+	GlobalPriorityShares   string  `mapstructure:"GLOBAL_PRIORITY_SHARES"`
+	ShadowRequestsLimit    int     `mapstructure:"SHADOW_REQUESTS_LIMIT"`
+	WaitMaxDelay           int     `mapstructure:"WAIT_MAX_DELAY"`
+	WaitMaxQueue           int     `mapstructure:"WAIT_MAX_QUEUE"`
+	ConcurrencyLimit       int     `mapstructure:"CONCURRENCY_LIMIT"`
+	GlobalConcurrencyLimit int     `mapstructure:"GLOBAL_CONCURRENCY_LIMIT"`
+	ConcurrencyLease       int     `mapstructure:"CONCURRENCY_LEASE"`
+	AdaptiveMinLimit       int     `mapstructure:"ADAPTIVE_MIN_LIMIT"`
+	AdaptiveMaxLimit       int     `mapstructure:"ADAPTIVE_MAX_LIMIT"`
+	AdaptiveTargetLatency  int     `mapstructure:"ADAPTIVE_TARGET_LATENCY"`
+	AdaptiveMaxErrorRate   float64 `mapstructure:"ADAPTIVE_MAX_ERROR_RATE"`
+	AdaptiveWindow         int     `mapstructure:"ADAPTIVE_WINDOW"`
+	DefaultDailyQuota      int     `mapstructure:"DEFAULT_DAILY_QUOTA"`
+	DefaultMonthlyQuota    int     `mapstructure:"DEFAULT_MONTHLY_QUOTA"`
+	LimitSchedule          string  `mapstructure:"LIMIT_SCHEDULE"`
+	ScheduleTimezone       string  `mapstructure:"SCHEDULE_TIMEZONE"`
+	QuotaTimezone          string  `mapstructure:"QUOTA_TIMEZONE"`
+	AccessListRefresh      int     `mapstructure:"ACCESS_LIST_REFRESH"`
+	APIKeyNotFoundSpike    int     `mapstructure:"API_KEY_NOT_FOUND_SPIKE"`
+	APIKeyNotFoundWindow   int     `mapstructure:"API_KEY_NOT_FOUND_WINDOW"`
+	EventsWebhookURL       string  `mapstructure:"EVENTS_WEBHOOK_URL"`
+	EventsWebhookSecret    string  `mapstructure:"EVENTS_WEBHOOK_SECRET"`
+	EventsRedisChannel     string  `mapstructure:"EVENTS_REDIS_CHANNEL"`
+	EventsFile             string  `mapstructure:"EVENTS_FILE"`
+	AuditStore             string  `mapstructure:"AUDIT_STORE"`
+	AuditStream            string  `mapstructure:"AUDIT_STREAM"`
+	AuditFile              string  `mapstructure:"AUDIT_FILE"`
+	UsageMinuteRetention   int     `mapstructure:"USAGE_MINUTE_RETENTION"`
+	UsageHourRetention     int     `mapstructure:"USAGE_HOUR_RETENTION"`
+	ProxyRoutes            string  `mapstructure:"PROXY_ROUTES"`
+	ProxyHealthPath        string  `mapstructure:"PROXY_HEALTH_PATH"`
+	ProxyHealthInterval    int     `mapstructure:"PROXY_HEALTH_INTERVAL"`
+	RLSPort                string  `mapstructure:"RLS_PORT"`
+	RLSDomain              string  `mapstructure:"RLS_DOMAIN"`
+	AdminPort              string  `mapstructure:"ADMIN_PORT"`
+	AdminTokens            string  `mapstructure:"ADMIN_TOKENS"`
+	TracingEndpoint        string  `mapstructure:"TRACING_ENDPOINT"`
+	LogFormat              string  `mapstructure:"LOG_FORMAT"`
+	LogLevel               string  `mapstructure:"LOG_LEVEL"`
+	LogAllowedSampling     int     `mapstructure:"LOG_ALLOWED_SAMPLING"`
+	DBHost                 string  `mapstructure:"DB_HOST"`
+	DBPort                 string  `mapstructure:"DB_PORT"`
+	DBPassword             string  `mapstructure:"DB_PASSWORD"`
 }
 
 func LoadConfig(path string) (*Config, error) {
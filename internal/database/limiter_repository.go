@@ -3,7 +3,9 @@ package database
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
@@ -11,20 +13,117 @@ import (
 
 const KEYSPACE_API_KEY = "apiKey"
 const KEYSPACE_CLIENT = "client"
+const KEYSPACE_QUOTA = "quota"
+const KEYSPACE_RULE = "rule"
+const KEYSPACE_ACCESS = "access"
+const KEYSPACE_OFFENSE = "offense"
+const KEYSPACE_SLOT = "slot"
+const KEYSPACE_USAGE = "usage"
+const KEYSPACE_USAGE_RANKING = "usageRanking"
+
+// consumeRulesScript charges every rule counter at once, only if none of them would exceed its max requests.
+// KEYS are the counters and ARGV the charged amount followed by the max requests and interval (ms) of each counter.
+// It returns the exceeded counter index and its remaining ttl (ms), or -1 if the counters were charged
+var consumeRulesScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+for i, key in ipairs(KEYS) do
+	local current = tonumber(redis.call("GET", key) or "0")
+	if current + cost > tonumber(ARGV[i * 2]) then
+		return {i - 1, redis.call("PTTL", key)}
+	end
+end
+
+for i, key in ipairs(KEYS) do
+	if redis.call("INCRBY", key, cost) == cost then
+		redis.call("PEXPIRE", key, ARGV[i * 2 + 1])
+	end
+end
+return {-1, 0}
+`)
+
+// consumeQuotasScript charges every quota at once, only if none of them would exceed its max requests.
+// KEYS are the quotas, hashes, and ARGV the charged amount followed by the max requests, the ID, the period,
+// the start and the reset time (unix) of each quota. It returns 1 if the quotas were charged, 0 otherwise,
+// followed by the requests of each quota
+var consumeQuotasScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+local charged = 1
+local requests = {}
+for i, key in ipairs(KEYS) do
+	requests[i] = tonumber(redis.call("HGET", key, "requests") or "0")
+	if cost > 0 and requests[i] + cost > tonumber(ARGV[i * 5 - 3]) then
+		charged = 0
+	end
+end
+
+if charged == 1 then
+	for i, key in ipairs(KEYS) do
+		redis.call("HSET", key, "id", ARGV[i * 5 - 2], "period", ARGV[i * 5 - 1], "start", ARGV[i * 5])
+		requests[i] = redis.call("HINCRBY", key, "requests", cost)
+		redis.call("EXPIREAT", key, ARGV[i * 5 + 1])
+	end
+end
+
+local res = {charged}
+for i = 1, #requests do
+	res[i + 1] = requests[i]
+end
+return res
+`)
+
+// acquireSlotsScript leases a slot on every counter at once, only if none of them is full.
+// KEYS are the counters, sorted sets of lease IDs scored by their expiration (ms), and ARGV the lease ID,
+// the current time (ms), the lease ttl (ms) followed by the max in flight of each counter.
+// It returns the full counter index, or -1 if the slots were leased
+var acquireSlotsScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+for i, key in ipairs(KEYS) do
+	redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+	if redis.call("ZSCORE", key, ARGV[1]) == false and redis.call("ZCARD", key) >= tonumber(ARGV[i + 3]) then
+		return i - 1
+	end
+end
+
+for i, key in ipairs(KEYS) do
+	redis.call("ZADD", key, now + tonumber(ARGV[3]), ARGV[1])
+	redis.call("PEXPIRE", key, ARGV[3])
+end
+return -1
+`)
 
 type RedisLimiterRepository struct {
 	ctx   context.Context
 	redis *redis.Client
+	clock limiter.Clock
 }
 
 func NewRedisLimiterRepository(ctx context.Context, redisClient *redis.Client) *RedisLimiterRepository {
 	return &RedisLimiterRepository{
 		ctx:   ctx,
 		redis: redisClient,
+		clock: limiter.SystemClock{},
 	}
 }
 
+// WithContext returns a copy of the repository running its calls within ctx,
+// their spans being children of the ctx span
+func (r *RedisLimiterRepository) WithContext(ctx context.Context) limiter.LimiterRepositoryInterface {
+	repository := *r
+	repository.ctx = ctx
+	return &repository
+}
+
+// WithClock sets the clock the slot leases expire by.
+// Every other key expires by its Redis ttl, that follows the Redis server clock
+func (r *RedisLimiterRepository) WithClock(clock limiter.Clock) *RedisLimiterRepository {
+	r.clock = clock
+	return r
+}
+
 func (r *RedisLimiterRepository) ApiKey(id string) *limiter.APIKey {
+	r, span := r.traced("ApiKey")
+	defer span.End()
+
 	res := r.getMap(KEYSPACE_API_KEY, id)
 	if len(res) > 0 {
 		apiKey := mapToApiKey(res)
@@ -36,26 +135,208 @@ func (r *RedisLimiterRepository) ApiKey(id string) *limiter.APIKey {
 }
 
 func (r *RedisLimiterRepository) Client(id string) *limiter.Client {
-	res := r.getMap(KEYSPACE_CLIENT, id)
-	if len(res) > 0 {
-		client := mapToClient(res)
+	r, span := r.traced("Client")
+	defer span.End()
+
+	key := generateKey(KEYSPACE_CLIENT, id)
+	var res *redis.MapStringStringCmd
+	var ttl *redis.DurationCmd
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		res = pipe.HGetAll(r.ctx, key)
+		ttl = pipe.PTTL(r.ctx, key)
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		return nil
+	}
+
+	if len(res.Val()) > 0 {
+		client := mapToClient(res.Val())
 		if (client != limiter.Client{}) {
+			client.TTL = max(ttl.Val(), 0)
 			return &client
 		}
 	}
 	return nil
 }
 
+func (r *RedisLimiterRepository) Quota(id string, period int, start time.Time) *limiter.Quota {
+	r, span := r.traced("Quota")
+	defer span.End()
+
+	res := r.getMap(KEYSPACE_QUOTA, quotaKey(id, period, start))
+	if len(res) > 0 {
+		quota := mapToQuota(res)
+		if (quota != limiter.Quota{}) {
+			return &quota
+		}
+	}
+	return nil
+}
+
+func (r *RedisLimiterRepository) AccessEntries() []limiter.AccessEntry {
+	r, span := r.traced("AccessEntries")
+	defer span.End()
+
+	var allow, deny *redis.StringSliceCmd
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		allow = pipe.SMembers(r.ctx, generateKey(KEYSPACE_ACCESS, accessKey(limiter.ACCESS_ALLOW)))
+		deny = pipe.SMembers(r.ctx, generateKey(KEYSPACE_ACCESS, accessKey(limiter.ACCESS_DENY)))
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		return nil
+	}
+
+	entries := make([]limiter.AccessEntry, 0, len(allow.Val())+len(deny.Val()))
+	for _, value := range allow.Val() {
+		entries = append(entries, limiter.AccessEntry{Value: value, Access: limiter.ACCESS_ALLOW})
+	}
+	for _, value := range deny.Val() {
+		entries = append(entries, limiter.AccessEntry{Value: value, Access: limiter.ACCESS_DENY})
+	}
+	return entries
+}
+
+func (r *RedisLimiterRepository) Offense(id string) *limiter.Offense {
+	r, span := r.traced("Offense")
+	defer span.End()
+
+	res := r.getMap(KEYSPACE_OFFENSE, id)
+	if len(res) > 0 {
+		offense := mapToOffense(res)
+		if (offense != limiter.Offense{}) {
+			return &offense
+		}
+	}
+	return nil
+}
+
+func (r *RedisLimiterRepository) ConsumeRules(counters []limiter.RuleCounter, cost int) (int, time.Duration) {
+	r, span := r.traced("ConsumeRules")
+	defer span.End()
+
+	if len(counters) == 0 {
+		return -1, 0
+	}
+
+	keys := make([]string, 0, len(counters))
+	args := make([]interface{}, 0, len(counters)*2+1)
+	args = append(args, cost)
+	for _, c := range counters {
+		keys = append(keys, generateKey(KEYSPACE_RULE, ruleKey(c.ClientID, c.Rule)))
+		args = append(args, c.Rule.MaxRequests, c.Rule.Interval.Milliseconds())
+	}
+
+	res, err := consumeRulesScript.Run(r.ctx, r.redis, keys, args...).Int64Slice()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+
+	// a counter charged with a cost greater than its max requests has no ttl
+	resetIn := time.Duration(max(res[1], 0)) * time.Millisecond
+	return int(res[0]), resetIn
+}
+
+func (r *RedisLimiterRepository) AcquireSlots(lease limiter.Lease, ttl time.Duration) int {
+	r, span := r.traced("AcquireSlots")
+	defer span.End()
+
+	if len(lease.Slots) == 0 {
+		return -1
+	}
+
+	keys := make([]string, 0, len(lease.Slots))
+	args := make([]interface{}, 0, len(lease.Slots)+3)
+	args = append(args, lease.ID, r.clock.Now().UnixMilli(), ttl.Milliseconds())
+	for _, s := range lease.Slots {
+		keys = append(keys, generateKey(KEYSPACE_SLOT, s.ClientID))
+		args = append(args, s.MaxInFlight)
+	}
+
+	res, err := acquireSlotsScript.Run(r.ctx, r.redis, keys, args...).Int()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+	return res
+}
+
+func (r *RedisLimiterRepository) RenewSlots(lease limiter.Lease, ttl time.Duration) {
+	r, span := r.traced("RenewSlots")
+	defer span.End()
+
+	expiresAt := float64(r.clock.Now().Add(ttl).UnixMilli())
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, s := range lease.Slots {
+			key := generateKey(KEYSPACE_SLOT, s.ClientID)
+			pipe.ZAddXX(r.ctx, key, redis.Z{Score: expiresAt, Member: lease.ID})
+			pipe.PExpire(r.ctx, key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
+func (r *RedisLimiterRepository) ReleaseSlots(lease limiter.Lease) {
+	r, span := r.traced("ReleaseSlots")
+	defer span.End()
+
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, s := range lease.Slots {
+			pipe.ZRem(r.ctx, generateKey(KEYSPACE_SLOT, s.ClientID), lease.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
 func (r *RedisLimiterRepository) SaveApiKey(apiKey limiter.APIKey) {
+	r, span := r.traced("SaveApiKey")
+	defer span.End()
+
 	if apiKey.ID != "" {
-		r.saveMap(KEYSPACE_API_KEY, apiKey.ID, map[string]string{
+		apiKeyMap := map[string]string{
 			"id":          apiKey.ID,
 			"maxRequests": strconv.Itoa(apiKey.MaxRequests),
+		}
+		if apiKey.DailyQuota > 0 {
+			apiKeyMap["dailyQuota"] = strconv.Itoa(apiKey.DailyQuota)
+		}
+		if apiKey.MonthlyQuota > 0 {
+			apiKeyMap["monthlyQuota"] = strconv.Itoa(apiKey.MonthlyQuota)
+		}
+		if apiKey.Priority != limiter.PRIORITY_DEFAULT {
+			apiKeyMap["priority"] = strconv.Itoa(apiKey.Priority)
+		}
+
+		// replaces the whole hash so that unset quotas are removed
+		key := generateKey(KEYSPACE_API_KEY, apiKey.ID)
+		_, err := r.redis.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(r.ctx, key)
+			pipe.HSet(r.ctx, key, apiKeyMap)
+			return nil
 		})
+		if err != nil {
+			r.recordError(err)
+			panic(err)
+		}
 	}
 }
 
 func (r *RedisLimiterRepository) SaveClient(client limiter.Client) {
+	r, span := r.traced("SaveClient")
+	defer span.End()
+
 	if client.ID != "" {
 		r.saveMap(KEYSPACE_CLIENT, client.ID, map[string]string{
 			"id":              client.ID,
@@ -67,9 +348,211 @@ func (r *RedisLimiterRepository) SaveClient(client limiter.Client) {
 	}
 }
 
+// ConsumeQuotas checks and charges the quotas on a script, so that concurrent requests can not all pass the check
+// and exceed them
+func (r *RedisLimiterRepository) ConsumeQuotas(counters []limiter.QuotaCounter, cost int) (bool, []int) {
+	r, span := r.traced("ConsumeQuotas")
+	defer span.End()
+
+	if len(counters) == 0 {
+		return true, nil
+	}
+
+	keys := make([]string, 0, len(counters))
+	args := make([]interface{}, 0, len(counters)*5+1)
+	args = append(args, cost)
+	for _, c := range counters {
+		keys = append(keys, generateKey(KEYSPACE_QUOTA, quotaKey(c.Quota.ID, c.Quota.Period, c.Quota.Start)))
+		args = append(args, c.MaxRequests, c.Quota.ID, c.Quota.Period, c.Quota.Start.Unix(), c.Quota.ResetAt.Unix())
+	}
+
+	res, err := consumeQuotasScript.Run(r.ctx, r.redis, keys, args...).Int64Slice()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+
+	requests := make([]int, 0, len(counters))
+	for _, value := range res[1:] {
+		requests = append(requests, int(value))
+	}
+	return res[0] == 1, requests
+}
+
+func (r *RedisLimiterRepository) SaveOffense(offense limiter.Offense) {
+	r, span := r.traced("SaveOffense")
+	defer span.End()
+
+	if offense.ID != "" {
+		r.saveMap(KEYSPACE_OFFENSE, offense.ID, map[string]string{
+			"id":     offense.ID,
+			"count":  strconv.Itoa(offense.Count),
+			"lastAt": strconv.FormatInt(offense.LastAt.UnixMilli(), 10),
+		})
+
+		r.redis.Expire(r.ctx, generateKey(KEYSPACE_OFFENSE, offense.ID), offense.TTL)
+	}
+}
+
+func (r *RedisLimiterRepository) SaveAccessEntry(entry limiter.AccessEntry) {
+	r, span := r.traced("SaveAccessEntry")
+	defer span.End()
+
+	if entry.Value != "" {
+		err := r.redis.SAdd(r.ctx, generateKey(KEYSPACE_ACCESS, accessKey(entry.Access)), entry.Value).Err()
+		if err != nil {
+			r.recordError(err)
+			panic(err)
+		}
+	}
+}
+
+func (r *RedisLimiterRepository) DeleteAccessEntry(entry limiter.AccessEntry) {
+	r, span := r.traced("DeleteAccessEntry")
+	defer span.End()
+
+	err := r.redis.SRem(r.ctx, generateKey(KEYSPACE_ACCESS, accessKey(entry.Access)), entry.Value).Err()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
+func (r *RedisLimiterRepository) DeleteApiKey(id string) {
+	r, span := r.traced("DeleteApiKey")
+	defer span.End()
+
+	err := r.redis.Del(r.ctx, generateKey(KEYSPACE_API_KEY, id)).Err()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
+func (r *RedisLimiterRepository) DeleteClient(id string, rules []limiter.LimitRule) {
+	r, span := r.traced("DeleteClient")
+	defer span.End()
+
+	keys := []string{generateKey(KEYSPACE_CLIENT, id), generateKey(KEYSPACE_OFFENSE, id)}
+	for _, rule := range rules {
+		keys = append(keys, generateKey(KEYSPACE_RULE, ruleKey(id, rule)))
+	}
+	err := r.redis.Del(r.ctx, keys...).Err()
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
+// AddUsage adds the requests to the identity buckets, hashes, and to the rankings of the bucket identities,
+// sorted sets scored by their requests, all in a single pipeline
+func (r *RedisLimiterRepository) AddUsage(usages ...limiter.Usage) {
+	r, span := r.traced("AddUsage")
+	defer span.End()
+
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, usage := range usages {
+			if usage.ID == "" {
+				continue
+			}
+
+			key := generateKey(KEYSPACE_USAGE, usageKey(usage.Limiter, usage.Kind, usage.Granularity, usage.Start, usage.ID))
+			ranking := generateKey(KEYSPACE_USAGE_RANKING, usageRankingKey(usage.Limiter, usage.Kind, usage.Granularity, usage.Start))
+			pipe.HIncrBy(r.ctx, key, "allowed", int64(usage.Allowed))
+			pipe.HIncrBy(r.ctx, key, "refused", int64(usage.Refused))
+			pipe.HIncrBy(r.ctx, key, "cost", int64(usage.Cost))
+			pipe.Expire(r.ctx, key, usage.TTL)
+			pipe.ZIncrBy(r.ctx, ranking, float64(usage.Requests()), usage.ID)
+			pipe.Expire(r.ctx, ranking, usage.TTL)
+		}
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		panic(err)
+	}
+}
+
+func (r *RedisLimiterRepository) UsageHistory(query limiter.UsageQuery) []limiter.Usage {
+	r, span := r.traced("UsageHistory")
+	defer span.End()
+
+	return r.usageBuckets(query, []string{query.ID})
+}
+
+// TopUsage ranks the identities by the union of the bucket rankings, summing up the buckets of the top ones
+func (r *RedisLimiterRepository) TopUsage(query limiter.UsageQuery, n int) []limiter.Usage {
+	r, span := r.traced("TopUsage")
+	defer span.End()
+
+	starts := usageStarts(query)
+	if len(starts) == 0 || n <= 0 {
+		return nil
+	}
+
+	rankings := make([]string, 0, len(starts))
+	for _, start := range starts {
+		rankings = append(rankings, generateKey(KEYSPACE_USAGE_RANKING, usageRankingKey(query.Limiter, query.Kind, query.Granularity, start)))
+	}
+	ranked, err := r.redis.ZUnionWithScores(r.ctx, redis.ZStore{Keys: rankings}).Result()
+	if err != nil {
+		r.recordError(err)
+		return nil
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	ids := make([]string, 0, min(n, len(ranked)))
+	for _, z := range ranked[:min(n, len(ranked))] {
+		ids = append(ids, z.Member.(string))
+	}
+	return sumUsage(query, ids, r.usageBuckets(query, ids))
+}
+
+// usageBuckets returns the query buckets of the identities that have requests, the oldest first
+func (r *RedisLimiterRepository) usageBuckets(query limiter.UsageQuery, ids []string) []limiter.Usage {
+	starts := usageStarts(query)
+	if len(starts) == 0 || len(ids) == 0 {
+		return nil
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, 0, len(starts)*len(ids))
+	_, err := r.redis.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, start := range starts {
+			for _, id := range ids {
+				cmds = append(cmds, pipe.HGetAll(r.ctx, generateKey(KEYSPACE_USAGE, usageKey(query.Limiter, query.Kind, query.Granularity, start, id))))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.recordError(err)
+		return nil
+	}
+
+	var buckets []limiter.Usage
+	for i, cmd := range cmds {
+		res := cmd.Val()
+		if len(res) == 0 {
+			continue
+		}
+
+		usage := mapToUsage(res)
+		usage.Limiter = query.Limiter
+		usage.Kind = query.Kind
+		usage.ID = ids[i%len(ids)]
+		usage.Granularity = query.Granularity
+		usage.Start = starts[i/len(ids)]
+		buckets = append(buckets, usage)
+	}
+	return buckets
+}
+
 func (r *RedisLimiterRepository) getMap(keyspace, key string) map[string]string {
 	res, err := r.redis.HGetAll(r.ctx, generateKey(keyspace, key)).Result()
 	if err != nil {
+		r.recordError(err)
 		return nil
 	}
 
@@ -79,6 +562,7 @@ func (r *RedisLimiterRepository) getMap(keyspace, key string) map[string]string
 func (r *RedisLimiterRepository) saveMap(keyspace, key string, valueMap map[string]string) {
 	err := r.redis.HSet(r.ctx, generateKey(keyspace, key), valueMap).Err()
 	if err != nil {
+		r.recordError(err)
 		panic(err)
 	}
 }
@@ -87,15 +571,56 @@ func generateKey(keyspace, key string) string {
 	return fmt.Sprintf("%s:%s", keyspace, key)
 }
 
+func quotaKey(id string, period int, start time.Time) string {
+	return fmt.Sprintf("%s:%d:%d", id, period, start.Unix())
+}
+
+func usageKey(limiterName, kind string, granularity time.Duration, start time.Time, id string) string {
+	return fmt.Sprintf("%s:%s", usageRankingKey(limiterName, kind, granularity, start), id)
+}
+
+func usageRankingKey(limiterName, kind string, granularity time.Duration, start time.Time) string {
+	return fmt.Sprintf("%s:%s:%d:%d", limiterName, kind, int64(granularity.Seconds()), start.Unix())
+}
+
+func accessKey(access int) string {
+	if access == limiter.ACCESS_DENY {
+		return "deny"
+	}
+	return "allow"
+}
+
+func ruleKey(id string, rule limiter.LimitRule) string {
+	return fmt.Sprintf("%s:%d", id, rule.Interval.Milliseconds())
+}
+
 func mapToApiKey(res map[string]string) limiter.APIKey {
 	maxRequests, err := strconv.Atoi(res["maxRequests"])
 	if err != nil {
 		return limiter.APIKey{}
 	}
 
+	dailyQuota, err := optionalInt(res["dailyQuota"])
+	if err != nil {
+		return limiter.APIKey{}
+	}
+
+	monthlyQuota, err := optionalInt(res["monthlyQuota"])
+	if err != nil {
+		return limiter.APIKey{}
+	}
+
+	priority, err := optionalInt(res["priority"])
+	if err != nil {
+		return limiter.APIKey{}
+	}
+
 	return limiter.APIKey{
-		ID:          res["id"],
-		MaxRequests: maxRequests,
+		ID:           res["id"],
+		MaxRequests:  maxRequests,
+		DailyQuota:   dailyQuota,
+		MonthlyQuota: monthlyQuota,
+		Priority:     priority,
 	}
 }
 
@@ -116,3 +641,76 @@ func mapToClient(res map[string]string) limiter.Client {
 		Blocked:         blocked,
 	}
 }
+
+func mapToQuota(res map[string]string) limiter.Quota {
+	period, err := strconv.Atoi(res["period"])
+	if err != nil {
+		return limiter.Quota{}
+	}
+
+	start, err := strconv.ParseInt(res["start"], 10, 64)
+	if err != nil {
+		return limiter.Quota{}
+	}
+
+	requests, err := strconv.Atoi(res["requests"])
+	if err != nil {
+		return limiter.Quota{}
+	}
+
+	return limiter.Quota{
+		ID:       res["id"],
+		Period:   period,
+		Start:    time.Unix(start, 0),
+		Requests: requests,
+	}
+}
+
+func mapToOffense(res map[string]string) limiter.Offense {
+	count, err := strconv.Atoi(res["count"])
+	if err != nil {
+		return limiter.Offense{}
+	}
+
+	lastAt, err := strconv.ParseInt(res["lastAt"], 10, 64)
+	if err != nil {
+		return limiter.Offense{}
+	}
+
+	return limiter.Offense{
+		ID:     res["id"],
+		Count:  count,
+		LastAt: time.UnixMilli(lastAt),
+	}
+}
+
+func mapToUsage(res map[string]string) limiter.Usage {
+	allowed, err := optionalInt(res["allowed"])
+	if err != nil {
+		return limiter.Usage{}
+	}
+
+	refused, err := optionalInt(res["refused"])
+	if err != nil {
+		return limiter.Usage{}
+	}
+
+	cost, err := optionalInt(res["cost"])
+	if err != nil {
+		return limiter.Usage{}
+	}
+
+	return limiter.Usage{
+		Allowed: allowed,
+		Refused: refused,
+		Cost:    cost,
+	}
+}
+
+// optionalInt parses a map field that may not be set, returning 0 if so
+func optionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
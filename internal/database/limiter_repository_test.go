@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,6 +13,12 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RedisLimiterRepositoryTestSuite struct {
@@ -171,6 +179,7 @@ func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_Client(
 				suite.Equal(t.Expected.ID, client.ID)
 				suite.Equal(t.Expected.CurrentRequests, client.CurrentRequests)
 				suite.Equal(t.Expected.Blocked, client.Blocked)
+				suite.InDelta(t.Expected.TTL, client.TTL, float64(time.Millisecond*100))
 			} else {
 				suite.Nil(client)
 			}
@@ -325,3 +334,411 @@ func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_SaveCli
 		})
 	}
 }
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_Quota() {
+	start := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	testQuota := limiter.Quota{
+		ID:       "secretKey1",
+		Period:   limiter.QUOTA_MONTHLY,
+		Start:    start,
+		Requests: 42,
+	}
+
+	suite.RedisClient.HSet(
+		context.Background(),
+		fmt.Sprintf("%s:%s:%d:%d", database.KEYSPACE_QUOTA, testQuota.ID, testQuota.Period, start.Unix()),
+		map[string]string{
+			"id":       testQuota.ID,
+			"period":   strconv.Itoa(testQuota.Period),
+			"start":    strconv.FormatInt(start.Unix(), 10),
+			"requests": strconv.Itoa(testQuota.Requests),
+		})
+
+	suite.Run("Should return nil if quota does not exist", func() {
+		suite.Nil(suite.Repository.Quota("Inexistent clientID", limiter.QUOTA_MONTHLY, start))
+	})
+
+	suite.Run("Should return nil if quota exists for another period", func() {
+		suite.Nil(suite.Repository.Quota(testQuota.ID, limiter.QUOTA_DAILY, start))
+		suite.Nil(suite.Repository.Quota(testQuota.ID, limiter.QUOTA_MONTHLY, start.AddDate(0, 1, 0)))
+	})
+
+	suite.Run("Should return quota register of the client period", func() {
+		quota := suite.Repository.Quota(testQuota.ID, limiter.QUOTA_MONTHLY, start)
+		suite.NotNil(quota)
+		suite.Equal(testQuota.ID, quota.ID)
+		suite.Equal(testQuota.Period, quota.Period)
+		suite.True(testQuota.Start.Equal(quota.Start))
+		suite.Equal(testQuota.Requests, quota.Requests)
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_ConsumeQuotas() {
+	start := time.Now().Truncate(time.Second)
+	daily := limiter.QuotaCounter{
+		Quota:       limiter.Quota{ID: "192.168.0.1", Period: limiter.QUOTA_DAILY, Start: start, ResetAt: start.Add(time.Hour)},
+		MaxRequests: 5,
+	}
+	monthly := limiter.QuotaCounter{
+		Quota:       limiter.Quota{ID: "192.168.0.1", Period: limiter.QUOTA_MONTHLY, Start: start, ResetAt: start.Add(time.Hour * 24)},
+		MaxRequests: 100,
+	}
+	counters := []limiter.QuotaCounter{daily, monthly}
+	key := fmt.Sprintf("%s:%s:%d:%d", database.KEYSPACE_QUOTA, daily.Quota.ID, daily.Quota.Period, start.Unix())
+	monthlyKey := fmt.Sprintf("%s:%s:%d:%d", database.KEYSPACE_QUOTA, monthly.Quota.ID, monthly.Quota.Period, start.Unix())
+	suite.RedisClient.Del(context.Background(), key, monthlyKey)
+
+	suite.Run("Should charge every quota while none of them would be exceeded", func() {
+		charged, requests := suite.Repository.ConsumeQuotas(counters, 2)
+		suite.True(charged)
+		suite.Equal([]int{2, 2}, requests)
+
+		suite.Equal(map[string]string{
+			"id":       "192.168.0.1",
+			"period":   strconv.Itoa(limiter.QUOTA_DAILY),
+			"start":    strconv.FormatInt(start.Unix(), 10),
+			"requests": "2",
+		}, suite.RedisClient.HGetAll(context.Background(), key).Val())
+		suite.InDelta(time.Hour.Seconds(), suite.RedisClient.TTL(context.Background(), key).Val().Seconds(), 2)
+	})
+
+	suite.Run("Should not charge any quota if one of them would be exceeded", func() {
+		charged, requests := suite.Repository.ConsumeQuotas(counters, 4)
+		suite.False(charged)
+		suite.Equal([]int{2, 2}, requests)
+	})
+
+	suite.Run("Should not let concurrent requests exceed the quota", func() {
+		var wg sync.WaitGroup
+		var allowed atomic.Int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if charged, _ := suite.Repository.ConsumeQuotas(counters, 1); charged {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		suite.Equal(int32(3), allowed.Load())
+		suite.Equal("5", suite.RedisClient.HGet(context.Background(), key, "requests").Val())
+	})
+
+	suite.Run("Should refund the requests on a negative cost", func() {
+		charged, requests := suite.Repository.ConsumeQuotas(counters, -1)
+		suite.True(charged)
+		suite.Equal([]int{4, 4}, requests)
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_SaveApiKey_Quotas() {
+	apiKey := limiter.APIKey{
+		ID:           "SecretApiKey1",
+		MaxRequests:  10,
+		DailyQuota:   1000,
+		MonthlyQuota: 20000,
+	}
+	key := fmt.Sprintf("%s:%s", database.KEYSPACE_API_KEY, apiKey.ID)
+
+	suite.Run("Should save and return the API Key quotas", func() {
+		suite.Repository.SaveApiKey(apiKey)
+		suite.Equal(map[string]string{
+			"id":           "SecretApiKey1",
+			"maxRequests":  "10",
+			"dailyQuota":   "1000",
+			"monthlyQuota": "20000",
+		}, suite.RedisClient.HGetAll(context.Background(), key).Val())
+		suite.Equal(&apiKey, suite.Repository.ApiKey(apiKey.ID))
+	})
+
+	suite.Run("Should remove the quotas no longer set", func() {
+		apiKey.MonthlyQuota = 0
+		suite.Repository.SaveApiKey(apiKey)
+		suite.Equal(map[string]string{
+			"id":          "SecretApiKey1",
+			"maxRequests": "10",
+			"dailyQuota":  "1000",
+		}, suite.RedisClient.HGetAll(context.Background(), key).Val())
+		suite.Equal(&apiKey, suite.Repository.ApiKey(apiKey.ID))
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_SaveApiKey_Priority() {
+	apiKey := limiter.APIKey{
+		ID:          "SecretApiKey1",
+		MaxRequests: 10,
+		Priority:    limiter.PRIORITY_HIGH,
+	}
+	key := fmt.Sprintf("%s:%s", database.KEYSPACE_API_KEY, apiKey.ID)
+
+	suite.Run("Should save and return the API Key plan priority", func() {
+		suite.Repository.SaveApiKey(apiKey)
+		suite.Equal(strconv.Itoa(limiter.PRIORITY_HIGH), suite.RedisClient.HGet(context.Background(), key, "priority").Val())
+		suite.Equal(&apiKey, suite.Repository.ApiKey(apiKey.ID))
+	})
+
+	suite.Run("Should not save the default priority", func() {
+		apiKey.Priority = limiter.PRIORITY_DEFAULT
+		suite.Repository.SaveApiKey(apiKey)
+		suite.False(suite.RedisClient.HExists(context.Background(), key, "priority").Val())
+		suite.Equal(&apiKey, suite.Repository.ApiKey(apiKey.ID))
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_ConsumeRules() {
+	counters := []limiter.RuleCounter{
+		{ClientID: "192.168.0.1", Rule: limiter.LimitRule{MaxRequests: 3, Interval: time.Second}},
+		{ClientID: "192.168.0.1", Rule: limiter.LimitRule{MaxRequests: 2, Interval: time.Minute}},
+	}
+	counterValue := func(c limiter.RuleCounter) string {
+		return suite.RedisClient.Get(
+			context.Background(),
+			fmt.Sprintf("%s:%s:%d", database.KEYSPACE_RULE, c.ClientID, c.Rule.Interval.Milliseconds()),
+		).Val()
+	}
+
+	suite.Run("Should charge every counter while none of them reached its limit", func() {
+		for range 2 {
+			exceeded, resetIn := suite.Repository.ConsumeRules(counters, 1)
+			suite.Equal(-1, exceeded)
+			suite.Zero(resetIn)
+		}
+		suite.Equal("2", counterValue(counters[0]))
+		suite.Equal("2", counterValue(counters[1]))
+	})
+
+	suite.Run("Should return the exceeded counter and when it resets without charging any of them", func() {
+		exceeded, resetIn := suite.Repository.ConsumeRules(counters, 1)
+		suite.Equal(1, exceeded)
+		suite.InDelta(time.Minute.Seconds(), resetIn.Seconds(), 2)
+		suite.Equal("2", counterValue(counters[0]))
+		suite.Equal("2", counterValue(counters[1]))
+	})
+
+	suite.Run("Should charge the cost on every counter", func() {
+		other := []limiter.RuleCounter{
+			{ClientID: "192.168.0.2", Rule: limiter.LimitRule{MaxRequests: 10, Interval: time.Second}},
+			{ClientID: "192.168.0.2", Rule: limiter.LimitRule{MaxRequests: 5, Interval: time.Minute}},
+		}
+		exceeded, _ := suite.Repository.ConsumeRules(other, 4)
+		suite.Equal(-1, exceeded)
+		exceeded, _ = suite.Repository.ConsumeRules(other, 2)
+		suite.Equal(1, exceeded)
+		suite.Equal("4", counterValue(other[0]))
+		suite.Equal("4", counterValue(other[1]))
+	})
+
+	suite.Run("Should reset the counter after its interval", func() {
+		time.Sleep(time.Second * 2)
+		suite.Equal("", counterValue(counters[0]))
+		suite.Equal("2", counterValue(counters[1]))
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_DeleteClient() {
+	rule := limiter.LimitRule{MaxRequests: 1, Interval: time.Hour}
+	counters := []limiter.RuleCounter{{ClientID: "192.168.0.1", Rule: rule}}
+	suite.Repository.SaveClient(limiter.Client{ID: "192.168.0.1", Blocked: true, TTL: time.Minute})
+	suite.Repository.SaveOffense(limiter.Offense{ID: "192.168.0.1", Count: 1, LastAt: time.Now(), TTL: time.Hour})
+	exceeded, _ := suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(-1, exceeded)
+
+	suite.Repository.DeleteClient("192.168.0.1", []limiter.LimitRule{rule})
+	suite.Nil(suite.Repository.Client("192.168.0.1"))
+	suite.Nil(suite.Repository.Offense("192.168.0.1"))
+	exceeded, _ = suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(-1, exceeded)
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_AccessEntries() {
+	entries := []limiter.AccessEntry{
+		{Value: "10.0.0.1", Access: limiter.ACCESS_ALLOW},
+		{Value: "goexpert-key", Access: limiter.ACCESS_ALLOW},
+		{Value: "192.168.0.0/16", Access: limiter.ACCESS_DENY},
+	}
+
+	suite.Run("Should return no entries if none was saved", func() {
+		suite.Empty(suite.Repository.AccessEntries())
+	})
+
+	suite.Run("Should return the saved entries", func() {
+		for _, e := range entries {
+			suite.Repository.SaveAccessEntry(e)
+		}
+		suite.ElementsMatch(entries, suite.Repository.AccessEntries())
+		suite.ElementsMatch(
+			[]string{"10.0.0.1", "goexpert-key"},
+			suite.RedisClient.SMembers(context.Background(), database.KEYSPACE_ACCESS+":allow").Val(),
+		)
+	})
+
+	suite.Run("Should not return the deleted entries", func() {
+		suite.Repository.DeleteAccessEntry(entries[2])
+		suite.Repository.DeleteAccessEntry(limiter.AccessEntry{Value: "10.0.0.1", Access: limiter.ACCESS_DENY})
+		suite.ElementsMatch(entries[:2], suite.Repository.AccessEntries())
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_Offense() {
+	offense := limiter.Offense{
+		ID:     "192.168.0.1",
+		Count:  3,
+		LastAt: time.UnixMilli(time.Now().UnixMilli()),
+		TTL:    time.Second,
+	}
+
+	suite.Run("Should return nil if offense does not exist", func() {
+		suite.Nil(suite.Repository.Offense(offense.ID))
+	})
+
+	suite.Run("Should return the saved offense", func() {
+		suite.Repository.SaveOffense(offense)
+		saved := suite.Repository.Offense(offense.ID)
+		suite.NotNil(saved)
+		suite.Equal(offense.ID, saved.ID)
+		suite.Equal(offense.Count, saved.Count)
+		suite.True(offense.LastAt.Equal(saved.LastAt))
+	})
+
+	suite.Run("Should return nil after TTL expired", func() {
+		time.Sleep(time.Second * 2)
+		suite.Nil(suite.Repository.Offense(offense.ID))
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_Slots() {
+	slots := []limiter.Slot{
+		{ClientID: "192.168.0.1", MaxInFlight: 2},
+		{ClientID: "global", MaxInFlight: 3},
+	}
+	lease := func(id string, slots ...limiter.Slot) limiter.Lease {
+		return limiter.Lease{ID: id, Slots: slots}
+	}
+
+	suite.Run("Should lease slots until a counter is full, leasing none of the others", func() {
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("a", slots...), time.Minute))
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("b", slots...), time.Minute))
+		suite.Equal(0, suite.Repository.AcquireSlots(lease("c", slots...), time.Minute))
+		suite.Equal(int64(2), suite.RedisClient.ZCard(context.Background(), "slot:global").Val())
+
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("d", slots[1]), time.Minute))
+		suite.Equal(0, suite.Repository.AcquireSlots(lease("e", slots[1]), time.Minute))
+	})
+
+	suite.Run("Should free the slots of released leases", func() {
+		suite.Repository.ReleaseSlots(lease("a", slots...))
+		suite.Equal(int64(1), suite.RedisClient.ZCard(context.Background(), "slot:192.168.0.1").Val())
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("c", slots...), time.Minute))
+	})
+
+	suite.Run("Should free the slots of expired leases unless they are renewed", func() {
+		suite.RedisClient.FlushAll(context.Background())
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("a", slots[0]), time.Millisecond*300))
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("b", slots[0]), time.Millisecond*300))
+
+		time.Sleep(time.Millisecond * 200)
+		suite.Repository.RenewSlots(lease("a", slots[0]), time.Second)
+		time.Sleep(time.Millisecond * 200)
+
+		suite.Equal(-1, suite.Repository.AcquireSlots(lease("c", slots[0]), time.Minute))
+		suite.Equal(0, suite.Repository.AcquireSlots(lease("d", slots[0]), time.Minute))
+		suite.Equal(float64(0), suite.RedisClient.ZScore(context.Background(), "slot:192.168.0.1", "b").Val())
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_WithContext_Spans() {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(provider)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	repository := suite.Repository.WithContext(ctx)
+	repository.SaveClient(limiter.Client{ID: "192.168.0.1", CurrentRequests: 1, TTL: time.Second})
+	repository.Client("192.168.0.1")
+	parent.End()
+
+	spans := exporter.GetSpans()
+	suite.Len(spans, 3)
+	suite.Equal("RedisLimiterRepository.SaveClient", spans[0].Name)
+	suite.Equal("RedisLimiterRepository.Client", spans[1].Name)
+	for _, span := range spans[:2] {
+		suite.Equal(trace.SpanKindClient, span.SpanKind)
+		suite.Equal(parent.SpanContext().SpanID(), span.Parent.SpanID())
+		suite.Contains(span.Attributes, attribute.String("db.system", "redis"))
+	}
+
+	suite.Run("Should record the Redis errors on the span", func() {
+		exporter.Reset()
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		suite.Nil(suite.Repository.WithContext(canceled).Client("192.168.0.1"))
+		spans := exporter.GetSpans()
+		suite.Len(spans, 1)
+		suite.Equal(codes.Error, spans[0].Status.Code)
+	})
+}
+
+func (suite *RedisLimiterRepositoryTestSuite) TestRedisLimiterRepository_Usage() {
+	start := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	add := func(id string, minute, allowed, refused int) {
+		suite.Repository.AddUsage(limiter.Usage{
+			Kind:        limiter.DIMENSION_IP,
+			ID:          id,
+			Granularity: limiter.USAGE_MINUTE,
+			Start:       start.Add(time.Duration(minute) * time.Minute),
+			Allowed:     allowed,
+			Refused:     refused,
+			Cost:        allowed * 2,
+			TTL:         time.Hour,
+		})
+	}
+	add("192.168.0.1", 0, 1, 0)
+	add("192.168.0.1", 0, 1, 0)
+	add("192.168.0.1", 2, 0, 1)
+	add("192.168.0.2", 1, 5, 0)
+	add("192.168.0.3", 1, 1, 0)
+	query := limiter.UsageQuery{
+		Kind:        limiter.DIMENSION_IP,
+		Granularity: limiter.USAGE_MINUTE,
+		From:        start,
+		To:          start.Add(3 * time.Minute),
+	}
+
+	suite.Run("Should return the identity buckets with requests, the oldest first", func() {
+		query := query
+		query.ID = "192.168.0.1"
+		history := suite.Repository.UsageHistory(query)
+		suite.Equal([]limiter.Usage{
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.1", Granularity: limiter.USAGE_MINUTE, Start: start, Allowed: 2, Cost: 4},
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.1", Granularity: limiter.USAGE_MINUTE, Start: start.Add(2 * time.Minute), Refused: 1},
+		}, history)
+	})
+
+	suite.Run("Should not return the buckets of other limiters or out of the query", func() {
+		query := query
+		query.ID = "192.168.0.1"
+		query.Limiter = "reports"
+		suite.Empty(suite.Repository.UsageHistory(query))
+
+		query.Limiter = ""
+		query.From = start.Add(time.Minute)
+		suite.Len(suite.Repository.UsageHistory(query), 1)
+	})
+
+	suite.Run("Should return the identities with the most requests, summed up", func() {
+		top := suite.Repository.TopUsage(query, 2)
+		suite.Equal([]limiter.Usage{
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.2", Granularity: 3 * time.Minute, Start: start, Allowed: 5, Cost: 10},
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.1", Granularity: 3 * time.Minute, Start: start, Allowed: 2, Refused: 1, Cost: 4},
+		}, top)
+	})
+
+	suite.Run("Should expire the buckets after their ttl", func() {
+		suite.Positive(suite.RedisClient.TTL(context.Background(), "usage::ip:60:"+strconv.FormatInt(start.Unix(), 10)+":192.168.0.1").Val())
+		suite.Positive(suite.RedisClient.TTL(context.Background(), "usageRanking::ip:60:"+strconv.FormatInt(start.Unix(), 10)).Val())
+	})
+}
@@ -0,0 +1,366 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// expiring is a value that expires at a time of the repository clock, never if expiresAt is zero
+type expiring[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// MemoryLimiterRepository keeps the limiter data in memory, expiring it by its clock instead of the wall clock.
+// It behaves as the RedisLimiterRepository of a single instance, as to run the limiter on tests
+type MemoryLimiterRepository struct {
+	clock limiter.Clock
+
+	mu       sync.Mutex
+	apiKeys  map[string]limiter.APIKey
+	clients  map[string]expiring[limiter.Client]
+	quotas   map[string]expiring[limiter.Quota]
+	rules    map[string]expiring[int]
+	offenses map[string]expiring[limiter.Offense]
+	access   map[int]map[string]struct{}
+	slots    map[string]map[string]time.Time
+	usage    map[string]expiring[limiter.Usage]
+}
+
+func NewMemoryLimiterRepository(clock limiter.Clock) *MemoryLimiterRepository {
+	return &MemoryLimiterRepository{
+		clock:    clock,
+		apiKeys:  map[string]limiter.APIKey{},
+		clients:  map[string]expiring[limiter.Client]{},
+		quotas:   map[string]expiring[limiter.Quota]{},
+		rules:    map[string]expiring[int]{},
+		offenses: map[string]expiring[limiter.Offense]{},
+		access: map[int]map[string]struct{}{
+			limiter.ACCESS_ALLOW: {},
+			limiter.ACCESS_DENY:  {},
+		},
+		slots: map[string]map[string]time.Time{},
+		usage: map[string]expiring[limiter.Usage]{},
+	}
+}
+
+func (r *MemoryLimiterRepository) ApiKey(id string) *limiter.APIKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if apiKey, found := r.apiKeys[id]; found {
+		return &apiKey
+	}
+	return nil
+}
+
+func (r *MemoryLimiterRepository) Client(id string) *limiter.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if client, found := get(r, r.clients, id); found {
+		client.TTL = r.timeLeft(r.clients[id].expiresAt)
+		return &client
+	}
+	return nil
+}
+
+func (r *MemoryLimiterRepository) Quota(id string, period int, start time.Time) *limiter.Quota {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if quota, found := get(r, r.quotas, quotaKey(id, period, start)); found {
+		quota.ResetAt = time.Time{}
+		return &quota
+	}
+	return nil
+}
+
+func (r *MemoryLimiterRepository) AccessEntries() []limiter.AccessEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []limiter.AccessEntry
+	for _, access := range []int{limiter.ACCESS_ALLOW, limiter.ACCESS_DENY} {
+		values := make([]string, 0, len(r.access[access]))
+		for value := range r.access[access] {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		for _, value := range values {
+			entries = append(entries, limiter.AccessEntry{Value: value, Access: access})
+		}
+	}
+	return entries
+}
+
+func (r *MemoryLimiterRepository) Offense(id string) *limiter.Offense {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if offense, found := get(r, r.offenses, id); found {
+		offense.TTL = 0
+		return &offense
+	}
+	return nil
+}
+
+func (r *MemoryLimiterRepository) ConsumeRules(counters []limiter.RuleCounter, cost int) (int, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	for i, c := range counters {
+		key := ruleKey(c.ClientID, c.Rule)
+		current, _ := get(r, r.rules, key)
+		if current+cost > c.Rule.MaxRequests {
+			resetIn := time.Duration(0)
+			if counter, found := r.rules[key]; found && !counter.expiresAt.IsZero() {
+				resetIn = counter.expiresAt.Sub(now)
+			}
+			return i, max(resetIn, 0)
+		}
+	}
+
+	for _, c := range counters {
+		key := ruleKey(c.ClientID, c.Rule)
+		counter, found := r.rules[key]
+		if !found || r.expired(counter.expiresAt) {
+			counter = expiring[int]{expiresAt: now.Add(c.Rule.Interval)}
+		}
+		counter.value += cost
+		r.rules[key] = counter
+	}
+	return -1, 0
+}
+
+func (r *MemoryLimiterRepository) AcquireSlots(lease limiter.Lease, ttl time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	for i, s := range lease.Slots {
+		leases := r.slots[s.ClientID]
+		for id, expiresAt := range leases {
+			if !expiresAt.After(now) {
+				delete(leases, id)
+			}
+		}
+
+		if _, held := leases[lease.ID]; !held && len(leases) >= s.MaxInFlight {
+			return i
+		}
+	}
+
+	for _, s := range lease.Slots {
+		if r.slots[s.ClientID] == nil {
+			r.slots[s.ClientID] = map[string]time.Time{}
+		}
+		r.slots[s.ClientID][lease.ID] = now.Add(ttl)
+	}
+	return -1
+}
+
+func (r *MemoryLimiterRepository) RenewSlots(lease limiter.Lease, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt := r.clock.Now().Add(ttl)
+	for _, s := range lease.Slots {
+		if _, held := r.slots[s.ClientID][lease.ID]; held {
+			r.slots[s.ClientID][lease.ID] = expiresAt
+		}
+	}
+}
+
+func (r *MemoryLimiterRepository) ReleaseSlots(lease limiter.Lease) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range lease.Slots {
+		delete(r.slots[s.ClientID], lease.ID)
+	}
+}
+
+func (r *MemoryLimiterRepository) SaveApiKey(apiKey limiter.APIKey) {
+	if apiKey.ID != "" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.apiKeys[apiKey.ID] = apiKey
+	}
+}
+
+func (r *MemoryLimiterRepository) SaveClient(client limiter.Client) {
+	if client.ID != "" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.clients[client.ID] = expiring[limiter.Client]{value: client, expiresAt: r.clock.Now().Add(client.TTL)}
+	}
+}
+
+func (r *MemoryLimiterRepository) ConsumeQuotas(counters []limiter.QuotaCounter, cost int) (bool, []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	charged := true
+	requests := make([]int, 0, len(counters))
+	for _, c := range counters {
+		current, _ := get(r, r.quotas, quotaKey(c.Quota.ID, c.Quota.Period, c.Quota.Start))
+		if cost > 0 && current.Requests+cost > c.MaxRequests {
+			charged = false
+		}
+		requests = append(requests, current.Requests)
+	}
+	if !charged {
+		return false, requests
+	}
+
+	for i, c := range counters {
+		quota := c.Quota
+		quota.Requests = requests[i] + cost
+		requests[i] = quota.Requests
+		r.quotas[quotaKey(quota.ID, quota.Period, quota.Start)] = expiring[limiter.Quota]{value: quota, expiresAt: quota.ResetAt}
+	}
+	return true, requests
+}
+
+func (r *MemoryLimiterRepository) SaveOffense(offense limiter.Offense) {
+	if offense.ID != "" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.offenses[offense.ID] = expiring[limiter.Offense]{value: offense, expiresAt: r.clock.Now().Add(offense.TTL)}
+	}
+}
+
+func (r *MemoryLimiterRepository) SaveAccessEntry(entry limiter.AccessEntry) {
+	if entry.Value != "" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.access[entry.Access][entry.Value] = struct{}{}
+	}
+}
+
+func (r *MemoryLimiterRepository) DeleteAccessEntry(entry limiter.AccessEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.access[entry.Access], entry.Value)
+}
+
+func (r *MemoryLimiterRepository) DeleteApiKey(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.apiKeys, id)
+}
+
+func (r *MemoryLimiterRepository) DeleteClient(id string, rules []limiter.LimitRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, id)
+	delete(r.offenses, id)
+	for _, rule := range rules {
+		delete(r.rules, ruleKey(id, rule))
+	}
+}
+
+func (r *MemoryLimiterRepository) AddUsage(usages ...limiter.Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, usage := range usages {
+		if usage.ID == "" {
+			continue
+		}
+
+		key := usageKey(usage.Limiter, usage.Kind, usage.Granularity, usage.Start, usage.ID)
+		if bucket, found := get(r, r.usage, key); found {
+			usage.Allowed += bucket.Allowed
+			usage.Refused += bucket.Refused
+			usage.Cost += bucket.Cost
+		}
+		expiresAt := r.clock.Now().Add(usage.TTL)
+		usage.TTL = 0
+		r.usage[key] = expiring[limiter.Usage]{value: usage, expiresAt: expiresAt}
+	}
+}
+
+func (r *MemoryLimiterRepository) UsageHistory(query limiter.UsageQuery) []limiter.Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var buckets []limiter.Usage
+	for _, start := range usageStarts(query) {
+		if bucket, found := get(r, r.usage, usageKey(query.Limiter, query.Kind, query.Granularity, start, query.ID)); found {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+func (r *MemoryLimiterRepository) TopUsage(query limiter.UsageQuery, n int) []limiter.Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	starts := usageStarts(query)
+	if len(starts) == 0 || n <= 0 {
+		return nil
+	}
+
+	var buckets []limiter.Usage
+	requests := map[string]int{}
+	for key, v := range r.usage {
+		bucket := v.value
+		if bucket.Limiter != query.Limiter || bucket.Kind != query.Kind || bucket.Granularity != query.Granularity ||
+			bucket.Start.Before(starts[0]) || !bucket.Start.Before(query.To) {
+			continue
+		}
+		if _, found := get(r, r.usage, key); found {
+			buckets = append(buckets, bucket)
+			requests[bucket.ID] += bucket.Requests()
+		}
+	}
+
+	ids := make([]string, 0, len(requests))
+	for id := range requests {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if requests[ids[i]] != requests[ids[j]] {
+			return requests[ids[i]] > requests[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return sumUsage(query, ids[:min(n, len(ids))], buckets)
+}
+
+// expired reports whether a value expiring at expiresAt is expired, as a Redis key is once its ttl is over
+func (r *MemoryLimiterRepository) expired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !expiresAt.After(r.clock.Now())
+}
+
+// timeLeft returns the time left until expiresAt, 0 if it never expires
+func (r *MemoryLimiterRepository) timeLeft(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	return expiresAt.Sub(r.clock.Now())
+}
+
+// get returns the value of the key if it is not expired, deleting it otherwise
+func get[T any](r *MemoryLimiterRepository, values map[string]expiring[T], key string) (T, bool) {
+	v, found := values[key]
+	if !found {
+		var zero T
+		return zero, false
+	}
+
+	if r.expired(v.expiresAt) {
+		delete(values, key)
+		var zero T
+		return zero, false
+	}
+	return v.value, true
+}
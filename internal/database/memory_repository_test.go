@@ -0,0 +1,150 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+type MemoryLimiterRepositoryTestSuite struct {
+	suite.Suite
+	Clock      *limiter.FakeClock
+	Repository *database.MemoryLimiterRepository
+}
+
+func TestMemorySuite(t *testing.T) {
+	suite.Run(t, new(MemoryLimiterRepositoryTestSuite))
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) SetupTest() {
+	suite.Clock = limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Repository = database.NewMemoryLimiterRepository(suite.Clock)
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_Client() {
+	client := limiter.Client{ID: "192.168.0.1", CurrentRequests: 2, TTL: time.Second}
+	suite.Repository.SaveClient(client)
+
+	suite.Equal(&client, suite.Repository.Client(client.ID))
+
+	suite.Clock.Advance(time.Millisecond * 400)
+	client.TTL = time.Millisecond * 600
+	suite.Equal(&client, suite.Repository.Client(client.ID))
+
+	suite.Clock.Advance(time.Millisecond * 600)
+	suite.Nil(suite.Repository.Client(client.ID))
+	suite.Nil(suite.Repository.Client("inexistent"))
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_ConsumeRules() {
+	counters := []limiter.RuleCounter{
+		{ClientID: "192.168.0.1", Rule: limiter.LimitRule{MaxRequests: 3, Interval: time.Second}},
+		{ClientID: "192.168.0.1", Rule: limiter.LimitRule{MaxRequests: 4, Interval: time.Minute}},
+	}
+
+	exceeded, _ := suite.Repository.ConsumeRules(counters, 3)
+	suite.Equal(-1, exceeded)
+
+	suite.Clock.Advance(time.Millisecond * 200)
+	exceeded, resetIn := suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(0, exceeded)
+	suite.Equal(time.Millisecond*800, resetIn)
+
+	suite.Clock.Advance(resetIn)
+	exceeded, _ = suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(-1, exceeded)
+
+	exceeded, resetIn = suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(1, exceeded)
+	suite.Equal(time.Minute-time.Second, resetIn)
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_DeleteClient() {
+	rule := limiter.LimitRule{MaxRequests: 1, Interval: time.Hour}
+	counters := []limiter.RuleCounter{{ClientID: "192.168.0.1", Rule: rule}}
+	suite.Repository.SaveClient(limiter.Client{ID: "192.168.0.1", Blocked: true, TTL: time.Minute})
+	suite.Repository.SaveOffense(limiter.Offense{ID: "192.168.0.1", Count: 1, LastAt: suite.Clock.Now(), TTL: time.Hour})
+	exceeded, _ := suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(-1, exceeded)
+
+	suite.Repository.DeleteClient("192.168.0.1", []limiter.LimitRule{rule})
+	suite.Nil(suite.Repository.Client("192.168.0.1"))
+	suite.Nil(suite.Repository.Offense("192.168.0.1"))
+	exceeded, _ = suite.Repository.ConsumeRules(counters, 1)
+	suite.Equal(-1, exceeded)
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_Quota() {
+	start := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	quota := limiter.Quota{ID: "key", Period: limiter.QUOTA_DAILY, Start: start, ResetAt: start.AddDate(0, 0, 1)}
+	counters := []limiter.QuotaCounter{{Quota: quota, MaxRequests: 5}}
+	charged, requests := suite.Repository.ConsumeQuotas(counters, 2)
+	suite.True(charged)
+	suite.Equal([]int{2}, requests)
+	charged, requests = suite.Repository.ConsumeQuotas(counters, 4)
+	suite.False(charged)
+	suite.Equal([]int{2}, requests)
+	charged, requests = suite.Repository.ConsumeQuotas(counters, 3)
+	suite.True(charged)
+	suite.Equal([]int{5}, requests)
+
+	quota.Requests, quota.ResetAt = 5, time.Time{}
+	suite.Equal(&quota, suite.Repository.Quota("key", limiter.QUOTA_DAILY, start))
+	suite.Nil(suite.Repository.Quota("key", limiter.QUOTA_MONTHLY, start))
+
+	suite.Clock.Set(start.AddDate(0, 0, 1))
+	suite.Nil(suite.Repository.Quota("key", limiter.QUOTA_DAILY, start))
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_AccessEntries() {
+	suite.Repository.SaveAccessEntry(limiter.AccessEntry{Value: "10.0.0.0/8", Access: limiter.ACCESS_DENY})
+	suite.Repository.SaveAccessEntry(limiter.AccessEntry{Value: "partner-key", Access: limiter.ACCESS_ALLOW})
+	suite.Repository.SaveAccessEntry(limiter.AccessEntry{Value: "192.168.0.1", Access: limiter.ACCESS_ALLOW})
+	suite.Repository.DeleteAccessEntry(limiter.AccessEntry{Value: "partner-key", Access: limiter.ACCESS_ALLOW})
+
+	suite.Equal([]limiter.AccessEntry{
+		{Value: "192.168.0.1", Access: limiter.ACCESS_ALLOW},
+		{Value: "10.0.0.0/8", Access: limiter.ACCESS_DENY},
+	}, suite.Repository.AccessEntries())
+}
+
+func (suite *MemoryLimiterRepositoryTestSuite) TestMemoryLimiterRepository_Usage() {
+	start := suite.Clock.Now()
+	add := func(id string, minute, allowed int) {
+		suite.Repository.AddUsage(limiter.Usage{
+			Kind:        limiter.DIMENSION_API_KEY,
+			ID:          id,
+			Granularity: limiter.USAGE_MINUTE,
+			Start:       start.Add(time.Duration(minute) * time.Minute),
+			Allowed:     allowed,
+			Cost:        allowed,
+			TTL:         time.Hour,
+		})
+	}
+	add("key-1", 0, 1)
+	add("key-1", 1, 2)
+	add("key-2", 1, 4)
+	query := limiter.UsageQuery{
+		Kind:        limiter.DIMENSION_API_KEY,
+		ID:          "key-1",
+		Granularity: limiter.USAGE_MINUTE,
+		From:        start,
+		To:          start.Add(2 * time.Minute),
+	}
+
+	history := suite.Repository.UsageHistory(query)
+	suite.Len(history, 2)
+	suite.Equal(2, history[1].Allowed)
+
+	top := suite.Repository.TopUsage(query, 5)
+	suite.Len(top, 2)
+	suite.Equal("key-2", top[0].ID)
+	suite.Equal(3, top[1].Allowed)
+
+	suite.Clock.Advance(time.Hour)
+	suite.Empty(suite.Repository.UsageHistory(query))
+	suite.Empty(suite.Repository.TopUsage(query, 5))
+}
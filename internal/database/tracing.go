@@ -0,0 +1,33 @@
+package database
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/yamauthi/goexpert-rate-limiter/internal/database"
+
+// traced starts the span of a repository call,
+// returning a copy of the repository running the call within it
+func (r *RedisLimiterRepository) traced(operation string) (*RedisLimiterRepository, trace.Span) {
+	ctx, span := otel.Tracer(TRACER_NAME).Start(r.ctx, "RedisLimiterRepository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", operation),
+		),
+	)
+
+	repository := *r
+	repository.ctx = ctx
+	return &repository, span
+}
+
+// recordError records the error on the span of the running call
+func (r *RedisLimiterRepository) recordError(err error) {
+	span := trace.SpanFromContext(r.ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
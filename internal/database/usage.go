@@ -0,0 +1,53 @@
+package database
+
+import (
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// MAX_USAGE_BUCKETS is the most buckets of an identity a usage query reads, the latest ones
+const MAX_USAGE_BUCKETS = 10000
+
+// usageStarts returns when each bucket of the query starts, the oldest first
+func usageStarts(query limiter.UsageQuery) []time.Time {
+	if query.Granularity <= 0 || query.From.IsZero() || !query.From.Before(query.To) {
+		return nil
+	}
+
+	from := query.From.Truncate(query.Granularity)
+	if oldest := query.To.Add(-MAX_USAGE_BUCKETS * query.Granularity).Truncate(query.Granularity); from.Before(oldest) {
+		from = oldest
+	}
+
+	var starts []time.Time
+	for start := from; start.Before(query.To); start = start.Add(query.Granularity) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// sumUsage sums up the buckets of each identity, in the order of the ids, into a single usage spanning the query
+func sumUsage(query limiter.UsageQuery, ids []string, buckets []limiter.Usage) []limiter.Usage {
+	sums := make(map[string]*limiter.Usage, len(ids))
+	usages := make([]limiter.Usage, len(ids))
+	for i, id := range ids {
+		usages[i] = limiter.Usage{
+			Limiter:     query.Limiter,
+			Kind:        query.Kind,
+			ID:          id,
+			Granularity: query.To.Sub(query.From),
+			Start:       query.From,
+		}
+		sums[id] = &usages[i]
+	}
+
+	for _, bucket := range buckets {
+		if sum, found := sums[bucket.ID]; found {
+			sum.Allowed += bucket.Allowed
+			sum.Refused += bucket.Refused
+			sum.Cost += bucket.Cost
+		}
+	}
+	return usages
+}
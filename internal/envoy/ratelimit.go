@@ -0,0 +1,168 @@
+package envoy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Descriptor entry keys the identity of the request is taken from, as sent by the Envoy
+// remote_address and request_headers actions
+const (
+	IP_KEY      = "remote_address"
+	API_KEY_KEY = "api_key"
+)
+
+// Policy checks the descriptors of a domain that match it by its limiter
+type Policy struct {
+	Domain string
+
+	// Match is the entries a descriptor must have, by key, with the value or with any value if empty
+	Match map[string]string
+
+	Limiter *limiter.Limiter
+}
+
+// matches reports whether the descriptor of the domain has every entry of the policy
+func (p Policy) matches(domain string, entries map[string]string) bool {
+	if p.Domain != domain {
+		return false
+	}
+	for key, value := range p.Match {
+		entry, found := entries[key]
+		if !found || (value != "" && entry != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// RateLimitService is the Envoy rate limit service, deciding each descriptor by the first policy that matches it.
+// The IP and API Key of a descriptor are taken from its IP_KEY and API_KEY_KEY entries.
+// The descriptors of no policy are not limited
+type RateLimitService struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	Policies []Policy
+}
+
+func NewRateLimitService(policies ...Policy) *RateLimitService {
+	return &RateLimitService{
+		Policies: policies,
+	}
+}
+
+func (s *RateLimitService) Register(server *grpc.Server) {
+	rlsv3.RegisterRateLimitServiceServer(server, s)
+}
+
+// ShouldRateLimit decides every descriptor, the request being over the limit if any of them is.
+// Each descriptor is charged hits_addend requests, 1 if not set.
+// A failing repository answers Unavailable, leaving the request to the Envoy failure_mode_deny setting
+func (s *RateLimitService) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (res *rlsv3.RateLimitResponse, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			res, err = nil, status.Errorf(codes.Unavailable, "the rate limit could not be decided: %v", p)
+		}
+	}()
+
+	if req.GetDomain() == "" {
+		return nil, status.Error(codes.InvalidArgument, "the domain is required")
+	}
+
+	cost := max(int(req.GetHitsAddend()), 1)
+	res = &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	for _, descriptor := range req.GetDescriptors() {
+		descriptorStatus := s.decide(ctx, req.GetDomain(), descriptor, cost)
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			res.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		res.Statuses = append(res.Statuses, descriptorStatus)
+	}
+	return res, nil
+}
+
+// decide decides the descriptor by the limiter of its policy
+func (s *RateLimitService) decide(ctx context.Context, domain string, descriptor *ratelimitv3.RateLimitDescriptor, cost int) *rlsv3.RateLimitResponse_DescriptorStatus {
+	entries := make(map[string]string, len(descriptor.GetEntries()))
+	for _, e := range descriptor.GetEntries() {
+		entries[e.GetKey()] = e.GetValue()
+	}
+
+	policy := s.policy(domain, entries)
+	if policy == nil {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}
+	}
+
+	request := limiter.Request{
+		ClientID: entries[IP_KEY],
+		APIKeyID: entries[API_KEY_KEY],
+		Cost:     cost,
+	}
+	decision := policy.Limiter.DecideRequest(ctx, request)
+
+	descriptorStatus := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code:         rlsv3.RateLimitResponse_OK,
+		CurrentLimit: currentLimit(policy.Limiter, decision),
+	}
+	if !decision.Allowed {
+		descriptorStatus.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	if decision.Remaining > 0 {
+		descriptorStatus.LimitRemaining = uint32(decision.Remaining)
+	}
+
+	var limitErr *limiter.LimitError
+	if errors.As(decision.Err, &limitErr) && !limitErr.ResetAt.IsZero() {
+		descriptorStatus.DurationUntilReset = durationpb.New(max(time.Until(limitErr.ResetAt), 0))
+	}
+	return descriptorStatus
+}
+
+func (s *RateLimitService) policy(domain string, entries map[string]string) *Policy {
+	for i := range s.Policies {
+		if s.Policies[i].matches(domain, entries) {
+			return &s.Policies[i]
+		}
+	}
+	return nil
+}
+
+// currentLimit returns the requests limit the client was decided by, as scheduled or adapted.
+// It is nil if the limit has no Envoy unit, or the client was not limited
+func currentLimit(l *limiter.Limiter, decision limiter.Decision) *rlsv3.RateLimitResponse_RateLimit {
+	unit, found := rateLimitUnit(l.Config.RequestsLimitInterval)
+	if !found || decision.Limit <= 0 {
+		return nil
+	}
+
+	return &rlsv3.RateLimitResponse_RateLimit{
+		Name:            l.Config.Name,
+		RequestsPerUnit: uint32(decision.Limit),
+		Unit:            unit,
+	}
+}
+
+// rateLimitUnit returns the Envoy unit of the limit interval, only intervals of a single unit have one
+func rateLimitUnit(interval time.Duration) (rlsv3.RateLimitResponse_RateLimit_Unit, bool) {
+	switch interval {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND, true
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE, true
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR, true
+	case 24 * time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_DAY, true
+	default:
+		return rlsv3.RateLimitResponse_RateLimit_UNKNOWN, false
+	}
+}
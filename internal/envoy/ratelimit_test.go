@@ -0,0 +1,230 @@
+package envoy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/envoy"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const MaxRequests = 2
+const Domain = "edge"
+
+type RateLimitServiceTestSuite struct {
+	suite.Suite
+	Repository *database.MemoryLimiterRepository
+	Client     rlsv3.RateLimitServiceClient
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitServiceTestSuite))
+}
+
+// SetupTest serves the rate limit service, deciding the API Key and the public IP descriptors
+func (suite *RateLimitServiceTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Repository = database.NewMemoryLimiterRepository(clock)
+	suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 5})
+
+	ipLimiter := limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, suite.Repository).WithClock(clock)
+	apiKeyLimiter := limiter.NewLimiter(limiter.LimiterConfig{
+		Name:                  "api-key",
+		ClientCheckType:       limiter.CHECK_API_KEY_ONLY,
+		ClientBlockTime:       time.Minute,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, suite.Repository).WithClock(clock)
+
+	suite.Client = suite.serve(envoy.NewRateLimitService(
+		envoy.Policy{Domain: Domain, Match: map[string]string{envoy.API_KEY_KEY: ""}, Limiter: apiKeyLimiter},
+		envoy.Policy{Domain: Domain, Match: map[string]string{"generic_key": "public", envoy.IP_KEY: ""}, Limiter: ipLimiter},
+	))
+}
+
+// serve serves the service in process, returning a client calling it through an in memory listener
+func (suite *RateLimitServiceTestSuite) serve(service *envoy.RateLimitService) rlsv3.RateLimitServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	service.Register(server)
+	go server.Serve(listener)
+	suite.T().Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	suite.NoError(err)
+	suite.T().Cleanup(func() { conn.Close() })
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func descriptor(entries ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+	}
+	return d
+}
+
+func (suite *RateLimitServiceTestSuite) shouldRateLimit(hits uint32, descriptors ...*ratelimitv3.RateLimitDescriptor) *rlsv3.RateLimitResponse {
+	res, err := suite.Client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      Domain,
+		Descriptors: descriptors,
+		HitsAddend:  hits,
+	})
+	suite.NoError(err)
+	return res
+}
+
+func (suite *RateLimitServiceTestSuite) TestShouldRateLimit_IP() {
+	ip := descriptor("generic_key", "public", envoy.IP_KEY, "192.168.0.1")
+
+	suite.Run("Should allow the requests within the limit, telling the limit and what remains", func() {
+		res := suite.shouldRateLimit(0, ip)
+		suite.Equal(rlsv3.RateLimitResponse_OK, res.OverallCode)
+		suite.Len(res.Statuses, 1)
+		suite.Equal(uint32(MaxRequests-1), res.Statuses[0].LimitRemaining)
+		suite.Equal(uint32(MaxRequests), res.Statuses[0].CurrentLimit.RequestsPerUnit)
+		suite.Equal(rlsv3.RateLimitResponse_RateLimit_SECOND, res.Statuses[0].CurrentLimit.Unit)
+	})
+
+	suite.Run("Should be over the limit once the requests exceed it", func() {
+		suite.Equal(rlsv3.RateLimitResponse_OK, suite.shouldRateLimit(1, ip).OverallCode)
+
+		res := suite.shouldRateLimit(1, ip)
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, res.OverallCode)
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, res.Statuses[0].Code)
+		suite.Zero(res.Statuses[0].LimitRemaining)
+	})
+
+	suite.Run("Should charge the hits addend", func() {
+		other := descriptor("generic_key", "public", envoy.IP_KEY, "192.168.0.2")
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, suite.shouldRateLimit(MaxRequests+1, other).OverallCode)
+	})
+}
+
+func (suite *RateLimitServiceTestSuite) TestShouldRateLimit_APIKey() {
+	suite.Run("Should decide by the API Key limit from the repository", func() {
+		res := suite.shouldRateLimit(1, descriptor(envoy.API_KEY_KEY, "goexpert-key"))
+		suite.Equal(rlsv3.RateLimitResponse_OK, res.OverallCode)
+		suite.Equal(uint32(5), res.Statuses[0].CurrentLimit.RequestsPerUnit)
+		suite.Equal(uint32(4), res.Statuses[0].LimitRemaining)
+	})
+
+	suite.Run("Should be over the limit with an unknown API Key", func() {
+		res := suite.shouldRateLimit(1, descriptor(envoy.API_KEY_KEY, "unknown-key"))
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, res.OverallCode)
+	})
+}
+
+func (suite *RateLimitServiceTestSuite) TestShouldRateLimit_EffectiveLimit() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	schedule, err := limiter.ParseLimitSchedule("* 12 * * *|ip=1,api_key_factor=2", time.UTC)
+	suite.NoError(err)
+	conf := limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_OR_API_KEY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		Schedule:              schedule,
+	}
+
+	suite.Run("Should tell the scheduled limits of the IP and the API Key", func() {
+		scheduled := limiter.NewLimiter(conf, suite.Repository).WithClock(clock)
+		client := suite.serve(envoy.NewRateLimitService(envoy.Policy{Domain: Domain, Limiter: scheduled}))
+
+		res, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+			Domain: Domain,
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{
+				descriptor(envoy.IP_KEY, "192.168.0.1"),
+				descriptor(envoy.IP_KEY, "192.168.0.1", envoy.API_KEY_KEY, "goexpert-key"),
+			},
+		})
+		suite.NoError(err)
+		suite.Equal(uint32(1), res.Statuses[0].CurrentLimit.RequestsPerUnit)
+		suite.Equal(uint32(10), res.Statuses[1].CurrentLimit.RequestsPerUnit)
+	})
+
+	suite.Run("Should tell the limit of the adaptive controller", func() {
+		adaptive := limiter.NewLimiter(conf, suite.Repository).WithClock(clock).WithAdaptiveLimit(
+			limiter.NewAdaptiveController(limiter.AdaptiveConfig{MinLimit: 1, MaxLimit: 7, Window: time.Minute}),
+		)
+		client := suite.serve(envoy.NewRateLimitService(envoy.Policy{Domain: Domain, Limiter: adaptive}))
+
+		res, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+			Domain:      Domain,
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(envoy.IP_KEY, "192.168.0.2")},
+		})
+		suite.NoError(err)
+		suite.Equal(uint32(7), res.Statuses[0].CurrentLimit.RequestsPerUnit)
+	})
+}
+
+func (suite *RateLimitServiceTestSuite) TestShouldRateLimit_Descriptors() {
+	suite.Run("Should not limit the descriptors of no policy", func() {
+		res := suite.shouldRateLimit(100, descriptor("generic_key", "internal", envoy.IP_KEY, "192.168.0.1"))
+		suite.Equal(rlsv3.RateLimitResponse_OK, res.OverallCode)
+		suite.Nil(res.Statuses[0].CurrentLimit)
+	})
+
+	suite.Run("Should be over the limit if any descriptor is", func() {
+		res := suite.shouldRateLimit(MaxRequests+1,
+			descriptor(envoy.API_KEY_KEY, "goexpert-key"),
+			descriptor("generic_key", "public", envoy.IP_KEY, "192.168.0.3"),
+		)
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, res.OverallCode)
+		suite.Equal(rlsv3.RateLimitResponse_OK, res.Statuses[0].Code)
+		suite.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, res.Statuses[1].Code)
+	})
+
+	suite.Run("Should refuse a request without domain", func() {
+		_, err := suite.Client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{})
+		suite.Equal(codes.InvalidArgument, status.Code(err))
+	})
+}
+
+// failingRepository panics on the client calls, as the Redis repository does when Redis is down
+type failingRepository struct {
+	*database.MemoryLimiterRepository
+}
+
+func (r failingRepository) Client(id string) *limiter.Client {
+	panic(errors.New("connection refused"))
+}
+
+func (suite *RateLimitServiceTestSuite) TestShouldRateLimit_FailingRepository() {
+	suite.Run("Should answer Unavailable, leaving the request to the Envoy failure mode", func() {
+		failingLimiter := limiter.NewLimiter(limiter.LimiterConfig{
+			ClientCheckType:       limiter.CHECK_IP_ONLY,
+			ClientBlockTime:       time.Minute,
+			MaxIPRequests:         MaxRequests,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		}, failingRepository{suite.Repository})
+		client := suite.serve(envoy.NewRateLimitService(envoy.Policy{Domain: Domain, Limiter: failingLimiter}))
+
+		_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+			Domain:      Domain,
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(envoy.IP_KEY, "192.168.0.1")},
+		})
+		suite.Equal(codes.Unavailable, status.Code(err))
+	})
+}
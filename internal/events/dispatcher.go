@@ -0,0 +1,236 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// DEFAULT_BUFFER_SIZE is how many events wait to be sent to each sink if the dispatcher has no buffer size
+const DEFAULT_BUFFER_SIZE = 1024
+
+// DEFAULT_SEND_TIMEOUT is how long a sink is given to send an event, retries included
+const DEFAULT_SEND_TIMEOUT = time.Second * 30
+
+// Sink sends the limiter events somewhere, as to a webhook
+type Sink interface {
+	Send(ctx context.Context, event limiter.Event) error
+}
+
+// Dispatcher sends the events emitted by the limiters to every sink in the background,
+// so that the request path never waits for them. Events emitted while a sink buffer is full are dropped,
+// but for the sync sinks, that are sent every event as it is emitted, as the audit trail that must not miss any.
+// The end of every block is emitted as a EVENT_CLIENT_UNBLOCKED once the block expires,
+// unless the client is blocked again, whose block is the one awaited, or unblocked before
+type Dispatcher struct {
+	// SendTimeout is how long a sink is given to send an event
+	SendTimeout time.Duration
+
+	// Logger logs the events that could not be sent, slog.Default() if nil
+	Logger *slog.Logger
+
+	queues    []chan limiter.Event
+	sinks     []Sink
+	syncSinks []Sink
+	dropped   atomic.Int64
+	wg        sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	// timers awaits the end of the blocks, by blocked client
+	timersMu sync.Mutex
+	timers   map[string]*time.Timer
+}
+
+func NewDispatcher(bufferSize int, sinks ...Sink) *Dispatcher {
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_BUFFER_SIZE
+	}
+
+	d := &Dispatcher{
+		SendTimeout: DEFAULT_SEND_TIMEOUT,
+		sinks:       sinks,
+		timers:      map[string]*time.Timer{},
+	}
+	for _, sink := range sinks {
+		queue := make(chan limiter.Event, bufferSize)
+		d.queues = append(d.queues, queue)
+
+		d.wg.Add(1)
+		go d.send(sink, queue)
+	}
+	return d
+}
+
+// WithLogger sets the logger the events that could not be sent are logged by
+func (d *Dispatcher) WithLogger(logger *slog.Logger) *Dispatcher {
+	d.Logger = logger
+	return d
+}
+
+// WithSyncSinks sets the sinks sent every event as it is emitted, waiting for them so that none is dropped
+func (d *Dispatcher) WithSyncSinks(sinks ...Sink) *Dispatcher {
+	d.syncSinks = sinks
+	return d
+}
+
+// Emit queues the event to every sink, without waiting for it to be sent but to the sync sinks
+func (d *Dispatcher) Emit(event limiter.Event) {
+	switch {
+	case event.Type == limiter.EVENT_CLIENT_BLOCKED && !event.ExpiresAt.IsZero():
+		d.scheduleUnblock(event)
+	case event.Type == limiter.EVENT_CLIENT_UNBLOCKED:
+		d.cancelUnblock(event)
+	}
+	d.emit(event)
+}
+
+// emit queues the event to every sink
+func (d *Dispatcher) emit(event limiter.Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	for _, sink := range d.syncSinks {
+		d.sendTo(sink, event)
+	}
+	for _, queue := range d.queues {
+		select {
+		case queue <- event:
+		default:
+			d.dropped.Add(1)
+			d.logger().Warn("event dropped, sink buffer full", slog.String("type", event.Type), slog.String("limiter", event.Limiter))
+		}
+	}
+}
+
+// Dropped returns how many events were dropped because a sink buffer was full
+func (d *Dispatcher) Dropped() int64 {
+	return d.dropped.Load()
+}
+
+// Close stops emitting events, waiting until the queued ones are sent or the context is done.
+// Blocks that did not expire yet are not reported
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+
+	d.timersMu.Lock()
+	for _, timer := range d.timers {
+		timer.Stop()
+	}
+	d.timers = map[string]*time.Timer{}
+	d.timersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scheduleUnblock emits the end of the block once it expires, in place of the end of a previous block of the client
+func (d *Dispatcher) scheduleUnblock(blocked limiter.Event) {
+	unblocked := blocked
+	unblocked.Type = limiter.EVENT_CLIENT_UNBLOCKED
+	unblocked.At = blocked.ExpiresAt
+	unblocked.PenaltyLevel = 0
+	key := blockKey(blocked)
+
+	// the timer is only removed once it is stored, however soon it fires
+	d.timersMu.Lock()
+	defer d.timersMu.Unlock()
+	d.mu.RLock()
+	closed := d.closed
+	d.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	if previous, found := d.timers[key]; found {
+		previous.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(blocked.ExpiresAt), func() {
+		d.timersMu.Lock()
+		current := d.timers[key] == timer
+		if current {
+			delete(d.timers, key)
+		}
+		d.timersMu.Unlock()
+
+		// a timer that fired as the client was blocked again, or unblocked, is not the end of the block
+		if current {
+			d.emit(unblocked)
+		}
+	})
+	d.timers[key] = timer
+}
+
+// cancelUnblock stops awaiting the end of the block of a client that was unblocked before it expired
+func (d *Dispatcher) cancelUnblock(unblocked limiter.Event) {
+	key := blockKey(unblocked)
+
+	d.timersMu.Lock()
+	defer d.timersMu.Unlock()
+	if timer, found := d.timers[key]; found {
+		timer.Stop()
+		delete(d.timers, key)
+	}
+}
+
+// blockKey identifies the blocked client of the event as its limiter counts it, an IP, an API Key or both
+func blockKey(event limiter.Event) string {
+	id := event.ClientID
+	switch event.Dimension {
+	case limiter.DIMENSION_API_KEY:
+		id = event.APIKeyID
+	case limiter.DIMENSION_API_KEY_IP:
+		id = event.APIKeyID + "@" + event.ClientID
+	}
+	return event.Limiter + ":" + id
+}
+
+func (d *Dispatcher) send(sink Sink, queue <-chan limiter.Event) {
+	defer d.wg.Done()
+	for event := range queue {
+		d.sendTo(sink, event)
+	}
+}
+
+// sendTo sends the event to the sink within the send timeout, logging it if it could not be sent
+func (d *Dispatcher) sendTo(sink Sink, event limiter.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.SendTimeout)
+	defer cancel()
+	if err := sink.Send(ctx, event); err != nil {
+		d.logger().Error("event not sent", slog.String("type", event.Type), slog.String("limiter", event.Limiter), slog.String("error", err.Error()))
+	}
+}
+
+func (d *Dispatcher) logger() *slog.Logger {
+	if d.Logger == nil {
+		return slog.Default()
+	}
+	return d.Logger
+}
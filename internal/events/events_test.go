@@ -0,0 +1,293 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/events"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// RecordingSink records the events sent, waiting for release before sending if set
+type RecordingSink struct {
+	mu      sync.Mutex
+	events  []limiter.Event
+	release chan struct{}
+}
+
+func (s *RecordingSink) Send(ctx context.Context, event limiter.Event) error {
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *RecordingSink) Events() []limiter.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]limiter.Event(nil), s.events...)
+}
+
+type EventsTestSuite struct {
+	suite.Suite
+	Event limiter.Event
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
+
+func (suite *EventsTestSuite) SetupTest() {
+	suite.Event = limiter.Event{
+		Type:      limiter.EVENT_CLIENT_BLOCKED,
+		Limiter:   "ip",
+		ClientID:  "192.168.0.1",
+		At:        time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+}
+
+func (suite *EventsTestSuite) TestDispatcher_Emit() {
+	suite.Run("Should send the events to every sink", func() {
+		first, second := &RecordingSink{}, &RecordingSink{}
+		dispatcher := events.NewDispatcher(10, first, second)
+
+		dispatcher.Emit(suite.Event)
+		suite.NoError(dispatcher.Close(context.Background()))
+
+		suite.Equal([]limiter.Event{suite.Event}, first.Events())
+		suite.Equal([]limiter.Event{suite.Event}, second.Events())
+	})
+
+	suite.Run("Should not wait for a slow sink, dropping the events over its buffer", func() {
+		slow := &RecordingSink{release: make(chan struct{})}
+		dispatcher := events.NewDispatcher(1, slow)
+
+		emitted := make(chan struct{})
+		go func() {
+			for i := 0; i < 5; i++ {
+				dispatcher.Emit(suite.Event)
+			}
+			close(emitted)
+		}()
+
+		select {
+		case <-emitted:
+		case <-time.After(time.Second):
+			suite.Fail("Emit waited for the sink")
+		}
+		suite.GreaterOrEqual(dispatcher.Dropped(), int64(3))
+
+		close(slow.release)
+		suite.NoError(dispatcher.Close(context.Background()))
+		suite.Equal(int64(5), dispatcher.Dropped()+int64(len(slow.Events())))
+	})
+
+	suite.Run("Should wait for the sync sinks, never dropping their events", func() {
+		slow := &RecordingSink{release: make(chan struct{})}
+		synced := &RecordingSink{}
+		dispatcher := events.NewDispatcher(1, slow).WithSyncSinks(synced)
+
+		for i := 0; i < 5; i++ {
+			dispatcher.Emit(suite.Event)
+			suite.Len(synced.Events(), i+1)
+		}
+		suite.GreaterOrEqual(dispatcher.Dropped(), int64(3))
+
+		close(slow.release)
+		suite.NoError(dispatcher.Close(context.Background()))
+		suite.Len(synced.Events(), 5)
+	})
+
+	suite.Run("Should emit the unblock once the block expires", func() {
+		sink := &RecordingSink{}
+		dispatcher := events.NewDispatcher(10, sink)
+
+		suite.Event.ExpiresAt = time.Now().Add(time.Millisecond * 50)
+		dispatcher.Emit(suite.Event)
+		suite.Eventually(func() bool { return len(sink.Events()) == 2 }, time.Second, time.Millisecond*10)
+		suite.NoError(dispatcher.Close(context.Background()))
+
+		unblocked := sink.Events()[1]
+		suite.Equal(limiter.EVENT_CLIENT_UNBLOCKED, unblocked.Type)
+		suite.Equal("192.168.0.1", unblocked.ClientID)
+		suite.Equal(suite.Event.ExpiresAt, unblocked.At)
+	})
+
+	suite.Run("Should only emit the end of the last block of a client blocked again", func() {
+		sink := &RecordingSink{}
+		dispatcher := events.NewDispatcher(10, sink)
+
+		suite.Event.ExpiresAt = time.Now().Add(time.Millisecond * 50)
+		dispatcher.Emit(suite.Event)
+		reblocked := suite.Event
+		reblocked.ExpiresAt = time.Now().Add(time.Millisecond * 150)
+		dispatcher.Emit(reblocked)
+
+		time.Sleep(time.Millisecond * 100)
+		suite.Len(sink.Events(), 2)
+		suite.Eventually(func() bool { return len(sink.Events()) == 3 }, time.Second, time.Millisecond*10)
+		suite.NoError(dispatcher.Close(context.Background()))
+
+		unblocked := sink.Events()[2]
+		suite.Equal(limiter.EVENT_CLIENT_UNBLOCKED, unblocked.Type)
+		suite.Equal(reblocked.ExpiresAt, unblocked.At)
+	})
+
+	suite.Run("Should not emit the end of a block lifted before it expired", func() {
+		sink := &RecordingSink{}
+		dispatcher := events.NewDispatcher(10, sink)
+
+		suite.Event.ExpiresAt = time.Now().Add(time.Millisecond * 50)
+		dispatcher.Emit(suite.Event)
+		other := suite.Event
+		other.ClientID = "192.168.0.2"
+		dispatcher.Emit(other)
+		lifted := limiter.Event{Type: limiter.EVENT_CLIENT_UNBLOCKED, Limiter: "ip", ClientID: "192.168.0.1", Dimension: limiter.DIMENSION_IP, At: time.Now()}
+		dispatcher.Emit(lifted)
+
+		suite.Eventually(func() bool { return len(sink.Events()) == 4 }, time.Second, time.Millisecond*10)
+		time.Sleep(time.Millisecond * 100)
+		suite.NoError(dispatcher.Close(context.Background()))
+
+		sent := sink.Events()
+		suite.Len(sent, 4)
+		suite.Equal(lifted, sent[2])
+		suite.Equal(limiter.EVENT_CLIENT_UNBLOCKED, sent[3].Type)
+		suite.Equal("192.168.0.2", sent[3].ClientID)
+	})
+
+	suite.Run("Should not emit after closing", func() {
+		sink := &RecordingSink{}
+		dispatcher := events.NewDispatcher(10, sink)
+
+		suite.Event.ExpiresAt = time.Now().Add(time.Millisecond * 50)
+		dispatcher.Emit(suite.Event)
+		suite.NoError(dispatcher.Close(context.Background()))
+		dispatcher.Emit(suite.Event)
+
+		time.Sleep(time.Millisecond * 100)
+		suite.Len(sink.Events(), 1)
+	})
+}
+
+func (suite *EventsTestSuite) TestWebhookSink_Send() {
+	suite.Run("Should post the signed event", func() {
+		var body []byte
+		var header http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			header = r.Header
+		}))
+		defer server.Close()
+
+		err := events.NewWebhookSink(server.URL, "s3cret").Send(context.Background(), suite.Event)
+		suite.NoError(err)
+
+		var event limiter.Event
+		suite.NoError(json.Unmarshal(body, &event))
+		suite.Equal(limiter.EVENT_CLIENT_BLOCKED, event.Type)
+		suite.Equal(limiter.EVENT_CLIENT_BLOCKED, header.Get(events.EVENT_TYPE_HEADER))
+		suite.True(events.VerifySignature("s3cret", body, header.Get(events.SIGNATURE_HEADER)))
+		suite.False(events.VerifySignature("other", body, header.Get(events.SIGNATURE_HEADER)))
+	})
+
+	suite.Run("Should retry the failed posts", func() {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		sink := events.NewWebhookSink(server.URL, "").WithRetries(3, time.Millisecond)
+		suite.NoError(sink.Send(context.Background(), suite.Event))
+		suite.Equal(int32(3), attempts.Load())
+	})
+
+	suite.Run("Should give up after the max retries", func() {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		sink := events.NewWebhookSink(server.URL, "").WithRetries(2, time.Millisecond)
+		suite.ErrorIs(sink.Send(context.Background(), suite.Event), events.ErrWebhookRejected)
+		suite.Equal(int32(3), attempts.Load())
+	})
+
+	suite.Run("Should not retry the rejected events", func() {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		sink := events.NewWebhookSink(server.URL, "").WithRetries(3, time.Millisecond)
+		suite.ErrorIs(sink.Send(context.Background(), suite.Event), events.ErrWebhookRejected)
+		suite.Equal(int32(1), attempts.Load())
+	})
+}
+
+func (suite *EventsTestSuite) TestFileSink_Send() {
+	path := filepath.Join(suite.T().TempDir(), "events.log")
+	sink, err := events.NewFileSink(path)
+	suite.NoError(err)
+
+	suite.NoError(sink.Send(context.Background(), suite.Event))
+	suite.Event.Type = limiter.EVENT_CLIENT_UNBLOCKED
+	suite.NoError(sink.Send(context.Background(), suite.Event))
+	suite.NoError(sink.Close())
+
+	content, err := os.ReadFile(path)
+	suite.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	suite.Len(lines, 2)
+	suite.Contains(lines[0], `"type":"client.blocked"`)
+	suite.Contains(lines[1], `"type":"client.unblocked"`)
+}
+
+func (suite *EventsTestSuite) TestRedisSink_Send() {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "redis-passw0rd",
+	})
+	defer client.Close()
+
+	subscription := client.Subscribe(context.Background(), "limiter-events")
+	defer subscription.Close()
+	_, err := subscription.Receive(context.Background())
+	suite.NoError(err)
+
+	suite.NoError(events.NewRedisSink(client, "limiter-events").Send(context.Background(), suite.Event))
+
+	select {
+	case message := <-subscription.Channel():
+		var event limiter.Event
+		suite.NoError(json.Unmarshal([]byte(message.Payload), &event))
+		suite.Equal(limiter.EVENT_CLIENT_BLOCKED, event.Type)
+		suite.Equal("192.168.0.1", event.ClientID)
+	case <-time.After(time.Second):
+		suite.Fail("the event was not published")
+	}
+}
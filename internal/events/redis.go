@@ -0,0 +1,30 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// RedisSink publishes every event as JSON on a Redis pub/sub channel
+type RedisSink struct {
+	Redis   *redis.Client
+	Channel string
+}
+
+func NewRedisSink(redisClient *redis.Client, channel string) *RedisSink {
+	return &RedisSink{
+		Redis:   redisClient,
+		Channel: channel,
+	}
+}
+
+func (s *RedisSink) Send(ctx context.Context, event limiter.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.Redis.Publish(ctx, s.Channel, body).Err()
+}
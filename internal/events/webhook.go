@@ -0,0 +1,122 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// SIGNATURE_HEADER carries the HMAC-SHA256 of the webhook body, as `sha256=<hex>`
+const SIGNATURE_HEADER = "X-Limiter-Signature"
+
+// EVENT_TYPE_HEADER carries the type of the event on the webhook body
+const EVENT_TYPE_HEADER = "X-Limiter-Event"
+
+const DEFAULT_WEBHOOK_RETRIES = 3
+const DEFAULT_WEBHOOK_BACKOFF = time.Millisecond * 500
+
+var ErrWebhookRejected = errors.New("the webhook rejected the event")
+
+// WebhookSink posts every event as JSON to a URL, signed with the secret.
+// Failed posts are retried with exponential backoff, unless the webhook rejects the event
+type WebhookSink struct {
+	URL    string
+	Secret string
+
+	// MaxRetries is how many times a failed post is retried
+	MaxRetries int
+
+	// Backoff is how long the first retry waits, each next one waiting twice as long
+	Backoff time.Duration
+
+	Client *http.Client
+}
+
+func NewWebhookSink(url, secret string) *WebhookSink {
+	return &WebhookSink{
+		URL:        url,
+		Secret:     secret,
+		MaxRetries: DEFAULT_WEBHOOK_RETRIES,
+		Backoff:    DEFAULT_WEBHOOK_BACKOFF,
+		Client:     &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// WithRetries sets how many times a failed post is retried and how long the first retry waits
+func (s *WebhookSink) WithRetries(maxRetries int, backoff time.Duration) *WebhookSink {
+	s.MaxRetries = maxRetries
+	s.Backoff = backoff
+	return s
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event limiter.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, event.Type, body)
+		if err == nil || !retry || attempt >= s.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// post posts the body once, reporting whether a failed post is worth retrying
+func (s *WebhookSink) post(ctx context.Context, eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_TYPE_HEADER, eventType)
+	if s.Secret != "" {
+		req.Header.Set(SIGNATURE_HEADER, Sign(s.Secret, body))
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("%w: status %d", ErrWebhookRejected, res.StatusCode)
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
+
+// Sign returns the signature of the body, as sent on the SIGNATURE_HEADER
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether the signature was made from the body with the secret, in constant time
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
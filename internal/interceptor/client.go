@@ -0,0 +1,78 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DEFAULT_MAX_RETRIES = 3
+const DEFAULT_MAX_RETRY_DELAY = 5 * time.Second
+
+// RetryInterceptor retries the unary calls refused with codes.ResourceExhausted once the server tells they can be made again.
+// Calls refused without retry info, or told to wait longer than MaxDelay, fail right away
+type RetryInterceptor struct {
+	MaxRetries int
+	MaxDelay   time.Duration
+}
+
+func NewRetryInterceptor() *RetryInterceptor {
+	return &RetryInterceptor{
+		MaxRetries: DEFAULT_MAX_RETRIES,
+		MaxDelay:   DEFAULT_MAX_RETRY_DELAY,
+	}
+}
+
+// WithMaxRetries sets how many times a call is retried
+func (i *RetryInterceptor) WithMaxRetries(maxRetries int) *RetryInterceptor {
+	i.MaxRetries = maxRetries
+	return i
+}
+
+// WithMaxDelay sets the longest the call waits to be retried
+func (i *RetryInterceptor) WithMaxDelay(maxDelay time.Duration) *RetryInterceptor {
+	i.MaxDelay = maxDelay
+	return i
+}
+
+func (i *RetryInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		for retry := 0; retry < i.MaxRetries; retry++ {
+			delay, ok := RetryDelay(err)
+			if !ok || delay > i.MaxDelay {
+				return err
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			err = invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return err
+	}
+}
+
+// RetryDelay returns how long until a call refused with codes.ResourceExhausted can be made again,
+// false if the error does not tell
+func RetryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok && retryInfo.GetRetryDelay() != nil {
+			return retryInfo.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package interceptor_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/interceptor"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const MaxRequests = 2
+
+type InterceptorTestSuite struct {
+	suite.Suite
+	Clock  *limiter.FakeClock
+	Client healthpb.HealthClient
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(InterceptorTestSuite))
+}
+
+// serve serves the health service in process with the options, returning a connection to it
+func (suite *InterceptorTestSuite) serve(serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(serverOpts...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	suite.T().Cleanup(server.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOpts...)
+	suite.NoError(err)
+	suite.T().Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// SetupTest serves the health service limited by IP or API Key
+func (suite *InterceptorTestSuite) SetupTest() {
+	suite.Clock = limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	repository := database.NewMemoryLimiterRepository(suite.Clock)
+	repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 5})
+
+	limiterInterceptor := interceptor.NewLimiterInterceptor(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_OR_API_KEY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, repository).WithClock(suite.Clock))
+
+	suite.Client = healthpb.NewHealthClient(suite.serve([]grpc.ServerOption{
+		grpc.UnaryInterceptor(limiterInterceptor.Unary()),
+		grpc.StreamInterceptor(limiterInterceptor.Stream()),
+	}))
+}
+
+// check calls the unary health check, returning the remaining requests answered
+func (suite *InterceptorTestSuite) check(ctx context.Context) (string, error) {
+	var header metadata.MD
+	_, err := suite.Client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	return strings.Join(header.Get(interceptor.REMAINING_METADATA), ","), err
+}
+
+func (suite *InterceptorTestSuite) TestUnary_IP() {
+	ctx := context.Background()
+	for i := MaxRequests - 1; i >= 0; i-- {
+		remaining, err := suite.check(ctx)
+		suite.NoError(err)
+		suite.Equal(strconv.Itoa(i), remaining)
+	}
+
+	_, err := suite.check(ctx)
+	suite.Equal(codes.ResourceExhausted, status.Code(err))
+	delay, ok := interceptor.RetryDelay(err)
+	suite.True(ok)
+	suite.Equal(time.Minute, delay)
+
+	suite.Clock.Advance(time.Minute)
+	_, err = suite.check(ctx)
+	suite.NoError(err)
+}
+
+func (suite *InterceptorTestSuite) TestUnary_APIKey() {
+	for _, md := range []metadata.MD{
+		metadata.Pairs(interceptor.API_KEY_METADATA, "goexpert-key"),
+		metadata.Pairs(interceptor.AUTHORIZATION_METADATA, interceptor.BEARER_PREFIX+"goexpert-key"),
+	} {
+		remaining, err := suite.check(metadata.NewOutgoingContext(context.Background(), md))
+		suite.NoError(err)
+		suite.NotEmpty(remaining)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), interceptor.API_KEY_METADATA, "goexpert-key")
+	for i := 0; i < 3; i++ {
+		_, err := suite.check(ctx)
+		suite.NoError(err)
+	}
+	_, err := suite.check(ctx)
+	suite.Equal(codes.ResourceExhausted, status.Code(err))
+}
+
+func (suite *InterceptorTestSuite) TestUnary_UnknownAPIKey() {
+	limiterInterceptor := interceptor.NewLimiterInterceptor(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_API_KEY_ONLY,
+		ClientBlockTime:       time.Minute,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(suite.Clock)).WithClock(suite.Clock))
+	client := healthpb.NewHealthClient(suite.serve([]grpc.ServerOption{grpc.UnaryInterceptor(limiterInterceptor.Unary())}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), interceptor.API_KEY_METADATA, "unknown-key")
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+}
+
+func (suite *InterceptorTestSuite) TestStream() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < MaxRequests; i++ {
+		stream, err := suite.Client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		suite.NoError(err)
+		response, err := stream.Recv()
+		suite.NoError(err)
+		suite.Equal(healthpb.HealthCheckResponse_SERVING, response.GetStatus())
+	}
+
+	stream, err := suite.Client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	suite.NoError(err)
+	_, err = stream.Recv()
+	suite.Equal(codes.ResourceExhausted, status.Code(err))
+	_, ok := interceptor.RetryDelay(err)
+	suite.True(ok)
+}
+
+// failingRepository panics on the client calls, as the Redis repository does when Redis is down
+type failingRepository struct {
+	*database.MemoryLimiterRepository
+}
+
+func (r failingRepository) Client(id string) *limiter.Client {
+	panic(errors.New("connection refused"))
+}
+
+func (suite *InterceptorTestSuite) TestFailingRepository() {
+	limiterInterceptor := interceptor.NewLimiterInterceptor(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, failingRepository{database.NewMemoryLimiterRepository(suite.Clock)}).WithClock(suite.Clock))
+	client := healthpb.NewHealthClient(suite.serve([]grpc.ServerOption{
+		grpc.UnaryInterceptor(limiterInterceptor.Unary()),
+		grpc.StreamInterceptor(limiterInterceptor.Stream()),
+	}))
+
+	suite.Run("Should answer the unary calls with Unavailable", func() {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		suite.Equal(codes.Unavailable, status.Code(err))
+	})
+
+	suite.Run("Should answer the streams with Unavailable", func() {
+		stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+		suite.NoError(err)
+		_, err = stream.Recv()
+		suite.Equal(codes.Unavailable, status.Code(err))
+	})
+}
+
+// refuse returns an interceptor refusing the first calls with the retry delay, counting the calls
+func refuse(refusals int, delay time.Duration, calls *atomic.Int32) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if int(calls.Add(1)) <= refusals {
+			st, _ := status.New(codes.ResourceExhausted, "limit reached").
+				WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+			return nil, st.Err()
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (suite *InterceptorTestSuite) TestRetryInterceptor() {
+	retry := interceptor.NewRetryInterceptor().WithMaxDelay(time.Second)
+
+	testCases := []struct {
+		Name      string
+		Refusals  int
+		Delay     time.Duration
+		Calls     int32
+		ErrorCode codes.Code
+	}{
+		{Name: "Retried", Refusals: 2, Delay: time.Millisecond * 10, Calls: 3, ErrorCode: codes.OK},
+		{Name: "Too many refusals", Refusals: 5, Delay: time.Millisecond * 10, Calls: 4, ErrorCode: codes.ResourceExhausted},
+		{Name: "Delay over the max", Refusals: 1, Delay: time.Minute, Calls: 1, ErrorCode: codes.ResourceExhausted},
+	}
+
+	for _, tc := range testCases {
+		suite.Run(tc.Name, func() {
+			var calls atomic.Int32
+			client := healthpb.NewHealthClient(suite.serve(
+				[]grpc.ServerOption{grpc.UnaryInterceptor(refuse(tc.Refusals, tc.Delay, &calls))},
+				grpc.WithUnaryInterceptor(retry.Unary()),
+			))
+
+			_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			suite.Equal(tc.ErrorCode, status.Code(err))
+			suite.Equal(tc.Calls, calls.Load())
+		})
+	}
+}
+
+func (suite *InterceptorTestSuite) TestRetryInterceptor_ContextDone() {
+	var calls atomic.Int32
+	client := healthpb.NewHealthClient(suite.serve(
+		[]grpc.ServerOption{grpc.UnaryInterceptor(refuse(1, time.Second, &calls))},
+		grpc.WithUnaryInterceptor(interceptor.NewRetryInterceptor().Unary()),
+	))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	suite.Equal(codes.ResourceExhausted, status.Code(err))
+	suite.Equal(int32(1), calls.Load())
+}
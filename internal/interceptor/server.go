@@ -0,0 +1,147 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Metadata keys the API Key is taken from, `authorization` as `Bearer <API Key>`
+const (
+	API_KEY_METADATA       = "api-key"
+	AUTHORIZATION_METADATA = "authorization"
+	BEARER_PREFIX          = "Bearer "
+)
+
+// REMAINING_METADATA is the header metadata telling how many requests the client can still make
+const REMAINING_METADATA = "x-ratelimit-remaining"
+
+// LimiterInterceptor limits the calls of a gRPC server, identifying the client by its peer address and API Key.
+// Unary calls are each checked, streams are checked once as they are opened
+type LimiterInterceptor struct {
+	Limiter *limiter.Limiter
+}
+
+func NewLimiterInterceptor(limiter *limiter.Limiter) *LimiterInterceptor {
+	return &LimiterInterceptor{
+		Limiter: limiter,
+	}
+}
+
+func (i *LimiterInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		header, err := i.decide(ctx)
+		if header != nil {
+			grpc.SetHeader(ctx, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (i *LimiterInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		header, err := i.decide(ss.Context())
+		if header != nil {
+			ss.SetHeader(header)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// decide checks the call, returning the header metadata to answer with
+// and the status error of the refused calls. A failing repository answers codes.Unavailable
+func (i *LimiterInterceptor) decide(ctx context.Context) (header metadata.MD, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			header, err = nil, status.Errorf(codes.Unavailable, "the rate limit could not be decided: %v", p)
+		}
+	}()
+
+	decision := i.Limiter.DecideRequest(ctx, limiter.Request{
+		ClientID: PeerIP(ctx),
+		APIKeyID: APIKey(ctx),
+		Cost:     1,
+	})
+
+	if decision.Remaining >= 0 && !decision.Shadowed {
+		header = metadata.Pairs(REMAINING_METADATA, strconv.Itoa(decision.Remaining))
+	}
+	if decision.Allowed {
+		return header, nil
+	}
+	return header, statusError(decision.Err, i.Limiter.RetryDelay(decision.Err))
+}
+
+// statusError returns the status of the refusal, limits being answered with codes.ResourceExhausted
+// along with the delay until the client can call again, if it ever can
+func statusError(err error, delay time.Duration) error {
+	switch {
+	case errors.Is(err, limiter.ErrClientDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case limiter.IsLimitError(err):
+		st := status.New(codes.ResourceExhausted, err.Error())
+		if delay > 0 {
+			retryInfo := &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}
+			if detailed, detailsErr := st.WithDetails(retryInfo); detailsErr == nil {
+				st = detailed
+			}
+		}
+		return st.Err()
+	case errors.Is(err, limiter.ErrApiKeyNotFound):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, limiter.ErrInvalidClient),
+		errors.Is(err, limiter.ErrInvalidCost),
+		errors.Is(err, limiter.ErrCostExceedsLimit):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// PeerIP returns the IP of the peer of the call, empty if unknown
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// APIKey returns the API Key of the call, from the api-key metadata or from a bearer authorization
+func APIKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get(API_KEY_METADATA); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	if values := md.Get(AUTHORIZATION_METADATA); len(values) > 0 && strings.HasPrefix(values[0], BEARER_PREFIX) {
+		return strings.TrimSpace(strings.TrimPrefix(values[0], BEARER_PREFIX))
+	}
+	return ""
+}
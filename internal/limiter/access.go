@@ -0,0 +1,215 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessList holds the allowlist and denylist entries of the repository in memory,
+// so that clients are checked without a repository round trip
+type AccessList struct {
+	Repository LimiterRepositoryInterface
+
+	// Logger logs the invalid entries, slog.Default() if nil
+	Logger *slog.Logger
+
+	mu    sync.RWMutex
+	allow accessSet
+	deny  accessSet
+}
+
+// accessSet is a set of API Keys and IP prefixes
+type accessSet struct {
+	apiKeys map[string]struct{}
+	ips     *prefixTrie
+}
+
+func NewAccessList(repository LimiterRepositoryInterface) *AccessList {
+	return &AccessList{
+		Repository: repository,
+		allow:      newAccessSet(),
+		deny:       newAccessSet(),
+	}
+}
+
+func newAccessSet() accessSet {
+	return accessSet{
+		apiKeys: map[string]struct{}{},
+		ips:     &prefixTrie{},
+	}
+}
+
+// WithLogger sets the logger the invalid entries are logged by
+func (a *AccessList) WithLogger(logger *slog.Logger) *AccessList {
+	a.Logger = logger
+	return a
+}
+
+// Check returns whether the client is allowlisted or denylisted, denylist entries prevailing.
+// It reports false if the client is on neither
+func (a *AccessList) Check(clientID, apiKeyID string) (int, bool) {
+	addr, err := netip.ParseAddr(clientID)
+	hasAddr := err == nil
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.deny.contains(addr, hasAddr, apiKeyID) {
+		return ACCESS_DENY, true
+	}
+	if a.allow.contains(addr, hasAddr, apiKeyID) {
+		return ACCESS_ALLOW, true
+	}
+	return 0, false
+}
+
+// Reload replaces the entries in memory by the ones on the repository
+func (a *AccessList) Reload() {
+	allow, deny := newAccessSet(), newAccessSet()
+	for _, entry := range a.Repository.AccessEntries() {
+		set := &allow
+		if entry.Access == ACCESS_DENY {
+			set = &deny
+		}
+
+		if err := set.add(entry.Value); err != nil {
+			orDefaultLogger(a.Logger).Warn("access list entry ignored", slog.String("entry", entry.Value), slog.String("error", err.Error()))
+		}
+	}
+
+	a.mu.Lock()
+	a.allow, a.deny = allow, deny
+	a.mu.Unlock()
+}
+
+// Watch reloads the entries every interval until the context is done,
+// picking up the changes made by other instances
+func (a *AccessList) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Reload()
+		}
+	}
+}
+
+// Entries returns the entries on the repository
+func (a *AccessList) Entries() []AccessEntry {
+	return a.Repository.AccessEntries()
+}
+
+// Save validates and stores the entry, applying it right away
+func (a *AccessList) Save(entry AccessEntry) error {
+	if entry.Access != ACCESS_ALLOW && entry.Access != ACCESS_DENY {
+		return fmt.Errorf("%w: unknown access %v", ErrInvalidAccessEntry, entry.Access)
+	}
+
+	entry.Value = strings.TrimSpace(entry.Value)
+	if err := newAccessSet().add(entry.Value); err != nil {
+		return err
+	}
+
+	a.Repository.SaveAccessEntry(entry)
+	a.Reload()
+	return nil
+}
+
+// Delete removes the entry, applying it right away
+func (a *AccessList) Delete(entry AccessEntry) {
+	a.Repository.DeleteAccessEntry(entry)
+	a.Reload()
+}
+
+// add adds a single IP, a CIDR or, if the value is neither, an API Key to the set
+func (s accessSet) add(value string) error {
+	if value == "" {
+		return fmt.Errorf("%w: empty value", ErrInvalidAccessEntry)
+	}
+
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAccessEntry, err.Error())
+		}
+		s.ips.insert(prefix)
+		return nil
+	}
+
+	if addr, err := netip.ParseAddr(value); err == nil {
+		s.ips.insert(netip.PrefixFrom(addr, addr.BitLen()))
+		return nil
+	}
+
+	s.apiKeys[value] = struct{}{}
+	return nil
+}
+
+func (s accessSet) contains(addr netip.Addr, hasAddr bool, apiKeyID string) bool {
+	if hasAddr && s.ips.contains(addr) {
+		return true
+	}
+
+	_, found := s.apiKeys[apiKeyID]
+	return apiKeyID != "" && found
+}
+
+// prefixTrie is a binary trie of IP prefixes, IPv4 ones stored as IPv4-mapped IPv6.
+// Looking an address up costs at most 128 steps, however many prefixes there are
+type prefixTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	terminal bool
+}
+
+func (t *prefixTrie) insert(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	bytes := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+
+	node := &t.root
+	for i := 0; i < bits; i++ {
+		bit := bitAt(bytes, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+}
+
+// contains reports whether any prefix of the trie contains the address
+func (t *prefixTrie) contains(addr netip.Addr) bool {
+	bytes := addr.As16()
+	node := &t.root
+	for i := 0; i < 128; i++ {
+		if node.terminal {
+			return true
+		}
+
+		node = node.children[bitAt(bytes, i)]
+		if node == nil {
+			return false
+		}
+	}
+	return node.terminal
+}
+
+func bitAt(bytes [16]byte, i int) int {
+	return int(bytes[i/8]>>(7-i%8)) & 1
+}
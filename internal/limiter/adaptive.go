@@ -0,0 +1,155 @@
+package limiter
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+type AdaptiveConfig struct {
+	// Name identifies the controller on the exposed limits, as the route it controls
+	Name string
+
+	// MinLimit and MaxLimit bound the effective limit, that starts at MaxLimit
+	MinLimit int
+	MaxLimit int
+
+	// TargetLatency is the mean handler latency above which the limit decreases
+	TargetLatency time.Duration
+
+	// MaxErrorRate is the rate of 5xx responses, from 0 to 1, above which the limit decreases
+	MaxErrorRate float64
+
+	// Window is how often the observed requests are evaluated
+	Window time.Duration
+
+	// IncreaseStep is added to the limit of a healthy window, 1 if 0
+	IncreaseStep int
+
+	// DecreaseFactor multiplies the limit of an unhealthy window, 0.5 if 0
+	DecreaseFactor float64
+}
+
+// AdaptiveController scales a limit between its bounds by additive increase and multiplicative decrease (AIMD),
+// from the latency and errors of the requests handled
+type AdaptiveController struct {
+	Config AdaptiveConfig
+	Clock  Clock
+
+	// Logger logs the limit changes, slog.Default() if nil
+	Logger *slog.Logger
+
+	mu           sync.Mutex
+	limit        float64
+	windowStart  time.Time
+	requests     int
+	errors       int
+	totalLatency time.Duration
+}
+
+func NewAdaptiveController(conf AdaptiveConfig) *AdaptiveController {
+	c := &AdaptiveController{
+		Config: conf,
+		Clock:  SystemClock{},
+		limit:  float64(conf.MaxLimit),
+	}
+	c.windowStart = c.Clock.Now()
+	return c
+}
+
+// WithLogger sets the logger the limit changes are logged by
+func (c *AdaptiveController) WithLogger(logger *slog.Logger) *AdaptiveController {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Logger = logger
+	return c
+}
+
+// WithClock sets the clock the windows are timed by, starting a new window
+func (c *AdaptiveController) WithClock(clock Clock) *AdaptiveController {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Clock = clock
+	c.windowStart = clock.Now()
+	return c
+}
+
+// Limit returns the current effective limit
+func (c *AdaptiveController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// Observe records a handled request, evaluating the window once it is over
+func (c *AdaptiveController) Observe(latency time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests++
+	c.totalLatency += latency
+	if failed {
+		c.errors++
+	}
+
+	if c.Clock.Now().Sub(c.windowStart) >= c.Config.Window {
+		c.evaluate()
+	}
+}
+
+// Evaluate scales the limit from the requests observed since the last evaluation and starts a new window
+func (c *AdaptiveController) Evaluate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evaluate()
+}
+
+func (c *AdaptiveController) evaluate() {
+	defer c.reset()
+	if c.requests == 0 {
+		return
+	}
+
+	meanLatency := c.totalLatency / time.Duration(c.requests)
+	errorRate := float64(c.errors) / float64(c.requests)
+	previous := int(c.limit)
+
+	if (c.Config.TargetLatency > 0 && meanLatency > c.Config.TargetLatency) ||
+		(c.Config.MaxErrorRate > 0 && errorRate > c.Config.MaxErrorRate) {
+		c.limit = math.Max(math.Floor(c.limit*c.decreaseFactor()), float64(c.Config.MinLimit))
+	} else {
+		c.limit = math.Min(c.limit+float64(c.increaseStep()), float64(c.Config.MaxLimit))
+	}
+
+	if int(c.limit) != previous {
+		orDefaultLogger(c.Logger).Info("adaptive limit scaled",
+			slog.String("controller", c.Config.Name),
+			slog.Duration("mean_latency", meanLatency),
+			slog.Float64("error_rate", errorRate),
+			slog.Int("previous_limit", previous),
+			slog.Int("limit", int(c.limit)),
+		)
+	}
+}
+
+func (c *AdaptiveController) reset() {
+	c.windowStart = c.Clock.Now()
+	c.requests = 0
+	c.errors = 0
+	c.totalLatency = 0
+}
+
+func (c *AdaptiveController) increaseStep() int {
+	if c.Config.IncreaseStep > 0 {
+		return c.Config.IncreaseStep
+	}
+	return 1
+}
+
+func (c *AdaptiveController) decreaseFactor() float64 {
+	if c.Config.DecreaseFactor > 0 && c.Config.DecreaseFactor < 1 {
+		return c.Config.DecreaseFactor
+	}
+	return 0.5
+}
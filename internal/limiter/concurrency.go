@@ -0,0 +1,202 @@
+package limiter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DEFAULT_LEASE_TTL is how long a slot is leased if the concurrency limiter has no LeaseTTL
+const DEFAULT_LEASE_TTL = time.Second * 30
+
+type ConcurrencyConfig struct {
+	// Name identifies the limiter, limiters with the same name share their slots
+	Name string
+
+	// ClientCheckType is how clients are identified, as on LimiterConfig.
+	// CHECK_GLOBAL limits the requests of all clients together only
+	ClientCheckType int
+
+	// MaxInFlight is the max simultaneous requests of a client
+	MaxInFlight int
+
+	// MaxGlobalInFlight is the max simultaneous requests of all clients together, 0 means unlimited
+	MaxGlobalInFlight int
+
+	// LeaseTTL is how long a slot is held without being renewed,
+	// releasing the slots of requests whose instance crashed
+	LeaseTTL time.Duration
+}
+
+// ConcurrencyLimiter limits the requests in flight, rather than the requests made within an interval
+type ConcurrencyLimiter struct {
+	Config     ConcurrencyConfig
+	Repository ConcurrencyRepositoryInterface
+
+	// AccessList allows or denies clients before any slot is leased, if set
+	AccessList *AccessList
+
+	// Logger logs the clients reaching their limit, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+func NewConcurrencyLimiter(
+	conf ConcurrencyConfig,
+	repository ConcurrencyRepositoryInterface,
+) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		Config:     conf,
+		Repository: repository,
+	}
+}
+
+// WithAccessList sets the allowlist and denylist checked before any slot is leased
+func (c *ConcurrencyLimiter) WithAccessList(accessList *AccessList) *ConcurrencyLimiter {
+	c.AccessList = accessList
+	return c
+}
+
+// WithLogger sets the logger the clients reaching their limit are logged by
+func (c *ConcurrencyLimiter) WithLogger(logger *slog.Logger) *ConcurrencyLimiter {
+	c.Logger = logger
+	return c
+}
+
+// Acquire leases a slot for the request, that must be released once it is done.
+// Denied clients are refused and allowed ones get a lease without slots, as on the limiter.
+// API Keys are looked up as on the limiter, so that unknown keys neither get slots of their own
+// nor exhaust the slots of others, the IP being limited instead if the check type allows it.
+// A full slot is refused with a MIN_RETRY_DELAY retry hint, as slots are released any time
+func (c *ConcurrencyLimiter) Acquire(clientID, apiKeyID string) (*Lease, error) {
+	id, err := newLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AccessList != nil {
+		if access, found := c.AccessList.Check(clientID, apiKeyID); found {
+			if access == ACCESS_DENY {
+				return nil, ErrClientDenied
+			}
+			return &Lease{ID: id}, nil
+		}
+	}
+
+	slots, dimensions, err := c.slots(clientID, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := Lease{ID: id, Slots: slots}
+	full := c.Repository.AcquireSlots(lease, c.LeaseTTL())
+	if full >= 0 {
+		orDefaultLogger(c.Logger).Info("requests in flight limit reached",
+			slog.String("limiter", c.Config.Name),
+			slog.String("dimension", dimensions[full]),
+			slog.Int("max_in_flight", slots[full].MaxInFlight),
+		)
+		resetAt := time.Now().Add(MIN_RETRY_DELAY)
+		if dimensions[full] == DIMENSION_GLOBAL {
+			return nil, &LimitError{Err: ErrGlobalLimitReached, ResetAt: resetAt, Dimension: DIMENSION_GLOBAL}
+		}
+		return nil, &LimitError{Err: ErrMaxConcurrentRequests, ResetAt: resetAt, Dimension: dimensions[full]}
+	}
+	return &lease, nil
+}
+
+// Renew extends the lease of a request that is still in flight
+func (c *ConcurrencyLimiter) Renew(lease *Lease) {
+	if len(lease.Slots) > 0 {
+		c.Repository.RenewSlots(*lease, c.LeaseTTL())
+	}
+}
+
+// Release frees the slots of a request that is done
+func (c *ConcurrencyLimiter) Release(lease *Lease) {
+	if len(lease.Slots) > 0 {
+		c.Repository.ReleaseSlots(*lease)
+	}
+}
+
+// LeaseTTL returns how long a slot is held without being renewed
+func (c *ConcurrencyLimiter) LeaseTTL() time.Duration {
+	if c.Config.LeaseTTL > 0 {
+		return c.Config.LeaseTTL
+	}
+	return DEFAULT_LEASE_TTL
+}
+
+// slots returns the slots the request must lease, along with the dimension each one limits
+func (c *ConcurrencyLimiter) slots(clientID, apiKeyID string) ([]Slot, []string, error) {
+	var slots []Slot
+	var dimensions []string
+	add := func(dimension, id string, maxInFlight int) {
+		slots = append(slots, Slot{ClientID: c.slotID(id), MaxInFlight: maxInFlight})
+		dimensions = append(dimensions, dimension)
+	}
+
+	switch c.Config.ClientCheckType {
+	case CHECK_IP_ONLY:
+		if clientID == "" {
+			return nil, nil, ErrInvalidClient
+		}
+		add(DIMENSION_IP, clientID, c.Config.MaxInFlight)
+	case CHECK_API_KEY_ONLY:
+		apiKey := c.apiKey(apiKeyID)
+		if apiKey == nil {
+			return nil, nil, ErrApiKeyNotFound
+		}
+		add(DIMENSION_API_KEY, apiKey.ID, c.Config.MaxInFlight)
+	case CHECK_IP_AND_API_KEY:
+		if clientID == "" {
+			return nil, nil, ErrInvalidClient
+		}
+		apiKey := c.apiKey(apiKeyID)
+		if apiKey == nil {
+			return nil, nil, ErrApiKeyNotFound
+		}
+		add(DIMENSION_API_KEY, apiKey.ID, c.Config.MaxInFlight)
+		add(DIMENSION_IP, clientID, c.Config.MaxInFlight)
+	case CHECK_GLOBAL:
+	default: // CHECK_IP_OR_API_KEY
+		if apiKey := c.apiKey(apiKeyID); apiKey != nil {
+			add(DIMENSION_API_KEY, apiKey.ID, c.Config.MaxInFlight)
+			break
+		}
+		if clientID == "" {
+			return nil, nil, ErrInvalidClient
+		}
+		add(DIMENSION_IP, clientID, c.Config.MaxInFlight)
+	}
+
+	if c.Config.MaxGlobalInFlight > 0 {
+		add(DIMENSION_GLOBAL, "global", c.Config.MaxGlobalInFlight)
+	}
+	return slots, dimensions, nil
+}
+
+// apiKey returns the API Key of the request, nil if it was not sent or is not found
+func (c *ConcurrencyLimiter) apiKey(apiKeyID string) *APIKey {
+	if apiKeyID == "" {
+		return nil
+	}
+	return c.Repository.ApiKey(apiKeyID)
+}
+
+// slotID returns the ID the client slots are counted by, apart for each named limiter
+func (c *ConcurrencyLimiter) slotID(id string) string {
+	if c.Config.Name == "" {
+		return id
+	}
+	return fmt.Sprintf("%s:%s", c.Config.Name, id)
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
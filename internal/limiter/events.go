@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+)
+
+// WithEvents sets the emitter told of the limiter events
+func (l *Limiter) WithEvents(emitter EventEmitter) *Limiter {
+	l.Events = emitter
+	return l
+}
+
+// emit tells the emitter of the event, along with the request being decided
+func (l *Limiter) emit(event Event) {
+	if l.Events == nil {
+		return
+	}
+
+	event.Limiter = l.name()
+	event.ClientID = l.request.ClientID
+	event.APIKey = logging.Redact(l.request.APIKeyID)
+	event.APIKeyID = l.request.APIKeyID
+	event.DryRun = l.Config.DryRun
+	event.At = l.now()
+	l.Events.Emit(event)
+}
+
+// observeKeyNotFound counts the request with an unknown API Key, emitting the spike the request completes
+func (l *Limiter) observeKeyNotFound() {
+	if l.keyNotFound == nil || l.Config.KeyNotFoundSpike <= 0 {
+		return
+	}
+
+	count, windowEnd, spike := l.keyNotFound.observe(l.now(), l.Config.KeyNotFoundWindow, l.Config.KeyNotFoundSpike)
+	if spike {
+		l.emit(Event{Type: EVENT_API_KEY_NOT_FOUND_SPIKE, Count: count, ExpiresAt: windowEnd})
+	}
+}
+
+// spikeCounter counts occurrences within fixed windows
+type spikeCounter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+// observe counts an occurrence, reporting a spike once per window, when the count reaches the threshold
+func (s *spikeCounter) observe(now time.Time, window time.Duration, threshold int) (int, time.Time, bool) {
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !now.Before(s.windowStart.Add(window)) {
+		s.windowStart = now
+		s.count = 0
+	}
+	s.count++
+	return s.count, s.windowStart.Add(window), s.count == threshold
+}
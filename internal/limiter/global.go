@@ -0,0 +1,45 @@
+package limiter
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// globalID is the client ID of the counter shared by every client of the limiter
+func (l *Limiter) globalID() string {
+	return fmt.Sprintf("global:%s", l.Config.Name)
+}
+
+// checkGlobalRequests checks the requests made by all the clients together, despite their identity.
+// Reaching the global limit blocks no one, requests are refused until the limit interval is reset.
+// Each priority class can only use its share of the limit
+func (l *Limiter) checkGlobalRequests(cost, priority int) (bool, error) {
+	counters := l.ruleCounters(l.globalID(), l.maxRequests(l.Config.MaxGlobalRequests, l.override().MaxGlobalRequests))
+	for i := range counters {
+		counters[i].Rule.MaxRequests = l.priorityShare(counters[i].Rule.MaxRequests, priority)
+	}
+	l.setLimit(counters[0].Rule.MaxRequests)
+
+	for _, counter := range counters {
+		if cost > counter.Rule.MaxRequests {
+			return false, costExceedsLimitError(cost, counter.Rule.MaxRequests)
+		}
+	}
+
+	exceeded, resetIn := l.Repository.ConsumeRules(counters, cost)
+	if exceeded >= 0 {
+		rule := counters[exceeded].Rule
+		l.logger().Debug("global limit reached",
+			slog.Int("max", rule.MaxRequests),
+			slog.Duration("interval", rule.Interval),
+			slog.String("priority", PriorityName(priority)),
+		)
+		return false, &LimitError{
+			Err:       ErrGlobalLimitReached,
+			ResetAt:   l.now().Add(resetIn),
+			Dimension: DIMENSION_GLOBAL,
+		}
+	}
+
+	return true, nil
+}
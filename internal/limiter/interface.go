@@ -1,30 +1,147 @@
 package limiter
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
 const (
-	CHECK_IP_ONLY       = iota // 0
-	CHECK_API_KEY_ONLY  = iota // 1
-	CHECK_IP_OR_API_KEY = iota // 2
+	CHECK_IP_ONLY        = iota // 0
+	CHECK_API_KEY_ONLY   = iota // 1
+	CHECK_IP_OR_API_KEY  = iota // 2
+	CHECK_IP_AND_API_KEY = iota // 3
+	CHECK_GLOBAL         = iota // 4
+)
+
+const (
+	ACCESS_ALLOW = iota // 0
+	ACCESS_DENY  = iota // 1
+)
+
+// Request priority classes, the lower ones are refused first when the global limit nears capacity
+const (
+	PRIORITY_DEFAULT  = iota // 0
+	PRIORITY_LOW      = iota // 1
+	PRIORITY_NORMAL   = iota // 2
+	PRIORITY_HIGH     = iota // 3
+	PRIORITY_CRITICAL = iota // 4
+)
+
+// Dimensions of a client limit, reported when the limit is reached
+const (
+	DIMENSION_IP         = "ip"
+	DIMENSION_API_KEY    = "api_key"
+	DIMENSION_API_KEY_IP = "api_key_ip"
+	DIMENSION_GLOBAL     = "global"
+)
+
+const (
+	QUOTA_DAILY   = iota // 0
+	QUOTA_MONTHLY = iota // 1
+)
+
+// Granularities of the usage buckets
+const (
+	USAGE_MINUTE = time.Minute
+	USAGE_HOUR   = time.Hour
+)
+
+// Types of the limiter events
+const (
+	EVENT_CLIENT_BLOCKED          = "client.blocked"
+	EVENT_CLIENT_UNBLOCKED        = "client.unblocked"
+	EVENT_QUOTA_EXHAUSTED         = "quota.exhausted"
+	EVENT_API_KEY_NOT_FOUND_SPIKE = "api_key.not_found_spike"
 )
 
 var ErrApiKeyNotFound = errors.New("the provided api key was not found")
 var ErrInvalidClient = errors.New("the provided client is invalid")
 var ErrMaxNumberRequestsReached = errors.New("you have reached the maximum number of requests or actions allowed within a certain time frame")
+var ErrQuotaExceeded = errors.New("you have exhausted your requests quota for the current period")
+var ErrInvalidCost = errors.New("the request cost must be greater than zero")
+var ErrCostExceedsLimit = errors.New("the request cost exceeds the maximum number of requests allowed")
+var ErrGlobalLimitReached = errors.New("the server is receiving too many requests, try again later")
+var ErrClientDenied = errors.New("the client is not allowed to make requests")
+var ErrInvalidAccessEntry = errors.New("the access entry is invalid")
+var ErrMaxConcurrentRequests = errors.New("you have reached the maximum number of simultaneous requests allowed")
 
 type LimiterConfig struct {
-	ClientCheckType       int
-	ClientBlockTime       time.Duration
+	// Name identifies the limiter, limiters with the same name share their counters.
+	// Limiters with different names count the same clients apart
+	Name string
+
+	// DryRun counts the requests but allows all of them, reporting the ones that would have been refused
+	DryRun bool
+
+	ClientCheckType int
+	ClientBlockTime time.Duration
+
+	// BlockEscalationFactor multiplies the block time of each block a client gets within the OffenseLookback,
+	// up to MaxClientBlockTime if set. 0 or 1 keeps the ClientBlockTime flat
+	BlockEscalationFactor int
+	MaxClientBlockTime    time.Duration
+
+	// OffenseLookback is how long a client block is remembered to escalate the next ones,
+	// each lookback without being blocked decays one of them
+	OffenseLookback time.Duration
+
 	MaxIPRequests         int
 	RequestsLimitInterval time.Duration
+
+	// MaxGlobalRequests is the max requests per RequestsLimitInterval of all clients together on CHECK_GLOBAL
+	MaxGlobalRequests int
+
+	// PriorityShares is the share, from 0 to 1, of the global limit each priority class can use,
+	// reserving the rest for the higher classes. Classes without a share can use all of it
+	PriorityShares map[int]float64
+
+	// MaxAPIKeyIPRequests limits the requests of an API Key from a single IP on CHECK_IP_AND_API_KEY,
+	// 0 means unlimited
+	MaxAPIKeyIPRequests int
+
+	// Rules are limits evaluated together with the max requests per RequestsLimitInterval,
+	// a request is only allowed if every one of them allows it.
+	// The counters are kept by interval, so rules sharing an interval, the RequestsLimitInterval included,
+	// are merged into the one with the least max requests
+	Rules []LimitRule
+
+	// DailyQuota and MonthlyQuota are the IP requests quotas, 0 means unlimited
+	DailyQuota   int
+	MonthlyQuota int
+
+	// QuotaLocation is the timezone the quota periods are aligned to, UTC if nil
+	QuotaLocation *time.Location
+
+	// Schedule overrides the limits by time of day and calendar, if set
+	Schedule *LimitSchedule
+
+	// KeyNotFoundSpike is how many requests with unknown API Keys within a KeyNotFoundWindow
+	// make a EVENT_API_KEY_NOT_FOUND_SPIKE, as of keys being guessed. 0 means no spike is reported
+	KeyNotFoundSpike  int
+	KeyNotFoundWindow time.Duration
+
+	// UsageRetention is how long the usage buckets of the request identities are kept, usage is not recorded if empty
+	UsageRetention UsageRetention
+}
+
+// UsageRetention is how long the usage buckets of each granularity are kept, 0 means they are not recorded
+type UsageRetention struct {
+	Minute time.Duration
+	Hour   time.Duration
 }
 
 type APIKey struct {
 	ID          string
 	MaxRequests int
+
+	// DailyQuota and MonthlyQuota are the API Key requests quotas, 0 means unlimited
+	DailyQuota   int
+	MonthlyQuota int
+
+	// Priority is the priority class of the API Key plan, PRIORITY_NORMAL if PRIORITY_DEFAULT
+	Priority int
 }
 
 // Client represents a client request information
@@ -35,20 +152,341 @@ type Client struct {
 	// CurrentRequests is the requests amount made
 	CurrentRequests int
 
-	// TTL is the interval time that this entry will be considered
+	// TTL is the interval time that this entry will be considered, the time left of it when read from the repository
 	TTL time.Duration
 
 	// Reports whether the client is blocked to make requests
 	Blocked bool
 }
 
+// LimitRule is a max amount of requests allowed within an interval
+type LimitRule struct {
+	MaxRequests int
+	Interval    time.Duration
+}
+
+// Offense represents the blocks a client got within the offense lookback
+type Offense struct {
+	// ID is the client IP or API Key
+	ID string
+
+	// Count is the amount of blocks, it is the client penalty level
+	Count int
+
+	// LastAt is when the client was last blocked
+	LastAt time.Time
+
+	// TTL is the interval time until every block is decayed
+	TTL time.Duration
+}
+
+// RuleCounter is the counter of a limit rule for a client
+type RuleCounter struct {
+	// ClientID is the client IP or API Key
+	ClientID string
+
+	Rule LimitRule
+}
+
+// Quota represents the requests made by a client within a quota period
+type Quota struct {
+	// ID is the client IP or API Key
+	ID string
+
+	// Period is the quota period, QUOTA_DAILY or QUOTA_MONTHLY
+	Period int
+
+	// Start is when the quota period started
+	Start time.Time
+
+	// Requests is the requests amount made within the period
+	Requests int
+
+	// ResetAt is when the quota period ends
+	ResetAt time.Time
+}
+
+// QuotaCounter is the quota of a client period along with its max requests
+type QuotaCounter struct {
+	Quota Quota
+
+	MaxRequests int
+}
+
+// Usage represents the requests of an identity within a usage bucket
+type Usage struct {
+	// Limiter is the name of the limiter that recorded the usage, empty for the limiters without a name
+	Limiter string
+
+	// Kind is the identity kind, DIMENSION_IP or DIMENSION_API_KEY
+	Kind string
+
+	// ID is the client IP or API Key
+	ID string
+
+	// Granularity is the bucket length, USAGE_MINUTE or USAGE_HOUR
+	Granularity time.Duration
+
+	// Start is when the bucket started, aligned to its granularity
+	Start time.Time
+
+	Allowed int
+	Refused int
+
+	// Cost is the requests charged by the allowed requests
+	Cost int
+
+	// TTL is how long the bucket is kept after the usage is added
+	TTL time.Duration
+}
+
+// Requests returns the requests made, allowed or refused
+func (u Usage) Requests() int {
+	return u.Allowed + u.Refused
+}
+
+// UsageQuery selects the usage buckets of a granularity started within [From, To)
+type UsageQuery struct {
+	Limiter     string
+	Kind        string
+	Granularity time.Duration
+	From        time.Time
+	To          time.Time
+
+	// ID selects the buckets of a single identity, every identity of the kind if empty
+	ID string
+}
+
+// AccessEntry is an allowlist or denylist entry
+type AccessEntry struct {
+	// Value is a single IP, a CIDR or an API Key
+	Value string
+
+	// Access is ACCESS_ALLOW or ACCESS_DENY
+	Access int
+}
+
+// Slot is a counter of the requests in flight of a client
+type Slot struct {
+	// ClientID is the client IP or API Key, or the concurrency limiter for the global slot
+	ClientID string
+
+	MaxInFlight int
+}
+
+// Lease holds a slot on every counter of a request in flight, until it is released or expires
+type Lease struct {
+	ID    string
+	Slots []Slot
+}
+
+// Request is a request to be checked by the limiter
+type Request struct {
+	ClientID string
+	APIKeyID string
+
+	// Cost is how many requests the request costs
+	Cost int
+
+	// Priority is the request priority class, taken from the API Key if PRIORITY_DEFAULT
+	Priority int
+}
+
+// Decision is the result of a request check
+type Decision struct {
+	Allowed bool
+
+	// Err is why the request was refused, or would have been if Shadowed
+	Err error
+
+	// Shadowed reports a request refused by a limiter on dry run, and therefore allowed
+	Shadowed bool
+
+	// Remaining is how many requests the client can still make within the limit interval, -1 if unknown
+	Remaining int
+
+	// Limit is how many requests the client can make within the limit interval as it was decided,
+	// scheduled or adapted, the lowest one if it is limited by many. It is 0 if unknown
+	Limit int
+}
+
+// LimitError reports a request refused by a limit and when the client can request again
+type LimitError struct {
+	// Err is the limit reached, as ErrMaxNumberRequestsReached or ErrQuotaExceeded
+	Err error
+
+	// ResetAt is when the limit is reset, zero if unknown
+	ResetAt time.Time
+
+	// Dimension is the kind of client limit reached, as DIMENSION_IP, empty if not reported
+	Dimension string
+
+	// PenaltyLevel is how many times the client was blocked within the offense lookback, 0 if not reported
+	PenaltyLevel int
+}
+
+func (e *LimitError) Error() string {
+	msg := e.Err.Error()
+	if e.Dimension != "" {
+		msg = fmt.Sprintf("%s, %s limit exceeded", msg, e.Dimension)
+	}
+	if e.PenaltyLevel > 0 {
+		msg = fmt.Sprintf("%s, penalty level %v", msg, e.PenaltyLevel)
+	}
+	if !e.ResetAt.IsZero() {
+		msg = fmt.Sprintf("%s, it will be reset at %s", msg, e.ResetAt.Format(time.RFC3339))
+	}
+	return msg
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// IsLimitError reports whether the request was refused by a limit or the access list,
+// rather than for being invalid
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrMaxNumberRequestsReached) ||
+		errors.Is(err, ErrMaxConcurrentRequests) ||
+		errors.Is(err, ErrQuotaExceeded) ||
+		errors.Is(err, ErrGlobalLimitReached) ||
+		errors.Is(err, ErrClientDenied)
+}
+
+// Reason returns a short name of why a request was refused, as to be reported on headers and metrics
+func Reason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrMaxNumberRequestsReached):
+		return "max_requests"
+	case errors.Is(err, ErrMaxConcurrentRequests):
+		return "concurrency"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota"
+	case errors.Is(err, ErrGlobalLimitReached):
+		return "global"
+	case errors.Is(err, ErrClientDenied):
+		return "denied"
+	case errors.Is(err, ErrInvalidClient):
+		return "invalid_client"
+	case errors.Is(err, ErrApiKeyNotFound):
+		return "api_key_not_found"
+	case errors.Is(err, ErrInvalidCost), errors.Is(err, ErrCostExceedsLimit):
+		return "invalid_cost"
+	default:
+		return "error"
+	}
+}
+
 type LimiterRepositoryInterface interface {
 	ApiKey(id string) *APIKey
 	Client(id string) *Client
+	Quota(id string, period int, start time.Time) *Quota
+	AccessEntries() []AccessEntry
+	Offense(id string) *Offense
+
+	// ConsumeRules charges cost requests on every counter if none of them would exceed its rule limit.
+	// It returns the index of the first counter that would and how long until it is reset, or -1 if they were charged
+	ConsumeRules(counters []RuleCounter, cost int) (int, time.Duration)
+
 	SaveApiKey(apiKey APIKey)
 	SaveClient(client Client)
+
+	// ConsumeQuotas charges cost requests on every quota at once if none of them would exceed its max requests,
+	// keeping each one until its ResetAt. A negative cost refunds the requests.
+	// It returns whether they were charged and the requests made within each period, the cost included if charged
+	ConsumeQuotas(counters []QuotaCounter, cost int) (bool, []int)
+
+	SaveOffense(offense Offense)
+	SaveAccessEntry(entry AccessEntry)
+	DeleteAccessEntry(entry AccessEntry)
+
+	// DeleteApiKey revokes the API Key, its requests being refused as of unknown keys
+	DeleteApiKey(id string)
+
+	// DeleteClient resets the client requests, its counters of the rules and its offenses,
+	// unblocking it without escalating its next block
+	DeleteClient(id string, rules []LimitRule)
+
+	// AddUsage adds the requests to the usage buckets of the identities, keeping each bucket until its TTL
+	AddUsage(usages ...Usage)
+
+	// UsageHistory returns the buckets of the query identity that have requests, the oldest first
+	UsageHistory(query UsageQuery) []Usage
+
+	// TopUsage returns the n identities of the query kind with the most requests within the query buckets, the top first.
+	// The buckets of each identity are summed up into a single one spanning the query
+	TopUsage(query UsageQuery, n int) []Usage
+}
+
+type ConcurrencyRepositoryInterface interface {
+	// ApiKey looks up the API Key a request is sent with, only the keys found having slots of their own
+	ApiKey(id string) *APIKey
+
+	// AcquireSlots leases a slot on every counter until ttl if none of them is full, expired leases being released.
+	// It returns the index of the first counter that is full, or -1 if the slots were leased
+	AcquireSlots(lease Lease, ttl time.Duration) int
+
+	// RenewSlots extends the lease slots until ttl
+	RenewSlots(lease Lease, ttl time.Duration)
+
+	ReleaseSlots(lease Lease)
+}
+
+// ContextRepository is a repository that can run its calls within the context of the request being decided,
+// as to trace them
+type ContextRepository interface {
+	WithContext(ctx context.Context) LimiterRepositoryInterface
+}
+
+// DecisionObserver is told of every decision of a limiter, as to record metrics
+type DecisionObserver interface {
+	ObserveDecision(conf LimiterConfig, req Request, decision Decision, elapsed time.Duration)
+}
+
+// Event is something that happened to a client of a limiter, as the client being blocked
+type Event struct {
+	Type    string `json:"type"`
+	Limiter string `json:"limiter"`
+
+	// ClientID is the IP of the request that caused the event
+	ClientID string `json:"client_id,omitempty"`
+
+	// APIKey is the redacted API Key of the request that caused the event
+	APIKey string `json:"api_key,omitempty"`
+
+	// APIKeyID is the API Key itself, only told to the sinks of the same process, as the audit trail
+	APIKeyID string `json:"-"`
+
+	// Dimension is the kind of client limit the event is about, as DIMENSION_IP, empty if not reported
+	Dimension string `json:"dimension,omitempty"`
+
+	// DryRun reports whether the limiter only reports the requests it would refuse
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// PenaltyLevel is how many blocks escalated the block time of a blocked client
+	PenaltyLevel int `json:"penalty_level,omitempty"`
+
+	// Period is the exhausted quota period, "daily" or "monthly"
+	Period string `json:"period,omitempty"`
+
+	// Count is the requests of a spike, or the quota max requests
+	Count int `json:"count,omitempty"`
+
+	At time.Time `json:"at"`
+
+	// ExpiresAt is when the block ends, the quota resets or the spike window ends
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EventEmitter is told of the limiter events.
+// Emit is called on the request path, so it must not wait for the events to be delivered
+type EventEmitter interface {
+	Emit(event Event)
 }
 
 type RateLimiterInterface interface {
 	AllowRequest(clientID, apiKeyID string) (bool, error)
+	AllowN(clientID, apiKeyID string, cost int) (bool, error)
 }
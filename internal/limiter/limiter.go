@@ -1,8 +1,13 @@
 package limiter
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
 )
 
 const REQUESTS_PER_SECOND = time.Second
@@ -11,6 +16,44 @@ const REQUESTS_PER_SECOND = time.Second
 type Limiter struct {
 	Config     LimiterConfig
 	Repository LimiterRepositoryInterface
+
+	// AccessList allows or denies clients before any limit is checked, if set
+	AccessList *AccessList
+
+	// Adaptive scales the IP and global limits in place of MaxIPRequests and MaxGlobalRequests, if set
+	Adaptive *AdaptiveController
+
+	Clock Clock
+
+	// Observer is told of every decision, if set
+	Observer DecisionObserver
+
+	// Logger logs the decisions, slog.Default() if nil
+	Logger *slog.Logger
+
+	// Events is told of the clients blocked, the quotas exhausted and the unknown API Keys spikes, if set
+	Events EventEmitter
+
+	keyNotFound *spikeCounter
+
+	// request is the request being decided, only set on the copy of the limiter each decision is made by
+	request Request
+
+	// remaining is the requests left to the client being decided, -1 if unknown.
+	// It is only set on the copy of the limiter each decision is made by
+	remaining int
+
+	// limit is the requests limit of the client being decided, 0 if unknown.
+	// It is only set on the copy of the limiter each decision is made by
+	limit int
+
+	// reserving reports a reservation, refusing the request without blocking the client or escalating its penalty.
+	// It is only set on the copy of the limiter each decision is made by
+	reserving bool
+
+	// apiKey is the API Key of the request once found on the repository by the client check, nil if it was not validated.
+	// It is only set on the copy of the limiter each decision is made by
+	apiKey *APIKey
 }
 
 func NewLimiter(
@@ -18,84 +61,448 @@ func NewLimiter(
 	repository LimiterRepositoryInterface,
 ) *Limiter {
 	return &Limiter{
-		Config:     conf,
-		Repository: repository,
+		Config:      conf,
+		Repository:  repository,
+		Clock:       SystemClock{},
+		keyNotFound: &spikeCounter{},
 	}
 }
 
+// WithObserver sets the observer told of every decision
+func (l *Limiter) WithObserver(observer DecisionObserver) *Limiter {
+	l.Observer = observer
+	return l
+}
+
+// WithLogger sets the logger the decisions are logged by
+func (l *Limiter) WithLogger(logger *slog.Logger) *Limiter {
+	l.Logger = logger
+	return l
+}
+
+// WithClock sets the clock the limiter, and its adaptive controller, tell the time by
+func (l *Limiter) WithClock(clock Clock) *Limiter {
+	l.Clock = clock
+	if l.Adaptive != nil {
+		l.Adaptive.WithClock(clock)
+	}
+	return l
+}
+
+// WithAccessList sets the allowlist and denylist checked before any limit
+func (l *Limiter) WithAccessList(accessList *AccessList) *Limiter {
+	l.AccessList = accessList
+	return l
+}
+
+// WithAdaptiveLimit sets the controller that scales the IP and global limits, timed by the limiter clock
+func (l *Limiter) WithAdaptiveLimit(controller *AdaptiveController) *Limiter {
+	if controller != nil && l.Clock != nil {
+		controller.WithClock(l.Clock)
+	}
+	l.Adaptive = controller
+	return l
+}
+
 func (l *Limiter) AllowRequest(clientID, apiKeyID string) (bool, error) {
+	return l.AllowN(clientID, apiKeyID, 1)
+}
+
+// AllowN reports whether a request costing `cost` requests is allowed, charging the cost if so.
+// A limiter on dry run allows every request
+func (l *Limiter) AllowN(clientID, apiKeyID string, cost int) (bool, error) {
+	decision := l.Decide(clientID, apiKeyID, cost)
+	if decision.Shadowed {
+		return true, nil
+	}
+	return decision.Allowed, decision.Err
+}
+
+// Decide checks a request costing `cost` requests, charging the cost if it is allowed.
+// On dry run the request is always allowed, the decision telling whether it would have been refused
+func (l *Limiter) Decide(clientID, apiKeyID string, cost int) Decision {
+	return l.DecideRequest(context.Background(), Request{ClientID: clientID, APIKeyID: apiKeyID, Cost: cost})
+}
+
+// DecideRequest checks the request like Decide, along with its priority.
+// The decision is traced as a child of the context span, as are the repository calls if it supports it
+func (l *Limiter) DecideRequest(ctx context.Context, req Request) Decision {
+	return l.decideRequest(ctx, req, false)
+}
+
+// decideRequest decides the request, a reservation penalizing no one if reserving, and reports the decision
+func (l *Limiter) decideRequest(ctx context.Context, req Request, reserving bool) Decision {
+	start := time.Now()
+	decision, decider := l.evaluate(ctx, req, reserving)
+	decider.report(ctx, req, decision, time.Since(start))
+	return decision
+}
+
+// evaluate decides the request without reporting it, returning the decider the decision is reported by.
+// The retries of a wait are evaluated, only the last one being reported
+func (l *Limiter) evaluate(ctx context.Context, req Request, reserving bool) (Decision, *Limiter) {
+	ctx, span := startDecisionSpan(ctx, l.Config, req)
+	defer span.End()
+
+	decider := l.decider(ctx, req)
+	decider.reserving = reserving
+	decision := decider.decide(req)
+	decision.Remaining = decider.remaining
+	decision.Limit = decider.limit
+	if IsLimitError(decision.Err) {
+		decision.Remaining = 0
+	}
+
+	endDecisionSpan(span, decision)
+	return decision, decider
+}
+
+// report tells the observer of the decision that took elapsed, records it on the usage and logs it
+func (l *Limiter) report(ctx context.Context, req Request, decision Decision, elapsed time.Duration) {
+	if errors.Is(decision.Err, ErrApiKeyNotFound) {
+		l.observeKeyNotFound()
+	}
+	if l.Observer != nil {
+		l.Observer.ObserveDecision(l.Config, req, decision, elapsed)
+	}
+	l.recordUsage(req, decision)
+	l.logDecision(ctx, req, decision)
+}
+
+// decider returns a copy of the limiter to decide the request by,
+// running the repository calls within the request context and logging along with the request
+func (l *Limiter) decider(ctx context.Context, req Request) *Limiter {
+	decider := *l
+	decider.remaining = -1
+	decider.request = req
+	if repository, ok := l.Repository.(ContextRepository); ok {
+		decider.Repository = repository.WithContext(ctx)
+	}
+
+	logger := l.logger().With(slog.String("limiter", l.name()), slog.String("client", req.ClientID))
+	if req.APIKeyID != "" {
+		logger = logger.With(slog.Any("api_key", logging.Secret(req.APIKeyID)))
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		logger = logger.With(slog.String(logging.REQUEST_ID_KEY, id))
+	}
+	decider.Logger = logger
+	return &decider
+}
+
+// logDecision logs the decision, refusals above the allowed requests and failures above both
+func (l *Limiter) logDecision(ctx context.Context, req Request, decision Decision) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.Bool(logging.ALLOWED_KEY, decision.Allowed && !decision.Shadowed),
+		slog.Int("cost", req.Cost),
+		slog.Int("check_type", l.Config.ClientCheckType),
+	}
+	if req.Priority != PRIORITY_DEFAULT {
+		attrs = append(attrs, slog.String("priority", PriorityName(req.Priority)))
+	}
+	if decision.Remaining >= 0 {
+		attrs = append(attrs, slog.Int("remaining", decision.Remaining))
+	}
+	if decision.Err != nil {
+		attrs = append(attrs, slog.String("reason", Reason(decision.Err)), slog.String("error", decision.Err.Error()))
+		if !IsLimitError(decision.Err) {
+			level = slog.LevelError
+		} else if !decision.Shadowed {
+			level = slog.LevelWarn
+		}
+	}
+	if decision.Shadowed {
+		attrs = append(attrs, slog.Bool("shadowed", true))
+	}
+
+	l.logger().LogAttrs(ctx, level, "rate limit decision", attrs...)
+}
+
+func (l *Limiter) logger() *slog.Logger {
+	return orDefaultLogger(l.Logger)
+}
+
+// orDefaultLogger returns the logger, slog.Default() if it is nil
+func orDefaultLogger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+func (l *Limiter) decide(req Request) Decision {
+	allowed, err := l.check(req)
+	if !allowed && l.Config.DryRun {
+		return Decision{Allowed: true, Err: err, Shadowed: true}
+	}
+	return Decision{Allowed: allowed, Err: err}
+}
+
+func (l *Limiter) check(req Request) (bool, error) {
+	clientID, apiKeyID, cost := req.ClientID, req.APIKeyID, req.Cost
+	if cost < 1 {
+		return false, ErrInvalidCost
+	}
+
+	if l.AccessList != nil {
+		if access, found := l.AccessList.Check(clientID, apiKeyID); found {
+			if access == ACCESS_DENY {
+				l.logger().Debug("client denied by the access list")
+				return false, ErrClientDenied
+			}
+			return true, nil
+		}
+	}
+
 	switch l.Config.ClientCheckType {
 	case CHECK_IP_ONLY:
-		return l.checkClientRequests(clientID, l.Config.MaxIPRequests)
+		return l.checkQuotaRequests(l.counterID(clientID), l.ipQuotas(), cost, l.ipCheck(clientID))
 	case CHECK_API_KEY_ONLY:
-		return l.checkAPIKeyOnly(apiKeyID)
+		return l.checkAPIKeyOnly(apiKeyID, cost)
+	case CHECK_IP_AND_API_KEY:
+		return l.checkIPAndAPIKey(clientID, apiKeyID, cost)
+	case CHECK_GLOBAL:
+		return l.checkGlobalRequests(cost, l.priority(req))
 	default: // CHECK_IP_OR_API_KEY
-		return l.checkIPOrAPIKey(clientID, apiKeyID)
+		return l.checkIPOrAPIKey(clientID, apiKeyID, cost)
 	}
 }
 
-func (l *Limiter) checkClientRequests(clientID string, maxRequests int) (bool, error) {
-	if clientID == "" {
-		return false, ErrInvalidClient
+// name returns the limiter name, "default" if it has none
+func (l *Limiter) name() string {
+	if l.Config.Name == "" {
+		return "default"
+	}
+	return l.Config.Name
+}
+
+// counterID returns the ID the client requests are counted by.
+// Named limiters count apart, so that a dry run limiter does not charge the enforcing one
+func (l *Limiter) counterID(clientID string) string {
+	if clientID == "" || l.Config.Name == "" {
+		return clientID
 	}
+	return fmt.Sprintf("%s:%s", l.Config.Name, clientID)
+}
 
-	client := l.Repository.Client(clientID)
+func (l *Limiter) now() time.Time {
+	if l.Clock == nil {
+		return time.Now()
+	}
+	return l.Clock.Now()
+}
 
-	if client != nil {
-		if !client.Blocked {
-			if client.CurrentRequests < maxRequests {
-				client.CurrentRequests++
-				client.TTL = l.Config.RequestsLimitInterval
-				l.Repository.SaveClient(*client)
+// override returns the limits overridden by the schedule entry active now
+func (l *Limiter) override() LimitOverride {
+	if l.Config.Schedule == nil {
+		return LimitOverride{}
+	}
+	override, _ := l.Config.Schedule.Active(l.now())
+	return override
+}
 
-				log.Printf("---------Client: %s | Requests Current/Max: %v/%v", clientID, client.CurrentRequests, maxRequests)
-				return true, nil
-			}
+// maxRequests returns the limit scaled by the adaptive controller if there is one,
+// otherwise the scheduled limit if it is overridden or the configured one
+func (l *Limiter) maxRequests(configured, scheduled int) int {
+	if l.Adaptive != nil {
+		return l.Adaptive.Limit()
+	}
+	if scheduled > 0 {
+		return scheduled
+	}
+	return configured
+}
+
+// apiKeyMaxRequests returns the API Key limit, multiplied by the scheduled factor if it is overridden
+func (l *Limiter) apiKeyMaxRequests(apiKey *APIKey) int {
+	factor := l.override().APIKeyFactor
+	if factor <= 0 {
+		return apiKey.MaxRequests
+	}
+	return max(int(float64(apiKey.MaxRequests)*factor), 1)
+}
+
+// maxAPIKeyIPRequests returns the limit of an API Key from a single IP, the scheduled one if it is overridden.
+// A limiter without it configured is not limited by schedule either
+func (l *Limiter) maxAPIKeyIPRequests() int {
+	if l.Config.MaxAPIKeyIPRequests <= 0 {
+		return 0
+	}
+	if scheduled := l.override().MaxAPIKeyIPRequests; scheduled > 0 {
+		return scheduled
+	}
+	return l.Config.MaxAPIKeyIPRequests
+}
+
+// clientCheck is a client requests limit to be checked
+type clientCheck struct {
+	// Dimension is the kind of client limited, it is reported when the limit is reached
+	Dimension   string
+	ClientID    string
+	MaxRequests int
+
+	// kind is the kind of client limited, as Dimension, reported on the logs and events even if Dimension is not
+	kind string
+}
+
+func (l *Limiter) ipCheck(clientID string) clientCheck {
+	return clientCheck{
+		ClientID:    l.counterID(clientID),
+		MaxRequests: l.maxRequests(l.Config.MaxIPRequests, l.override().MaxIPRequests),
+		kind:        DIMENSION_IP,
+	}
+}
+
+func (l *Limiter) apiKeyCheck(apiKey *APIKey) clientCheck {
+	return clientCheck{
+		ClientID:    l.counterID(apiKey.ID),
+		MaxRequests: l.apiKeyMaxRequests(apiKey),
+		kind:        DIMENSION_API_KEY,
+	}
+}
 
-			//apply block if client tries to access after limit is reached out
-			client.Blocked = true
-			client.TTL = l.Config.ClientBlockTime
-			l.Repository.SaveClient(*client)
+// checkClientRequests checks the requests limit of every client.
+// The clients are only charged if all of them allow the request
+func (l *Limiter) checkClientRequests(cost int, checks ...clientCheck) (bool, error) {
+	for _, c := range checks {
+		if c.ClientID == "" {
+			return false, ErrInvalidClient
 		}
-		log.Printf("---------Client: %s blocked for %v seconds", clientID, l.Config.ClientBlockTime)
-		return false, ErrMaxNumberRequestsReached
-	} else {
-		client = &Client{
-			ID:              clientID,
-			CurrentRequests: 1,
-			TTL:             l.Config.RequestsLimitInterval,
-			Blocked:         false,
+		if cost > c.MaxRequests {
+			return false, costExceedsLimitError(cost, c.MaxRequests)
+		}
+	}
+
+	if len(l.Config.Rules) > 0 {
+		return l.checkClientRules(checks, cost)
+	}
+
+	clients := make([]Client, 0, len(checks))
+	for _, c := range checks {
+		client := l.Repository.Client(c.ClientID)
+
+		if client != nil {
+			if !client.Blocked {
+				if client.CurrentRequests+cost <= c.MaxRequests {
+					clients = append(clients, *client)
+					continue
+				}
+
+				//apply block if client tries to access after limit is reached out
+				return false, l.blockClient(c, *client, 0)
+			}
+			return false, l.blockedError(c, *client)
+		} else {
+			clients = append(clients, Client{
+				ID:      c.ClientID,
+				Blocked: false,
+			})
 		}
-		l.Repository.SaveClient(*client)
-		log.Printf("---------Client: %s | Requests Current/Max: %v/%v", clientID, client.CurrentRequests, maxRequests)
-		return true, nil
+	}
+
+	for i, client := range clients {
+		client.CurrentRequests += cost
+		client.TTL = l.Config.RequestsLimitInterval
+		l.Repository.SaveClient(client)
+		l.setRemaining(checks[i].MaxRequests - client.CurrentRequests)
+		l.logger().Debug("client requests counted",
+			slog.String("dimension", checks[i].kind),
+			slog.Int("current", client.CurrentRequests),
+			slog.Int("max", checks[i].MaxRequests),
+		)
+	}
+	return true, nil
+}
+
+// setRemaining lowers the requests left to the client being decided, the client being as limited as its most limited check
+func (l *Limiter) setRemaining(remaining int) {
+	if l.remaining < 0 || remaining < l.remaining {
+		l.remaining = remaining
 	}
 }
 
-func (l *Limiter) checkAPIKeyOnly(apiKeyID string) (bool, error) {
+// setLimit lowers the requests limit of the client being decided, as setRemaining
+func (l *Limiter) setLimit(maxRequests int) {
+	if l.limit <= 0 || maxRequests < l.limit {
+		l.limit = maxRequests
+	}
+}
+
+// limitReachedError returns ErrMaxNumberRequestsReached, reporting the limited dimension if there is one
+func limitReachedError(dimension string) error {
+	if dimension == "" {
+		return ErrMaxNumberRequestsReached
+	}
+	return &LimitError{Err: ErrMaxNumberRequestsReached, Dimension: dimension}
+}
+
+// costExceedsLimitError returns ErrCostExceedsLimit for a cost that no request could ever be allowed with
+func costExceedsLimitError(cost, maxRequests int) error {
+	return fmt.Errorf("%w: cost %v, limit %v", ErrCostExceedsLimit, cost, maxRequests)
+}
+
+func (l *Limiter) checkAPIKeyOnly(apiKeyID string, cost int) (bool, error) {
 	var apiKey *APIKey
 
 	if apiKeyID != "" {
 		apiKey = l.Repository.ApiKey(apiKeyID)
 
 		if apiKey != nil {
-			return l.checkClientRequests(apiKeyID, apiKey.MaxRequests)
+			l.apiKey = apiKey
+			return l.checkQuotaRequests(l.counterID(apiKeyID), apiKeyQuotas(apiKey), cost, l.apiKeyCheck(apiKey))
 		}
 	}
 
 	return false, ErrApiKeyNotFound
 }
 
-func (l *Limiter) checkIPOrAPIKey(clientID, apiKeyID string) (bool, error) {
+func (l *Limiter) checkIPOrAPIKey(clientID, apiKeyID string, cost int) (bool, error) {
 	var apiKey *APIKey
 
 	if apiKeyID != "" {
 		apiKey = l.Repository.ApiKey(apiKeyID)
 
 		if apiKey != nil {
-			return l.checkClientRequests(apiKeyID, apiKey.MaxRequests)
+			l.apiKey = apiKey
+			return l.checkQuotaRequests(l.counterID(apiKeyID), apiKeyQuotas(apiKey), cost, l.apiKeyCheck(apiKey))
 		}
 	}
 
-	return l.checkClientRequests(clientID, l.Config.MaxIPRequests)
+	return l.checkQuotaRequests(l.counterID(clientID), l.ipQuotas(), cost, l.ipCheck(clientID))
+}
+
+// checkIPAndAPIKey requires both the API Key and the IP limits to allow the request,
+// along with the limit of the API Key used from the IP if it is configured
+func (l *Limiter) checkIPAndAPIKey(clientID, apiKeyID string, cost int) (bool, error) {
+	if clientID == "" {
+		return false, ErrInvalidClient
+	}
+
+	if apiKeyID == "" {
+		return false, ErrApiKeyNotFound
+	}
+
+	apiKey := l.Repository.ApiKey(apiKeyID)
+	if apiKey == nil {
+		return false, ErrApiKeyNotFound
+	}
+	l.apiKey = apiKey
+
+	keyCheck := l.apiKeyCheck(apiKey)
+	keyCheck.Dimension = DIMENSION_API_KEY
+	ipCheck := l.ipCheck(clientID)
+	ipCheck.Dimension = DIMENSION_IP
+	checks := []clientCheck{keyCheck, ipCheck}
+
+	if maxAPIKeyIPRequests := l.maxAPIKeyIPRequests(); maxAPIKeyIPRequests > 0 {
+		checks = append(checks, clientCheck{
+			Dimension:   DIMENSION_API_KEY_IP,
+			ClientID:    l.counterID(fmt.Sprintf("%s@%s", apiKeyID, clientID)),
+			MaxRequests: maxAPIKeyIPRequests,
+			kind:        DIMENSION_API_KEY_IP,
+		})
+	}
+
+	return l.checkQuotaRequests(l.counterID(apiKeyID), apiKeyQuotas(apiKey), cost, checks...)
 }
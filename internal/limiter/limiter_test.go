@@ -1,12 +1,20 @@
 package limiter_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
 	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
 )
 
 type MockLimiterRepository struct {
@@ -23,6 +31,26 @@ func (r *MockLimiterRepository) Client(id string) *limiter.Client {
 	return args.Get(0).(*limiter.Client)
 }
 
+func (r *MockLimiterRepository) Quota(id string, period int, start time.Time) *limiter.Quota {
+	args := r.Called(id, period, start)
+	return args.Get(0).(*limiter.Quota)
+}
+
+func (r *MockLimiterRepository) AccessEntries() []limiter.AccessEntry {
+	args := r.Called()
+	return args.Get(0).([]limiter.AccessEntry)
+}
+
+func (r *MockLimiterRepository) Offense(id string) *limiter.Offense {
+	args := r.Called(id)
+	return args.Get(0).(*limiter.Offense)
+}
+
+func (r *MockLimiterRepository) ConsumeRules(counters []limiter.RuleCounter, cost int) (int, time.Duration) {
+	args := r.Called(counters, cost)
+	return args.Int(0), args.Get(1).(time.Duration)
+}
+
 func (r *MockLimiterRepository) SaveClient(client limiter.Client) {
 	r.Called(client)
 }
@@ -31,6 +59,67 @@ func (r *MockLimiterRepository) SaveApiKey(apiKey limiter.APIKey) {
 	r.Called(apiKey)
 }
 
+func (r *MockLimiterRepository) ConsumeQuotas(counters []limiter.QuotaCounter, cost int) (bool, []int) {
+	args := r.Called(counters, cost)
+	return args.Bool(0), args.Get(1).([]int)
+}
+
+func (r *MockLimiterRepository) SaveOffense(offense limiter.Offense) {
+	r.Called(offense)
+}
+
+func (r *MockLimiterRepository) SaveAccessEntry(entry limiter.AccessEntry) {
+	r.Called(entry)
+}
+
+func (r *MockLimiterRepository) DeleteAccessEntry(entry limiter.AccessEntry) {
+	r.Called(entry)
+}
+
+func (r *MockLimiterRepository) DeleteApiKey(id string) {
+	r.Called(id)
+}
+
+func (r *MockLimiterRepository) DeleteClient(id string, rules []limiter.LimitRule) {
+	r.Called(id, rules)
+}
+
+func (r *MockLimiterRepository) AddUsage(usages ...limiter.Usage) {
+	r.Called(usages)
+}
+
+func (r *MockLimiterRepository) UsageHistory(query limiter.UsageQuery) []limiter.Usage {
+	args := r.Called(query)
+	return args.Get(0).([]limiter.Usage)
+}
+
+func (r *MockLimiterRepository) TopUsage(query limiter.UsageQuery, n int) []limiter.Usage {
+	args := r.Called(query, n)
+	return args.Get(0).([]limiter.Usage)
+}
+
+type MockConcurrencyRepository struct {
+	mock.Mock
+}
+
+func (r *MockConcurrencyRepository) ApiKey(id string) *limiter.APIKey {
+	args := r.Called(id)
+	return args.Get(0).(*limiter.APIKey)
+}
+
+func (r *MockConcurrencyRepository) AcquireSlots(lease limiter.Lease, ttl time.Duration) int {
+	args := r.Called(lease, ttl)
+	return args.Int(0)
+}
+
+func (r *MockConcurrencyRepository) RenewSlots(lease limiter.Lease, ttl time.Duration) {
+	r.Called(lease, ttl)
+}
+
+func (r *MockConcurrencyRepository) ReleaseSlots(lease limiter.Lease) {
+	r.Called(lease)
+}
+
 func TestSuite(t *testing.T) {
 	suite.Run(t, new(LimiterTestSuite))
 }
@@ -541,3 +630,2112 @@ func (suite *LimiterTestSuite) TestLimiter_AllowRequest_CheckIpOrAPIKey() {
 		})
 	}
 }
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_Quota() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+	suite.Config.DailyQuota = 10
+	suite.Config.QuotaLocation = time.UTC
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	testApiKey := limiter.APIKey{
+		ID:           "SecretKey123",
+		MaxRequests:  5,
+		DailyQuota:   100,
+		MonthlyQuota: 1000,
+	}
+	ipCounters := []limiter.QuotaCounter{
+		{
+			Quota:       limiter.Quota{ID: "192.168.0.1", Period: limiter.QUOTA_DAILY, Start: dayStart, ResetAt: dayStart.AddDate(0, 0, 1)},
+			MaxRequests: suite.Config.DailyQuota,
+		},
+	}
+	apiKeyCounters := []limiter.QuotaCounter{
+		{
+			Quota:       limiter.Quota{ID: testApiKey.ID, Period: limiter.QUOTA_DAILY, Start: dayStart, ResetAt: dayStart.AddDate(0, 0, 1)},
+			MaxRequests: testApiKey.DailyQuota,
+		},
+		{
+			Quota:       limiter.Quota{ID: testApiKey.ID, Period: limiter.QUOTA_MONTHLY, Start: monthStart, ResetAt: monthStart.AddDate(0, 1, 0)},
+			MaxRequests: testApiKey.MonthlyQuota,
+		},
+	}
+
+	type QuotaTestCase struct {
+		Name     string
+		Input    TestCaseInput
+		ApiKey   *limiter.APIKey
+		Counters []limiter.QuotaCounter
+		Charged  bool
+		Requests []int
+		Client   *limiter.Client
+		Expected TestCaseExpected
+	}
+
+	testCases := []QuotaTestCase{
+		{
+			Name: "Should allow and charge the IP daily quota if it has no usage yet",
+			Input: TestCaseInput{
+				ClientID: "192.168.0.1",
+			},
+			Counters: ipCounters,
+			Charged:  true,
+			Requests: []int{1},
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				Error:     nil,
+				SavedClient: limiter.Client{
+					ID:              "192.168.0.1",
+					CurrentRequests: 1,
+					TTL:             suite.Config.RequestsLimitInterval,
+				},
+			},
+		},
+		{
+			Name: "Should not allow with QuotaExceeded error if the IP daily quota is exhausted, without charging the requests limit",
+			Input: TestCaseInput{
+				ClientID: "192.168.0.1",
+			},
+			Counters: ipCounters,
+			Requests: []int{suite.Config.DailyQuota},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error: &limiter.LimitError{
+					Err:     limiter.ErrQuotaExceeded,
+					ResetAt: dayStart.AddDate(0, 0, 1),
+				},
+			},
+		},
+		{
+			Name: "Should use the API Key quotas instead of the IP ones",
+			Input: TestCaseInput{
+				ClientID: "192.168.0.1",
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey:   &testApiKey,
+			Counters: apiKeyCounters,
+			Charged:  true,
+			Requests: []int{suite.Config.DailyQuota + 1, 501},
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				Error:     nil,
+				SavedClient: limiter.Client{
+					ID:              testApiKey.ID,
+					CurrentRequests: 1,
+					TTL:             suite.Config.RequestsLimitInterval,
+				},
+			},
+		},
+		{
+			Name: "Should report the latest reset time when more than one quota is exhausted",
+			Input: TestCaseInput{
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey:   &testApiKey,
+			Counters: apiKeyCounters,
+			Requests: []int{testApiKey.DailyQuota, testApiKey.MonthlyQuota},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error: &limiter.LimitError{
+					Err:     limiter.ErrQuotaExceeded,
+					ResetAt: monthStart.AddDate(0, 1, 0),
+				},
+			},
+		},
+		{
+			Name: "Should refund the quotas charged if the requests limit refuses the request",
+			Input: TestCaseInput{
+				ClientID: "192.168.0.1",
+			},
+			Counters: ipCounters,
+			Charged:  true,
+			Requests: []int{1},
+			Client:   &limiter.Client{ID: "192.168.0.1", Blocked: true},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrMaxNumberRequestsReached,
+			},
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+
+			clientID := t.Input.ClientID
+			if t.ApiKey != nil {
+				clientID = t.ApiKey.ID
+			}
+
+			suite.MockLimiterRepository.Mock.On("ApiKey", t.Input.ApiKeyID).Return(t.ApiKey)
+			suite.MockLimiterRepository.Mock.On("Client", clientID).Return(t.Client)
+			suite.MockLimiterRepository.Mock.On("SaveClient", t.Expected.SavedClient)
+			suite.MockLimiterRepository.Mock.On("ConsumeQuotas", t.Counters, 1).Return(t.Charged, t.Requests)
+			suite.MockLimiterRepository.Mock.On("ConsumeQuotas", t.Counters, -1).Return(true, []int{0})
+
+			allowed, err := suite.Limiter.AllowRequest(t.Input.ClientID, t.Input.ApiKeyID)
+			suite.Equal(t.Expected.IsAllowed, allowed)
+			suite.Equal(t.Expected.Error, err)
+			if !t.Charged {
+				suite.ErrorIs(err, limiter.ErrQuotaExceeded)
+				suite.MockLimiterRepository.AssertNotCalled(suite.T(), "Client", clientID)
+			}
+
+			suite.MockLimiterRepository.AssertCalled(suite.T(), "ConsumeQuotas", t.Counters, 1)
+			if t.Charged && !t.Expected.IsAllowed {
+				suite.MockLimiterRepository.AssertCalled(suite.T(), "ConsumeQuotas", t.Counters, -1)
+			} else {
+				suite.MockLimiterRepository.AssertNotCalled(suite.T(), "ConsumeQuotas", t.Counters, -1)
+			}
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_Rules() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.Rules = []limiter.LimitRule{
+		{MaxRequests: 30, Interval: time.Minute},
+		{MaxRequests: 500, Interval: time.Hour},
+	}
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	clientID := "192.168.0.1"
+	counters := []limiter.RuleCounter{
+		{ClientID: clientID, Rule: limiter.LimitRule{MaxRequests: MaxRequests, Interval: suite.Config.RequestsLimitInterval}},
+		{ClientID: clientID, Rule: suite.Config.Rules[0]},
+		{ClientID: clientID, Rule: suite.Config.Rules[1]},
+	}
+
+	type RulesTestCase struct {
+		Name     string
+		Client   *limiter.Client
+		Exceeded int
+		Expected TestCaseExpected
+	}
+
+	testCases := []RulesTestCase{
+		{
+			Name:     "Should allow with no error if every rule allows the request",
+			Client:   nil,
+			Exceeded: -1,
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				Error:     nil,
+			},
+		},
+		{
+			Name:     "Should not allow with MaxNumberRequestsReached error if any rule is exceeded, applying block time",
+			Client:   nil,
+			Exceeded: 1,
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrMaxNumberRequestsReached,
+				SavedClient: limiter.Client{
+					ID:      clientID,
+					TTL:     suite.Config.ClientBlockTime,
+					Blocked: true,
+				},
+			},
+		},
+		{
+			Name: "Should not allow with MaxNumberRequestsReached error a blocked client, without charging the rules",
+			Client: &limiter.Client{
+				ID:      clientID,
+				TTL:     suite.Config.ClientBlockTime,
+				Blocked: true,
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrMaxNumberRequestsReached,
+			},
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+
+			suite.MockLimiterRepository.Mock.On("Client", clientID).Return(t.Client)
+			suite.MockLimiterRepository.Mock.On("ConsumeRules", counters, 1).Return(t.Exceeded, time.Duration(0))
+			suite.MockLimiterRepository.Mock.On("SaveClient", t.Expected.SavedClient)
+
+			allowed, err := suite.Limiter.AllowRequest(clientID, "")
+			suite.Equal(t.Expected.IsAllowed, allowed)
+			suite.Equal(t.Expected.Error, err)
+
+			if t.Client != nil {
+				suite.MockLimiterRepository.AssertNotCalled(suite.T(), "ConsumeRules", counters, 1)
+			} else {
+				suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "ConsumeRules", 1)
+			}
+
+			if (t.Expected.SavedClient != limiter.Client{}) {
+				suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "SaveClient", 1)
+			} else {
+				suite.MockLimiterRepository.AssertNotCalled(suite.T(), "SaveClient", t.Expected.SavedClient)
+			}
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_Rules_ResetAt() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Config.Rules = []limiter.LimitRule{{MaxRequests: 3, Interval: time.Minute}}
+	l := limiter.NewLimiter(suite.Config, database.NewMemoryLimiterRepository(clock)).WithClock(clock)
+
+	for i := 0; i < 3; i++ {
+		allowed, err := l.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+		suite.NoError(err)
+		clock.Advance(time.Second * ClientBlockTime)
+	}
+
+	allowed, err := l.AllowRequest("192.168.0.1", "")
+	suite.False(allowed)
+	suite.Equal(&limiter.LimitError{
+		Err:     limiter.ErrMaxNumberRequestsReached,
+		ResetAt: clock.Now().Add(time.Minute - 3*time.Second*ClientBlockTime),
+	}, err)
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_Rules_SharedInterval() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Config.Rules = []limiter.LimitRule{
+		{MaxRequests: 5, Interval: time.Minute},
+		{MaxRequests: 3, Interval: time.Minute},
+	}
+	l := limiter.NewLimiter(suite.Config, database.NewMemoryLimiterRepository(clock)).WithClock(clock)
+
+	// the rules share the counter of the interval, each request being charged once against the strictest rule
+	for i := 0; i < 3; i++ {
+		allowed, err := l.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+		suite.NoError(err)
+		clock.Advance(time.Second * ClientBlockTime)
+	}
+
+	allowed, err := l.AllowRequest("192.168.0.1", "")
+	suite.False(allowed)
+	suite.ErrorIs(err, limiter.ErrMaxNumberRequestsReached)
+}
+
+func (suite *LimiterTestSuite) TestParseLimitRules() {
+	suite.Run("Should parse a comma separated list of rules", func() {
+		rules, err := limiter.ParseLimitRules("10/1s, 300/1m,5000/1h")
+		suite.NoError(err)
+		suite.Equal([]limiter.LimitRule{
+			{MaxRequests: 10, Interval: time.Second},
+			{MaxRequests: 300, Interval: time.Minute},
+			{MaxRequests: 5000, Interval: time.Hour},
+		}, rules)
+	})
+
+	suite.Run("Should return no rules for an empty list", func() {
+		rules, err := limiter.ParseLimitRules("")
+		suite.NoError(err)
+		suite.Empty(rules)
+	})
+
+	for _, invalid := range []string{"300", "a/1m", "0/1m", "300/minute", "300/-1m", "300/1m,10/60s"} {
+		suite.Run("Should return error for invalid rule "+invalid, func() {
+			_, err := limiter.ParseLimitRules(invalid)
+			suite.Error(err)
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_CheckIpAndAPIKey() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_AND_API_KEY
+	suite.Config.MaxAPIKeyIPRequests = 2
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+	testApiKey := limiter.APIKey{
+		ID:          "SecretKey123",
+		MaxRequests: 5,
+	}
+	clientID := "192.168.0.1"
+	keyIPClientID := testApiKey.ID + "@" + clientID
+
+	type AndTestCase struct {
+		Name          string
+		Input         TestCaseInput
+		ApiKey        *limiter.APIKey
+		Clients       map[string]*limiter.Client
+		Expected      TestCaseExpected
+		SavedClients  []limiter.Client
+		BlockedClient limiter.Client
+	}
+
+	testCases := []AndTestCase{
+		{
+			Name: "Should not allow and return InvalidClient error if clientID is empty",
+			Input: TestCaseInput{
+				ApiKeyID: testApiKey.ID,
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrInvalidClient,
+			},
+		},
+		{
+			Name: "Should not allow and return ApiKeyNotFound error if apiKey is empty",
+			Input: TestCaseInput{
+				ClientID: clientID,
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrApiKeyNotFound,
+			},
+		},
+		{
+			Name: "Should not allow and return ApiKeyNotFound error if apiKey does not exist, even with a valid IP",
+			Input: TestCaseInput{
+				ClientID: clientID,
+				ApiKeyID: "Inexistent API Key",
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrApiKeyNotFound,
+			},
+		},
+		{
+			Name: "Should allow and charge every dimension if all of them are within the limit",
+			Input: TestCaseInput{
+				ClientID: clientID,
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey: &testApiKey,
+			Clients: map[string]*limiter.Client{
+				testApiKey.ID: {ID: testApiKey.ID, CurrentRequests: 3},
+				clientID:      {ID: clientID, CurrentRequests: 1},
+				keyIPClientID: nil,
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				Error:     nil,
+			},
+			SavedClients: []limiter.Client{
+				{ID: testApiKey.ID, CurrentRequests: 4, TTL: suite.Config.RequestsLimitInterval},
+				{ID: clientID, CurrentRequests: 2, TTL: suite.Config.RequestsLimitInterval},
+				{ID: keyIPClientID, CurrentRequests: 1, TTL: suite.Config.RequestsLimitInterval},
+			},
+		},
+		{
+			Name: "Should not allow with the IP dimension reported if the IP limit is reached, blocking only the IP",
+			Input: TestCaseInput{
+				ClientID: clientID,
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey: &testApiKey,
+			Clients: map[string]*limiter.Client{
+				testApiKey.ID: {ID: testApiKey.ID, CurrentRequests: 1},
+				clientID:      {ID: clientID, CurrentRequests: MaxRequests},
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error: &limiter.LimitError{
+					Err:       limiter.ErrMaxNumberRequestsReached,
+					Dimension: limiter.DIMENSION_IP,
+				},
+			},
+			BlockedClient: limiter.Client{
+				ID:              clientID,
+				CurrentRequests: MaxRequests,
+				TTL:             suite.Config.ClientBlockTime,
+				Blocked:         true,
+			},
+		},
+		{
+			Name: "Should not allow with the API Key dimension reported if the API Key is blocked",
+			Input: TestCaseInput{
+				ClientID: clientID,
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey: &testApiKey,
+			Clients: map[string]*limiter.Client{
+				testApiKey.ID: {ID: testApiKey.ID, CurrentRequests: testApiKey.MaxRequests, Blocked: true},
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error: &limiter.LimitError{
+					Err:       limiter.ErrMaxNumberRequestsReached,
+					Dimension: limiter.DIMENSION_API_KEY,
+				},
+			},
+		},
+		{
+			Name: "Should not allow with the API Key from IP dimension reported if the key reached its limit from the IP",
+			Input: TestCaseInput{
+				ClientID: clientID,
+				ApiKeyID: testApiKey.ID,
+			},
+			ApiKey: &testApiKey,
+			Clients: map[string]*limiter.Client{
+				testApiKey.ID: {ID: testApiKey.ID, CurrentRequests: 2},
+				clientID:      {ID: clientID, CurrentRequests: 2},
+				keyIPClientID: {ID: keyIPClientID, CurrentRequests: 2},
+			},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error: &limiter.LimitError{
+					Err:       limiter.ErrMaxNumberRequestsReached,
+					Dimension: limiter.DIMENSION_API_KEY_IP,
+				},
+			},
+			BlockedClient: limiter.Client{
+				ID:              keyIPClientID,
+				CurrentRequests: 2,
+				TTL:             suite.Config.ClientBlockTime,
+				Blocked:         true,
+			},
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+
+			suite.MockLimiterRepository.Mock.On("ApiKey", t.Input.ApiKeyID).Return(t.ApiKey)
+			for id, client := range t.Clients {
+				suite.MockLimiterRepository.Mock.On("Client", id).Return(client)
+			}
+			for _, client := range t.SavedClients {
+				suite.MockLimiterRepository.Mock.On("SaveClient", client)
+			}
+			suite.MockLimiterRepository.Mock.On("SaveClient", t.BlockedClient)
+
+			allowed, err := suite.Limiter.AllowRequest(t.Input.ClientID, t.Input.ApiKeyID)
+			suite.Equal(t.Expected.IsAllowed, allowed)
+			suite.Equal(t.Expected.Error, err)
+
+			if (t.BlockedClient != limiter.Client{}) {
+				suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "SaveClient", 1)
+				suite.MockLimiterRepository.AssertCalled(suite.T(), "SaveClient", t.BlockedClient)
+			} else {
+				suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "SaveClient", len(t.SavedClients))
+			}
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowN() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+	suite.Config.MaxIPRequests = 10
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+	clientID := "192.168.0.1"
+
+	type CostTestCase struct {
+		Name     string
+		Cost     int
+		Client   *limiter.Client
+		Expected TestCaseExpected
+	}
+
+	testCases := []CostTestCase{
+		{
+			Name: "Should not allow and return InvalidCost error if cost is not positive",
+			Cost: 0,
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrInvalidCost,
+			},
+		},
+		{
+			Name: "Should not allow and return CostExceedsLimit error if cost is greater than the limit, without charging or blocking",
+			Cost: 11,
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrCostExceedsLimit,
+			},
+		},
+		{
+			Name: "Should allow and charge the cost of a new client",
+			Cost: 3,
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				SavedClient: limiter.Client{
+					ID:              clientID,
+					CurrentRequests: 3,
+					TTL:             suite.Config.RequestsLimitInterval,
+				},
+			},
+		},
+		{
+			Name:   "Should allow and charge the cost if it reaches the limit exactly",
+			Cost:   4,
+			Client: &limiter.Client{ID: clientID, CurrentRequests: 6},
+			Expected: TestCaseExpected{
+				IsAllowed: true,
+				SavedClient: limiter.Client{
+					ID:              clientID,
+					CurrentRequests: 10,
+					TTL:             suite.Config.RequestsLimitInterval,
+				},
+			},
+		},
+		{
+			Name:   "Should not allow with MaxNumberRequestsReached error if the cost exceeds the remaining requests",
+			Cost:   5,
+			Client: &limiter.Client{ID: clientID, CurrentRequests: 6},
+			Expected: TestCaseExpected{
+				IsAllowed: false,
+				Error:     limiter.ErrMaxNumberRequestsReached,
+				SavedClient: limiter.Client{
+					ID:              clientID,
+					CurrentRequests: 6,
+					TTL:             suite.Config.ClientBlockTime,
+					Blocked:         true,
+				},
+			},
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+
+			suite.MockLimiterRepository.Mock.On("Client", clientID).Return(t.Client)
+			suite.MockLimiterRepository.Mock.On("SaveClient", t.Expected.SavedClient)
+
+			allowed, err := suite.Limiter.AllowN(clientID, "", t.Cost)
+			suite.Equal(t.Expected.IsAllowed, allowed)
+			suite.ErrorIs(err, t.Expected.Error)
+
+			if (t.Expected.SavedClient != limiter.Client{}) {
+				suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "SaveClient", 1)
+			} else {
+				suite.MockLimiterRepository.AssertNotCalled(suite.T(), "Client", clientID)
+			}
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_CheckGlobal() {
+	suite.Config.Name = "reports"
+	suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+	suite.Config.MaxGlobalRequests = 200
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	counters := []limiter.RuleCounter{
+		{
+			ClientID: "global:reports",
+			Rule:     limiter.LimitRule{MaxRequests: 200, Interval: suite.Config.RequestsLimitInterval},
+		},
+	}
+
+	suite.Run("Should allow requests of any client while the global limit is not reached", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", counters, 1).Return(-1, time.Duration(0))
+
+		for _, clientID := range []string{"192.168.0.1", "192.168.0.2", ""} {
+			allowed, err := suite.Limiter.AllowRequest(clientID, "")
+			suite.True(allowed)
+			suite.NoError(err)
+		}
+		suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "ConsumeRules", 3)
+	})
+
+	suite.Run("Should not allow with GlobalLimitReached error when the global limit is reached, without blocking any client", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", counters, 1).Return(0, time.Millisecond*400)
+
+		allowed, err := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.False(allowed)
+		suite.ErrorIs(err, limiter.ErrGlobalLimitReached)
+		suite.NotErrorIs(err, limiter.ErrMaxNumberRequestsReached)
+
+		var limitErr *limiter.LimitError
+		suite.ErrorAs(err, &limitErr)
+		suite.Equal(limiter.DIMENSION_GLOBAL, limitErr.Dimension)
+		suite.WithinDuration(time.Now().Add(time.Millisecond*400), limitErr.ResetAt, time.Millisecond*100)
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "SaveClient")
+	})
+}
+
+func (suite *LimiterTestSuite) TestAccessList_Check() {
+	entries := []limiter.AccessEntry{
+		{Value: "10.0.0.1", Access: limiter.ACCESS_ALLOW},
+		{Value: "172.16.0.0/12", Access: limiter.ACCESS_ALLOW},
+		{Value: "172.16.5.0/24", Access: limiter.ACCESS_DENY},
+		{Value: "2001:db8::/32", Access: limiter.ACCESS_DENY},
+		{Value: "HealthCheckKey", Access: limiter.ACCESS_ALLOW},
+		{Value: "LeakedKey", Access: limiter.ACCESS_DENY},
+		{Value: "300.0.0.0/8", Access: limiter.ACCESS_DENY}, // invalid entries are ignored
+	}
+	suite.MockLimiterRepository.Mock.On("AccessEntries").Return(entries)
+
+	accessList := limiter.NewAccessList(suite.MockLimiterRepository)
+	accessList.Reload()
+
+	type AccessTestCase struct {
+		Name     string
+		Input    TestCaseInput
+		Found    bool
+		Expected int
+	}
+
+	testCases := []AccessTestCase{
+		{Name: "Should allow an allowlisted single IP", Input: TestCaseInput{ClientID: "10.0.0.1"}, Found: true, Expected: limiter.ACCESS_ALLOW},
+		{Name: "Should not find a neighbour of an allowlisted single IP", Input: TestCaseInput{ClientID: "10.0.0.2"}, Found: false},
+		{Name: "Should allow an IP within an allowlisted CIDR", Input: TestCaseInput{ClientID: "172.31.255.255"}, Found: true, Expected: limiter.ACCESS_ALLOW},
+		{Name: "Should deny an IP within a denylisted CIDR, even if it is allowlisted", Input: TestCaseInput{ClientID: "172.16.5.20"}, Found: true, Expected: limiter.ACCESS_DENY},
+		{Name: "Should deny an IPv6 within a denylisted CIDR", Input: TestCaseInput{ClientID: "2001:db8::1"}, Found: true, Expected: limiter.ACCESS_DENY},
+		{Name: "Should allow an allowlisted API Key from any IP", Input: TestCaseInput{ClientID: "8.8.8.8", ApiKeyID: "HealthCheckKey"}, Found: true, Expected: limiter.ACCESS_ALLOW},
+		{Name: "Should deny a denylisted API Key from an allowlisted IP", Input: TestCaseInput{ClientID: "10.0.0.1", ApiKeyID: "LeakedKey"}, Found: true, Expected: limiter.ACCESS_DENY},
+		{Name: "Should not find a client on neither list", Input: TestCaseInput{ClientID: "8.8.8.8", ApiKeyID: "OtherKey"}, Found: false},
+		{Name: "Should not find a client without a valid IP", Input: TestCaseInput{ClientID: "invalid"}, Found: false},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			access, found := accessList.Check(t.Input.ClientID, t.Input.ApiKeyID)
+			suite.Equal(t.Found, found)
+			if t.Found {
+				suite.Equal(t.Expected, access)
+			}
+		})
+	}
+
+	suite.Run("Should check fast against a large CIDR list", func() {
+		var large []limiter.AccessEntry
+		for i := 0; i < 65536; i++ {
+			large = append(large, limiter.AccessEntry{
+				Value:  fmt.Sprintf("10.%d.%d.0/24", i/256, i%256),
+				Access: limiter.ACCESS_DENY,
+			})
+		}
+
+		repository := &MockLimiterRepository{}
+		repository.Mock.On("AccessEntries").Return(large)
+		largeList := limiter.NewAccessList(repository)
+		largeList.Reload()
+
+		start := time.Now()
+		for i := 0; i < 10000; i++ {
+			access, found := largeList.Check(fmt.Sprintf("10.200.%d.1", i%256), "")
+			suite.True(found)
+			suite.Equal(limiter.ACCESS_DENY, access)
+		}
+		suite.Less(time.Since(start), time.Second)
+	})
+}
+
+func (suite *LimiterTestSuite) TestAccessList_Save() {
+	suite.Run("Should store a valid entry and apply it right away", func() {
+		repository := &MockLimiterRepository{}
+		entry := limiter.AccessEntry{Value: "192.168.0.0/16", Access: limiter.ACCESS_DENY}
+		repository.Mock.On("SaveAccessEntry", entry)
+		repository.Mock.On("AccessEntries").Return([]limiter.AccessEntry{entry})
+
+		accessList := limiter.NewAccessList(repository)
+		suite.NoError(accessList.Save(entry))
+		repository.AssertCalled(suite.T(), "SaveAccessEntry", entry)
+
+		access, found := accessList.Check("192.168.10.1", "")
+		suite.True(found)
+		suite.Equal(limiter.ACCESS_DENY, access)
+	})
+
+	for _, entry := range []limiter.AccessEntry{
+		{Value: "", Access: limiter.ACCESS_ALLOW},
+		{Value: "192.168.0.0/33", Access: limiter.ACCESS_ALLOW},
+		{Value: "192.168.0.1", Access: 5},
+	} {
+		suite.Run("Should return InvalidAccessEntry error without storing entry "+entry.Value, func() {
+			repository := &MockLimiterRepository{}
+			accessList := limiter.NewAccessList(repository)
+			suite.ErrorIs(accessList.Save(entry), limiter.ErrInvalidAccessEntry)
+			repository.AssertNotCalled(suite.T(), "SaveAccessEntry", entry)
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_AccessList() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.MockLimiterRepository.Mock.On("AccessEntries").Return([]limiter.AccessEntry{
+		{Value: "10.0.0.1", Access: limiter.ACCESS_ALLOW},
+		{Value: "192.168.0.0/16", Access: limiter.ACCESS_DENY},
+	})
+	accessList := limiter.NewAccessList(suite.MockLimiterRepository)
+	accessList.Reload()
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository).WithAccessList(accessList)
+
+	suite.Run("Should allow an allowlisted client without checking its limit", func() {
+		for i := 0; i < MaxRequests*2; i++ {
+			allowed, err := suite.Limiter.AllowRequest("10.0.0.1", "")
+			suite.True(allowed)
+			suite.NoError(err)
+		}
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "Client", "10.0.0.1")
+	})
+
+	suite.Run("Should not allow and return ClientDenied error for a denylisted client", func() {
+		allowed, err := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.False(allowed)
+		suite.Equal(limiter.ErrClientDenied, err)
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "Client", "192.168.0.1")
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_EscalatingBlock() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.BlockEscalationFactor = 10
+	suite.Config.MaxClientBlockTime = time.Hour
+	suite.Config.OffenseLookback = time.Hour
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+	clientID := "192.168.0.1"
+
+	type EscalationTestCase struct {
+		Name              string
+		Offense           *limiter.Offense
+		ExpectedLevel     int
+		ExpectedBlockTime time.Duration
+	}
+
+	testCases := []EscalationTestCase{
+		{
+			Name:              "Should block a first offender for the client block time",
+			Offense:           nil,
+			ExpectedLevel:     1,
+			ExpectedBlockTime: suite.Config.ClientBlockTime,
+		},
+		{
+			Name:              "Should multiply the block time of a second offense within the lookback",
+			Offense:           &limiter.Offense{ID: clientID, Count: 1, LastAt: time.Now().Add(-time.Minute)},
+			ExpectedLevel:     2,
+			ExpectedBlockTime: suite.Config.ClientBlockTime * 10,
+		},
+		{
+			Name:              "Should multiply the block time once per previous offense",
+			Offense:           &limiter.Offense{ID: clientID, Count: 2, LastAt: time.Now().Add(-time.Minute)},
+			ExpectedLevel:     3,
+			ExpectedBlockTime: suite.Config.ClientBlockTime * 100,
+		},
+		{
+			Name:              "Should cap the block time at the max client block time",
+			Offense:           &limiter.Offense{ID: clientID, Count: 5, LastAt: time.Now().Add(-time.Minute)},
+			ExpectedLevel:     6,
+			ExpectedBlockTime: time.Hour,
+		},
+		{
+			Name:              "Should decay one offense per lookback without being blocked",
+			Offense:           &limiter.Offense{ID: clientID, Count: 3, LastAt: time.Now().Add(-time.Hour*2 - time.Minute)},
+			ExpectedLevel:     2,
+			ExpectedBlockTime: suite.Config.ClientBlockTime * 10,
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+
+			limitedClient := limiter.Client{ID: clientID, CurrentRequests: MaxRequests}
+			blockedClient := limitedClient
+			blockedClient.Blocked = true
+			blockedClient.TTL = t.ExpectedBlockTime
+
+			suite.MockLimiterRepository.Mock.On("Client", clientID).Return(&limitedClient)
+			suite.MockLimiterRepository.Mock.On("Offense", clientID).Return(t.Offense)
+			suite.MockLimiterRepository.Mock.On("SaveOffense", mock.Anything)
+			suite.MockLimiterRepository.Mock.On("SaveClient", blockedClient)
+
+			before := time.Now()
+			allowed, err := suite.Limiter.AllowRequest(clientID, "")
+			suite.False(allowed)
+			suite.ErrorIs(err, limiter.ErrMaxNumberRequestsReached)
+
+			var limitErr *limiter.LimitError
+			suite.ErrorAs(err, &limitErr)
+			suite.Equal(t.ExpectedLevel, limitErr.PenaltyLevel)
+			suite.WithinDuration(before.Add(t.ExpectedBlockTime), limitErr.ResetAt, time.Second)
+
+			suite.MockLimiterRepository.AssertCalled(suite.T(), "SaveClient", blockedClient)
+			var saved limiter.Offense
+			for _, call := range suite.MockLimiterRepository.Calls {
+				if call.Method == "SaveOffense" {
+					saved = call.Arguments.Get(0).(limiter.Offense)
+				}
+			}
+			suite.Equal(clientID, saved.ID)
+			suite.Equal(t.ExpectedLevel, saved.Count)
+			suite.Equal(suite.Config.OffenseLookback*time.Duration(t.ExpectedLevel), saved.TTL)
+		})
+	}
+
+	suite.Run("Should report the penalty level of an already blocked client", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+
+		suite.MockLimiterRepository.Mock.On("Client", clientID).Return(&limiter.Client{ID: clientID, CurrentRequests: MaxRequests, Blocked: true})
+		suite.MockLimiterRepository.Mock.On("Offense", clientID).Return(&limiter.Offense{ID: clientID, Count: 4, LastAt: time.Now()})
+
+		allowed, err := suite.Limiter.AllowRequest(clientID, "")
+		suite.False(allowed)
+		suite.Equal(&limiter.LimitError{Err: limiter.ErrMaxNumberRequestsReached, PenaltyLevel: 4}, err)
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "SaveOffense", mock.Anything)
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Decide_DryRun() {
+	suite.Config.Name = "ip-shadow"
+	suite.Config.DryRun = true
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+	counterID := "ip-shadow:192.168.0.1"
+
+	suite.Run("Should allow and count the request of a client within the limit apart from other limiters", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		savedClient := limiter.Client{ID: counterID, CurrentRequests: 1, TTL: limiter.REQUESTS_PER_SECOND}
+		suite.MockLimiterRepository.Mock.On("Client", counterID).Return((*limiter.Client)(nil))
+		suite.MockLimiterRepository.Mock.On("SaveClient", savedClient)
+
+		decision := suite.Limiter.Decide("192.168.0.1", "", 1)
+		suite.Equal(limiter.Decision{Allowed: true, Remaining: MaxRequests - 1, Limit: MaxRequests}, decision)
+		suite.MockLimiterRepository.AssertCalled(suite.T(), "SaveClient", savedClient)
+	})
+
+	suite.Run("Should allow a request over the limit, reporting it would have been refused", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", counterID).Return(&limiter.Client{ID: counterID, CurrentRequests: MaxRequests})
+		suite.MockLimiterRepository.Mock.On("SaveClient", mock.Anything)
+
+		decision := suite.Limiter.Decide("192.168.0.1", "", 1)
+		suite.True(decision.Allowed)
+		suite.True(decision.Shadowed)
+		suite.ErrorIs(decision.Err, limiter.ErrMaxNumberRequestsReached)
+		suite.Equal("max_requests", limiter.Reason(decision.Err))
+
+		allowed, err := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+		suite.NoError(err)
+	})
+
+	suite.Run("Should refuse the request over the limit once the dry run is off", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.Limiter.Config.DryRun = false
+		suite.MockLimiterRepository.Mock.On("Client", counterID).Return(&limiter.Client{ID: counterID, Blocked: true})
+
+		decision := suite.Limiter.Decide("192.168.0.1", "", 1)
+		suite.Equal(limiter.Decision{Allowed: false, Err: limiter.ErrMaxNumberRequestsReached, Limit: MaxRequests}, decision)
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Reserve() {
+	suite.Config.Name = "reports"
+	suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+	suite.Config.MaxGlobalRequests = 200
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	suite.Run("Should return no delay for an allowed request", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(-1, time.Duration(0))
+
+		decision, delay := suite.Limiter.Reserve("192.168.0.1", "", 1)
+		suite.True(decision.Allowed)
+		suite.Zero(delay)
+	})
+
+	suite.Run("Should return the delay until the limit is reset for a refused request", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(0, time.Millisecond*400)
+
+		decision, delay := suite.Limiter.Reserve("192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.ErrorIs(decision.Err, limiter.ErrGlobalLimitReached)
+		suite.InDelta(time.Millisecond*400, delay, float64(time.Millisecond*100))
+	})
+
+	limiterIP := limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Second * ClientBlockTime,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		MaxIPRequests:         MaxRequests,
+	}, suite.MockLimiterRepository)
+
+	suite.Run("Should return the time left of the block for a blocked client", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		limiterIP.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", "192.168.0.1").Return(&limiter.Client{ID: "192.168.0.1", Blocked: true, TTL: time.Second * 2})
+
+		_, delay := limiterIP.Reserve("192.168.0.1", "", 1)
+		suite.InDelta(time.Second*2, delay, float64(time.Millisecond*100))
+	})
+
+	suite.Run("Should return at most the block time for a blocked client whose time left is unknown", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		limiterIP.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", "192.168.0.1").Return(&limiter.Client{ID: "192.168.0.1", Blocked: true})
+
+		_, delay := limiterIP.Reserve("192.168.0.1", "", 1)
+		suite.Equal(time.Second*ClientBlockTime, delay)
+	})
+
+	suite.Run("Should refuse without blocking the client that reached its limit, until its requests are reset", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		limiterIP.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", "192.168.0.1").
+			Return(&limiter.Client{ID: "192.168.0.1", CurrentRequests: MaxRequests, TTL: time.Millisecond * 400})
+
+		decision, delay := limiterIP.Reserve("192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.ErrorIs(decision.Err, limiter.ErrMaxNumberRequestsReached)
+		suite.InDelta(time.Millisecond*400, delay, float64(time.Millisecond*100))
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "SaveClient", mock.Anything)
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "SaveOffense", mock.Anything)
+	})
+
+	suite.Run("Should return no delay for a request that retrying would never allow", func() {
+		decision, delay := suite.Limiter.Reserve("192.168.0.1", "", 0)
+		suite.False(decision.Allowed)
+		suite.Equal(limiter.ErrInvalidCost, decision.Err)
+		suite.Zero(delay)
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Wait() {
+	suite.Config.Name = "reports"
+	suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+	suite.Config.MaxGlobalRequests = 200
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	suite.Run("Should wait until the request is allowed", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(0, time.Millisecond*50).Once()
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(-1, time.Duration(0))
+
+		start := time.Now()
+		decision := suite.Limiter.Wait(context.Background(), "192.168.0.1", "", 1)
+		suite.True(decision.Allowed)
+		suite.NoError(decision.Err)
+		suite.GreaterOrEqual(time.Since(start), time.Millisecond*40)
+		suite.MockLimiterRepository.AssertNumberOfCalls(suite.T(), "ConsumeRules", 2)
+	})
+
+	suite.Run("Should not wait when the request would only be allowed after the context deadline", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(0, time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		start := time.Now()
+		decision := suite.Limiter.Wait(ctx, "192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.ErrorIs(decision.Err, limiter.ErrGlobalLimitReached)
+		suite.Less(time.Since(start), time.Millisecond*50)
+	})
+
+	suite.Run("Should compare the context deadline on the wall clock, whatever the limiter clock", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		l := limiter.NewLimiter(suite.Config, suite.MockLimiterRepository).WithClock(limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)))
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(0, time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		start := time.Now()
+		decision := l.Wait(ctx, "192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.NotErrorIs(decision.Err, context.DeadlineExceeded)
+		suite.Less(time.Since(start), time.Millisecond*50)
+	})
+
+	suite.Run("Should stop waiting when the context is canceled", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", mock.Anything, 1).Return(0, time.Second)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*20, cancel)
+
+		decision := suite.Limiter.Wait(ctx, "192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.ErrorIs(decision.Err, limiter.ErrGlobalLimitReached)
+		suite.ErrorIs(decision.Err, context.Canceled)
+	})
+}
+
+func (suite *LimiterTestSuite) TestConcurrencyLimiter_Acquire() {
+	conf := limiter.ConcurrencyConfig{
+		Name:              "reports",
+		ClientCheckType:   limiter.CHECK_IP_OR_API_KEY,
+		MaxInFlight:       2,
+		MaxGlobalInFlight: 50,
+	}
+	slotsMatching := func(slots ...limiter.Slot) interface{} {
+		return mock.MatchedBy(func(lease limiter.Lease) bool {
+			return lease.ID != "" && assert.ObjectsAreEqual(slots, lease.Slots)
+		})
+	}
+
+	suite.Run("Should lease a slot of the IP and a global one", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("AcquireSlots", slotsMatching(
+			limiter.Slot{ClientID: "reports:192.168.0.1", MaxInFlight: 2},
+			limiter.Slot{ClientID: "reports:global", MaxInFlight: 50},
+		), limiter.DEFAULT_LEASE_TTL).Return(-1)
+
+		lease, err := concurrency.Acquire("192.168.0.1", "")
+		suite.NoError(err)
+		suite.NotNil(lease)
+	})
+
+	suite.Run("Should lease a slot of the API Key instead of the IP if it is sent", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("ApiKey", "goexpert-key").Return(&limiter.APIKey{ID: "goexpert-key", MaxRequests: 10})
+		repository.Mock.On("AcquireSlots", slotsMatching(
+			limiter.Slot{ClientID: "reports:goexpert-key", MaxInFlight: 2},
+			limiter.Slot{ClientID: "reports:global", MaxInFlight: 50},
+		), limiter.DEFAULT_LEASE_TTL).Return(-1)
+
+		_, err := concurrency.Acquire("192.168.0.1", "goexpert-key")
+		suite.NoError(err)
+	})
+
+	suite.Run("Should lease a slot of the IP if the API Key sent is not found", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("ApiKey", "spoofed-key").Return((*limiter.APIKey)(nil))
+		repository.Mock.On("AcquireSlots", slotsMatching(
+			limiter.Slot{ClientID: "reports:192.168.0.1", MaxInFlight: 2},
+			limiter.Slot{ClientID: "reports:global", MaxInFlight: 50},
+		), limiter.DEFAULT_LEASE_TTL).Return(-1)
+
+		_, err := concurrency.Acquire("192.168.0.1", "spoofed-key")
+		suite.NoError(err)
+	})
+
+	suite.Run("Should not lease and return ApiKeyNotFound error if the API Key required is not found", func() {
+		repository := &MockConcurrencyRepository{}
+		conf := conf
+		conf.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("ApiKey", "spoofed-key").Return((*limiter.APIKey)(nil))
+
+		_, err := concurrency.Acquire("192.168.0.1", "spoofed-key")
+		suite.Equal(limiter.ErrApiKeyNotFound, err)
+		repository.AssertNotCalled(suite.T(), "AcquireSlots", mock.Anything, mock.Anything)
+	})
+
+	suite.Run("Should not lease and return MaxConcurrentRequests error if the client slots are full", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("AcquireSlots", mock.Anything, limiter.DEFAULT_LEASE_TTL).Return(0)
+
+		lease, err := concurrency.Acquire("192.168.0.1", "")
+		suite.Nil(lease)
+		suite.ErrorIs(err, limiter.ErrMaxConcurrentRequests)
+
+		var limitErr *limiter.LimitError
+		suite.ErrorAs(err, &limitErr)
+		suite.Equal(limiter.DIMENSION_IP, limitErr.Dimension)
+	})
+
+	suite.Run("Should not lease and return GlobalLimitReached error if the global slots are full", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		repository.Mock.On("AcquireSlots", mock.Anything, limiter.DEFAULT_LEASE_TTL).Return(1)
+
+		_, err := concurrency.Acquire("192.168.0.1", "")
+		suite.ErrorIs(err, limiter.ErrGlobalLimitReached)
+
+		var limitErr *limiter.LimitError
+		suite.ErrorAs(err, &limitErr)
+		suite.WithinDuration(time.Now().Add(limiter.MIN_RETRY_DELAY), limitErr.ResetAt, time.Second)
+	})
+
+	suite.Run("Should not lease and return ClientDenied error if the client is denylisted", func() {
+		repository := &MockConcurrencyRepository{}
+		accessRepository := &MockLimiterRepository{}
+		accessRepository.Mock.On("AccessEntries").Return([]limiter.AccessEntry{
+			{Value: "192.168.0.0/16", Access: limiter.ACCESS_DENY},
+			{Value: "HealthCheckKey", Access: limiter.ACCESS_ALLOW},
+		})
+		accessList := limiter.NewAccessList(accessRepository)
+		accessList.Reload()
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository).WithAccessList(accessList)
+
+		lease, err := concurrency.Acquire("192.168.0.1", "")
+		suite.Nil(lease)
+		suite.Equal(limiter.ErrClientDenied, err)
+		repository.AssertNotCalled(suite.T(), "AcquireSlots", mock.Anything, mock.Anything)
+	})
+
+	suite.Run("Should lease no slots to an allowlisted client", func() {
+		repository := &MockConcurrencyRepository{}
+		accessRepository := &MockLimiterRepository{}
+		accessRepository.Mock.On("AccessEntries").Return([]limiter.AccessEntry{
+			{Value: "HealthCheckKey", Access: limiter.ACCESS_ALLOW},
+		})
+		accessList := limiter.NewAccessList(accessRepository)
+		accessList.Reload()
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository).WithAccessList(accessList)
+
+		lease, err := concurrency.Acquire("192.168.0.1", "HealthCheckKey")
+		suite.NoError(err)
+		suite.Empty(lease.Slots)
+
+		concurrency.Renew(lease)
+		concurrency.Release(lease)
+		repository.AssertNotCalled(suite.T(), "AcquireSlots", mock.Anything, mock.Anything)
+		repository.AssertNotCalled(suite.T(), "RenewSlots", mock.Anything, mock.Anything)
+		repository.AssertNotCalled(suite.T(), "ReleaseSlots", mock.Anything)
+	})
+
+	suite.Run("Should not lease and return InvalidClient error if the client is empty", func() {
+		repository := &MockConcurrencyRepository{}
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+
+		_, err := concurrency.Acquire("", "")
+		suite.Equal(limiter.ErrInvalidClient, err)
+		repository.AssertNotCalled(suite.T(), "AcquireSlots", mock.Anything, mock.Anything)
+	})
+
+	suite.Run("Should release and renew the lease slots", func() {
+		repository := &MockConcurrencyRepository{}
+		conf := conf
+		conf.LeaseTTL = time.Second * 5
+		concurrency := limiter.NewConcurrencyLimiter(conf, repository)
+		lease := &limiter.Lease{ID: "lease", Slots: []limiter.Slot{{ClientID: "reports:192.168.0.1", MaxInFlight: 2}}}
+		repository.Mock.On("RenewSlots", *lease, time.Second*5)
+		repository.Mock.On("ReleaseSlots", *lease)
+
+		concurrency.Renew(lease)
+		concurrency.Release(lease)
+		repository.AssertExpectations(suite.T())
+	})
+}
+
+func (suite *LimiterTestSuite) TestAdaptiveController() {
+	conf := limiter.AdaptiveConfig{
+		MinLimit:      2,
+		MaxLimit:      10,
+		TargetLatency: time.Millisecond * 200,
+		MaxErrorRate:  0.1,
+		Window:        time.Hour,
+	}
+
+	suite.Run("Should start at the max limit and keep it under a healthy backend", func() {
+		controller := limiter.NewAdaptiveController(conf)
+		suite.Equal(10, controller.Limit())
+
+		controller.Observe(time.Millisecond*50, false)
+		controller.Evaluate()
+		suite.Equal(10, controller.Limit())
+	})
+
+	suite.Run("Should halve the limit when the mean latency is above the target, down to the min limit", func() {
+		controller := limiter.NewAdaptiveController(conf)
+		for _, expected := range []int{5, 2, 2} {
+			controller.Observe(time.Millisecond*100, false)
+			controller.Observe(time.Millisecond*400, false)
+			controller.Evaluate()
+			suite.Equal(expected, controller.Limit())
+		}
+	})
+
+	suite.Run("Should halve the limit when the error rate is above the max", func() {
+		controller := limiter.NewAdaptiveController(conf)
+		for i := 0; i < 8; i++ {
+			controller.Observe(time.Millisecond, false)
+		}
+		controller.Observe(time.Millisecond, true)
+		controller.Observe(time.Millisecond, true)
+		controller.Evaluate()
+		suite.Equal(5, controller.Limit())
+	})
+
+	suite.Run("Should increase the limit one step per healthy window, up to the max limit", func() {
+		controller := limiter.NewAdaptiveController(conf)
+		controller.Observe(time.Second, false)
+		controller.Evaluate()
+
+		for _, expected := range []int{6, 7, 8, 9, 10, 10} {
+			controller.Observe(time.Millisecond, false)
+			controller.Evaluate()
+			suite.Equal(expected, controller.Limit())
+		}
+	})
+
+	suite.Run("Should keep the limit of a window without requests", func() {
+		controller := limiter.NewAdaptiveController(conf)
+		controller.Observe(time.Second, false)
+		controller.Evaluate()
+		controller.Evaluate()
+		suite.Equal(5, controller.Limit())
+	})
+
+	suite.Run("Should evaluate the window once it is over", func() {
+		conf := conf
+		conf.Window = 0
+		controller := limiter.NewAdaptiveController(conf)
+		controller.Observe(time.Second, false)
+		suite.Equal(5, controller.Limit())
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_AdaptiveLimit() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	controller := limiter.NewAdaptiveController(limiter.AdaptiveConfig{
+		MinLimit:      1,
+		MaxLimit:      MaxRequests,
+		TargetLatency: time.Millisecond * 200,
+		Window:        time.Hour,
+	})
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository).WithAdaptiveLimit(controller)
+	clientID := "192.168.0.1"
+
+	suite.Run("Should allow requests up to the max limit under a healthy backend", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", clientID).Return(&limiter.Client{ID: clientID, CurrentRequests: MaxRequests - 1})
+		suite.MockLimiterRepository.Mock.On("SaveClient", mock.Anything)
+
+		allowed, err := suite.Limiter.AllowRequest(clientID, "")
+		suite.True(allowed)
+		suite.NoError(err)
+	})
+
+	suite.Run("Should not allow requests above the decreased limit", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		suite.Limiter.Repository = suite.MockLimiterRepository
+		suite.MockLimiterRepository.Mock.On("Client", clientID).Return(&limiter.Client{ID: clientID, CurrentRequests: MaxRequests - 1})
+		suite.MockLimiterRepository.Mock.On("Offense", clientID).Return((*limiter.Offense)(nil))
+		suite.MockLimiterRepository.Mock.On("SaveClient", mock.Anything)
+
+		controller.Observe(time.Second, false)
+		controller.Evaluate()
+		suite.Equal(1, controller.Limit())
+
+		allowed, err := suite.Limiter.AllowRequest(clientID, "")
+		suite.False(allowed)
+		suite.Equal(limiter.ErrMaxNumberRequestsReached, err)
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_DecideRequest_Priority() {
+	suite.Config.Name = "reports"
+	suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+	suite.Config.MaxGlobalRequests = 200
+	suite.Config.PriorityShares = map[int]float64{
+		limiter.PRIORITY_LOW:    0.6,
+		limiter.PRIORITY_NORMAL: 0.8,
+		limiter.PRIORITY_HIGH:   0.95,
+	}
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository)
+
+	globalCounter := func(maxRequests int) []limiter.RuleCounter {
+		return []limiter.RuleCounter{{
+			ClientID: "global:reports",
+			Rule:     limiter.LimitRule{MaxRequests: maxRequests, Interval: suite.Config.RequestsLimitInterval},
+		}}
+	}
+
+	type PriorityTestCase struct {
+		Name                string
+		Request             limiter.Request
+		ApiKey              *limiter.APIKey
+		ExpectedMaxRequests int
+	}
+
+	testCases := []PriorityTestCase{
+		{
+			Name:                "Should limit anonymous requests to the low priority share",
+			Request:             limiter.Request{ClientID: "192.168.0.1", Cost: 1},
+			ExpectedMaxRequests: 120,
+		},
+		{
+			Name:                "Should limit requests of an API Key without plan priority to the normal priority share",
+			Request:             limiter.Request{ClientID: "192.168.0.1", APIKeyID: "key", Cost: 1},
+			ApiKey:              &limiter.APIKey{ID: "key", MaxRequests: 10},
+			ExpectedMaxRequests: 160,
+		},
+		{
+			Name:                "Should limit requests of an API Key to its plan priority share",
+			Request:             limiter.Request{ClientID: "192.168.0.1", APIKeyID: "key", Cost: 1},
+			ApiKey:              &limiter.APIKey{ID: "key", MaxRequests: 10, Priority: limiter.PRIORITY_HIGH},
+			ExpectedMaxRequests: 190,
+		},
+		{
+			Name:                "Should limit requests of an unknown API Key to the low priority share",
+			Request:             limiter.Request{ClientID: "192.168.0.1", APIKeyID: "unknown", Cost: 1},
+			ExpectedMaxRequests: 120,
+		},
+		{
+			Name:                "Should let requests of a priority without share use the whole global limit",
+			Request:             limiter.Request{ClientID: "192.168.0.1", Cost: 1, Priority: limiter.PRIORITY_CRITICAL},
+			ExpectedMaxRequests: 200,
+		},
+		{
+			Name:                "Should take the request priority over the API Key plan priority",
+			Request:             limiter.Request{ClientID: "192.168.0.1", APIKeyID: "key", Cost: 1, Priority: limiter.PRIORITY_LOW},
+			ExpectedMaxRequests: 120,
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+			suite.MockLimiterRepository.Mock.On("ApiKey", t.Request.APIKeyID).Return(t.ApiKey)
+			suite.MockLimiterRepository.Mock.On("ConsumeRules", globalCounter(t.ExpectedMaxRequests), 1).Return(0, time.Millisecond*100)
+
+			decision := suite.Limiter.DecideRequest(context.Background(), t.Request)
+			suite.False(decision.Allowed)
+			suite.ErrorIs(decision.Err, limiter.ErrGlobalLimitReached)
+			suite.MockLimiterRepository.AssertCalled(suite.T(), "ConsumeRules", globalCounter(t.ExpectedMaxRequests), 1)
+		})
+	}
+
+	suite.Run("Should not look the API Key up if the limiter has no priority shares", func() {
+		suite.MockLimiterRepository = &MockLimiterRepository{}
+		limiterNoShares := limiter.NewLimiter(limiter.LimiterConfig{
+			Name:                  "reports",
+			ClientCheckType:       limiter.CHECK_GLOBAL,
+			MaxGlobalRequests:     200,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		}, suite.MockLimiterRepository)
+		suite.MockLimiterRepository.Mock.On("ConsumeRules", globalCounter(200), 1).Return(-1, time.Duration(0))
+
+		decision := limiterNoShares.DecideRequest(context.Background(), limiter.Request{ClientID: "192.168.0.1", APIKeyID: "key", Cost: 1})
+		suite.True(decision.Allowed)
+		suite.MockLimiterRepository.AssertNotCalled(suite.T(), "ApiKey", "key")
+	})
+}
+
+func (suite *LimiterTestSuite) TestParsePriorityShares() {
+	shares, err := limiter.ParsePriorityShares("low=0.6, normal=0.8,HIGH=1")
+	suite.NoError(err)
+	suite.Equal(map[int]float64{
+		limiter.PRIORITY_LOW:    0.6,
+		limiter.PRIORITY_NORMAL: 0.8,
+		limiter.PRIORITY_HIGH:   1,
+	}, shares)
+
+	shares, err = limiter.ParsePriorityShares("")
+	suite.NoError(err)
+	suite.Empty(shares)
+
+	for _, invalid := range []string{"low", "urgent=0.5", "low=abc", "low=1.5", "low=-0.1"} {
+		_, err := limiter.ParsePriorityShares(invalid)
+		suite.Error(err, invalid)
+	}
+}
+
+func (suite *LimiterTestSuite) TestParseCron() {
+	type CronTestCase struct {
+		Expression string
+		Time       time.Time
+		Expected   bool
+	}
+
+	testCases := []CronTestCase{
+		{"* 1-3 * * *", time.Date(2024, 5, 10, 1, 0, 0, 0, time.UTC), true},
+		{"* 1-3 * * *", time.Date(2024, 5, 10, 3, 59, 0, 0, time.UTC), true},
+		{"* 1-3 * * *", time.Date(2024, 5, 10, 4, 0, 0, 0, time.UTC), false},
+		{"* 1-3 * * *", time.Date(2024, 5, 10, 0, 59, 0, 0, time.UTC), false},
+		{"*/15 9-17 * * 1-5", time.Date(2024, 5, 10, 9, 30, 0, 0, time.UTC), true},
+		{"*/15 9-17 * * 1-5", time.Date(2024, 5, 10, 9, 31, 0, 0, time.UTC), false},
+		{"*/15 9-17 * * 1-5", time.Date(2024, 5, 11, 9, 30, 0, 0, time.UTC), false},
+		{"0 0 * * 7", time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1,15 * 1", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1,15 * 1", time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1,15 * 1", time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC), false},
+		{"* * * 12 *", time.Date(2024, 12, 24, 18, 0, 0, 0, time.UTC), true},
+		{"* * * 12 *", time.Date(2024, 11, 24, 18, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, t := range testCases {
+		cron, err := limiter.ParseCron(t.Expression)
+		suite.NoError(err, t.Expression)
+		suite.Equal(t.Expected, cron.Matches(t.Time), "%s at %v", t.Expression, t.Time)
+	}
+
+	for _, invalid := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* 5-2 * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := limiter.ParseCron(invalid)
+		suite.Error(err, invalid)
+	}
+}
+
+func (suite *LimiterTestSuite) TestParseLimitSchedule() {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	suite.NoError(err)
+
+	schedule, err := limiter.ParseLimitSchedule("* 1-3 * * *|ip=10,global=500,api_key_factor=2; * 9-17 * * 1-5|ip=2,api_key_ip=1", saoPaulo)
+	suite.NoError(err)
+	suite.Len(schedule.Entries, 2)
+
+	override, active := schedule.Active(time.Date(2024, 5, 10, 4, 30, 0, 0, time.UTC))
+	suite.True(active)
+	suite.Equal(limiter.LimitOverride{MaxIPRequests: 10, MaxGlobalRequests: 500, APIKeyFactor: 2}, override)
+
+	override, active = schedule.Active(time.Date(2024, 5, 10, 13, 0, 0, 0, time.UTC))
+	suite.True(active)
+	suite.Equal(limiter.LimitOverride{MaxIPRequests: 2, MaxAPIKeyIPRequests: 1}, override)
+
+	_, active = schedule.Active(time.Date(2024, 5, 10, 1, 30, 0, 0, time.UTC))
+	suite.False(active)
+
+	for _, invalid := range []string{"* 1-3 * * *", "* 1-3 * * *|ip=0", "* 1-3 * * *|burst=2", "* 1-3 * *|ip=2", "* 1-3 * * *|api_key_factor=-1"} {
+		_, err := limiter.ParseLimitSchedule(invalid, time.UTC)
+		suite.Error(err, invalid)
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_AllowRequest_Schedule() {
+	schedule, err := limiter.ParseLimitSchedule("* 1-3 * * *|ip=10,api_key_factor=2;* 9-17 * * 1-5|ip=1", time.UTC)
+	suite.NoError(err)
+	suite.Config.Schedule = schedule
+	suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+	clock := limiter.NewFakeClock(time.Time{})
+	suite.Limiter = limiter.NewLimiter(suite.Config, suite.MockLimiterRepository).WithClock(clock)
+	clientID := "192.168.0.1"
+	apiKey := &limiter.APIKey{ID: "partner-key", MaxRequests: 5}
+
+	type ScheduleTestCase struct {
+		Name            string
+		Now             time.Time
+		ApiKeyID        string
+		CurrentRequests int
+		Expected        bool
+	}
+
+	testCases := []ScheduleTestCase{
+		{
+			Name:            "Should allow the IP above the configured limit during the batch window",
+			Now:             time.Date(2024, 5, 10, 2, 0, 0, 0, time.UTC),
+			CurrentRequests: MaxRequests + 5,
+			Expected:        true,
+		},
+		{
+			Name:            "Should allow the API Key up to twice its limit during the batch window",
+			Now:             time.Date(2024, 5, 10, 2, 0, 0, 0, time.UTC),
+			ApiKeyID:        apiKey.ID,
+			CurrentRequests: 9,
+			Expected:        true,
+		},
+		{
+			Name:            "Should limit the IP to the configured limit out of any schedule entry",
+			Now:             time.Date(2024, 5, 10, 5, 0, 0, 0, time.UTC),
+			CurrentRequests: MaxRequests,
+			Expected:        false,
+		},
+		{
+			Name:            "Should limit the API Key to its limit out of the batch window",
+			Now:             time.Date(2024, 5, 10, 5, 0, 0, 0, time.UTC),
+			ApiKeyID:        apiKey.ID,
+			CurrentRequests: 5,
+			Expected:        false,
+		},
+		{
+			Name:            "Should limit the IP below the configured limit during business hours",
+			Now:             time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC),
+			CurrentRequests: 1,
+			Expected:        false,
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.MockLimiterRepository = &MockLimiterRepository{}
+			suite.Limiter.Repository = suite.MockLimiterRepository
+			clock.Set(t.Now)
+
+			id := clientID
+			if t.ApiKeyID != "" {
+				id = t.ApiKeyID
+				suite.MockLimiterRepository.Mock.On("ApiKey", t.ApiKeyID).Return(apiKey)
+			}
+			suite.MockLimiterRepository.Mock.On("Client", id).Return(&limiter.Client{ID: id, CurrentRequests: t.CurrentRequests})
+			suite.MockLimiterRepository.Mock.On("SaveClient", mock.Anything)
+
+			allowed, _ := suite.Limiter.AllowRequest(clientID, t.ApiKeyID)
+			suite.Equal(t.Expected, allowed)
+		})
+	}
+}
+
+// newTimedLimiter returns a limiter storing its data in memory, all of its timing following the fake clock
+func (suite *LimiterTestSuite) newTimedLimiter(conf limiter.LimiterConfig) (*limiter.Limiter, *database.MemoryLimiterRepository, *limiter.FakeClock) {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	repository := database.NewMemoryLimiterRepository(clock)
+	return limiter.NewLimiter(conf, repository).WithClock(clock), repository, clock
+}
+
+// allowN makes n requests, returning how many were allowed
+func allowN(l *limiter.Limiter, n int, clientID, apiKeyID string) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if ok, _ := l.AllowRequest(clientID, apiKeyID); ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Window() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	clientID := "192.168.0.1"
+
+	suite.Run("Should reset the client requests once the limit interval is over", func() {
+		l, _, clock := suite.newTimedLimiter(suite.Config)
+		suite.Equal(MaxRequests, allowN(l, MaxRequests, clientID, ""))
+
+		clock.Advance(limiter.REQUESTS_PER_SECOND)
+		suite.Equal(MaxRequests, allowN(l, MaxRequests, clientID, ""))
+	})
+
+	suite.Run("Should block the client for the block time once the limit is reached", func() {
+		l, _, clock := suite.newTimedLimiter(suite.Config)
+		suite.Equal(MaxRequests, allowN(l, MaxRequests+1, clientID, ""))
+
+		clock.Advance(time.Second*ClientBlockTime - time.Millisecond)
+		allowed, err := l.AllowRequest(clientID, "")
+		suite.False(allowed)
+		suite.Equal(limiter.ErrMaxNumberRequestsReached, err)
+
+		clock.Advance(time.Millisecond)
+		suite.Equal(MaxRequests, allowN(l, MaxRequests+1, clientID, ""))
+	})
+
+	suite.Run("Should count every client apart", func() {
+		l, _, _ := suite.newTimedLimiter(suite.Config)
+		suite.Equal(MaxRequests, allowN(l, MaxRequests+1, clientID, ""))
+		suite.Equal(MaxRequests, allowN(l, MaxRequests+1, "192.168.0.2", ""))
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_EscalatingBlock() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.BlockEscalationFactor = 10
+	suite.Config.MaxClientBlockTime = time.Minute
+	suite.Config.OffenseLookback = time.Hour
+	l, _, clock := suite.newTimedLimiter(suite.Config)
+	clientID := "192.168.0.1"
+
+	blockFor := func(expected time.Duration, level int) {
+		suite.Equal(MaxRequests, allowN(l, MaxRequests, clientID, ""))
+		_, err := l.AllowRequest(clientID, "")
+
+		var limitErr *limiter.LimitError
+		suite.Require().ErrorAs(err, &limitErr)
+		suite.Equal(level, limitErr.PenaltyLevel)
+		suite.Equal(clock.Now().Add(expected), limitErr.ResetAt)
+
+		clock.Advance(expected - time.Millisecond)
+		allowed, _ := l.AllowRequest(clientID, "")
+		suite.False(allowed)
+		clock.Advance(time.Millisecond)
+	}
+
+	blockFor(time.Second*ClientBlockTime, 1)
+	blockFor(time.Second*ClientBlockTime*10, 2)
+	blockFor(time.Minute, 3)
+
+	// a lookback without being blocked decays one offense
+	clock.Advance(time.Hour)
+	blockFor(time.Minute, 3)
+
+	// every offense decays after a lookback each
+	clock.Advance(time.Hour * 3)
+	blockFor(time.Second*ClientBlockTime, 1)
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Rules() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.Rules = []limiter.LimitRule{{MaxRequests: 5, Interval: time.Minute}}
+	suite.Config.ClientBlockTime = 0
+	l, _, clock := suite.newTimedLimiter(suite.Config)
+	clientID := "192.168.0.1"
+
+	suite.Equal(MaxRequests, allowN(l, MaxRequests+1, clientID, ""))
+	clock.Advance(time.Second)
+	suite.Equal(2, allowN(l, MaxRequests, clientID, ""))
+
+	// the minute rule is still exhausted after the second one is reset
+	clock.Advance(time.Second * 30)
+	allowed, err := l.AllowRequest(clientID, "")
+	suite.False(allowed)
+	suite.ErrorIs(err, limiter.ErrMaxNumberRequestsReached)
+
+	clock.Advance(time.Second * 29)
+	suite.Equal(MaxRequests, allowN(l, MaxRequests+1, clientID, ""))
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Quota() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.DailyQuota = 5
+	l, _, clock := suite.newTimedLimiter(suite.Config)
+	clientID := "192.168.0.1"
+
+	suite.Equal(MaxRequests, allowN(l, MaxRequests, clientID, ""))
+	clock.Advance(time.Second)
+	suite.Equal(2, allowN(l, MaxRequests, clientID, ""))
+
+	clock.Advance(time.Hour)
+	_, err := l.AllowRequest(clientID, "")
+	var limitErr *limiter.LimitError
+	suite.Require().ErrorAs(err, &limitErr)
+	suite.ErrorIs(err, limiter.ErrQuotaExceeded)
+	suite.Equal(time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), limitErr.ResetAt)
+
+	clock.Set(limitErr.ResetAt.Add(-time.Millisecond))
+	allowed, _ := l.AllowRequest(clientID, "")
+	suite.False(allowed)
+
+	clock.Set(limitErr.ResetAt)
+	suite.Equal(MaxRequests, allowN(l, MaxRequests, clientID, ""))
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Global() {
+	suite.Config.Name = "reports"
+	suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+	suite.Config.MaxGlobalRequests = 5
+	l, _, clock := suite.newTimedLimiter(suite.Config)
+
+	suite.Equal(3, allowN(l, 3, "192.168.0.1", ""))
+	clock.Advance(time.Millisecond * 400)
+	suite.Equal(2, allowN(l, 3, "192.168.0.2", ""))
+
+	_, err := l.AllowRequest("192.168.0.3", "")
+	var limitErr *limiter.LimitError
+	suite.Require().ErrorAs(err, &limitErr)
+	suite.Equal(clock.Now().Add(time.Millisecond*600), limitErr.ResetAt)
+
+	decision, delay := l.Reserve("192.168.0.3", "", 1)
+	suite.False(decision.Allowed)
+	suite.Equal(time.Millisecond*600, delay)
+
+	clock.Advance(delay)
+	suite.Equal(5, allowN(l, 6, "192.168.0.3", ""))
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Reserve() {
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.BlockEscalationFactor = 2
+	suite.Config.OffenseLookback = time.Minute
+	l, repository, clock := suite.newTimedLimiter(suite.Config)
+
+	suite.Equal(MaxRequests, allowN(l, MaxRequests, "192.168.0.1", ""))
+	clock.Advance(time.Millisecond * 400)
+
+	for i := 0; i < 3; i++ {
+		decision, delay := l.Reserve("192.168.0.1", "", 1)
+		suite.False(decision.Allowed)
+		suite.Equal(limiter.REQUESTS_PER_SECOND-time.Millisecond*400, delay)
+	}
+	suite.False(repository.Client("192.168.0.1").Blocked)
+	suite.Nil(repository.Offense("192.168.0.1"))
+
+	clock.Advance(time.Millisecond * 600)
+	decision, delay := l.Reserve("192.168.0.1", "", 1)
+	suite.True(decision.Allowed)
+	suite.Zero(delay)
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Timing_Schedule() {
+	schedule, err := limiter.ParseLimitSchedule("* 1-3 * * *|ip=10", time.UTC)
+	suite.NoError(err)
+	suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+	suite.Config.Schedule = schedule
+	l, _, clock := suite.newTimedLimiter(suite.Config)
+	clientID := "192.168.0.1"
+
+	clock.Set(time.Date(2024, 5, 10, 0, 59, 59, 0, time.UTC))
+	suite.Equal(MaxRequests, allowN(l, 10, clientID, ""))
+
+	clock.Set(time.Date(2024, 5, 10, 1, 0, 0, 0, time.UTC).Add(time.Second * ClientBlockTime))
+	suite.Equal(10, allowN(l, 10, clientID, ""))
+
+	clock.Set(time.Date(2024, 5, 10, 4, 0, 0, 0, time.UTC))
+	suite.Equal(MaxRequests, allowN(l, 10, clientID, ""))
+}
+
+func (suite *LimiterTestSuite) TestConcurrencyLimiter_Timing_Lease() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	concurrency := limiter.NewConcurrencyLimiter(limiter.ConcurrencyConfig{
+		ClientCheckType: limiter.CHECK_IP_ONLY,
+		MaxInFlight:     2,
+		LeaseTTL:        time.Second * 10,
+	}, database.NewMemoryLimiterRepository(clock))
+	clientID := "192.168.0.1"
+
+	first, err := concurrency.Acquire(clientID, "")
+	suite.NoError(err)
+	_, err = concurrency.Acquire(clientID, "")
+	suite.NoError(err)
+	_, err = concurrency.Acquire(clientID, "")
+	suite.ErrorIs(err, limiter.ErrMaxConcurrentRequests)
+
+	// the second lease is never released nor renewed, as of a crashed instance
+	clock.Advance(time.Second * 6)
+	concurrency.Renew(first)
+	clock.Advance(time.Second * 4)
+
+	_, err = concurrency.Acquire(clientID, "")
+	suite.NoError(err)
+	_, err = concurrency.Acquire(clientID, "")
+	suite.ErrorIs(err, limiter.ErrMaxConcurrentRequests)
+
+	concurrency.Release(first)
+	_, err = concurrency.Acquire(clientID, "")
+	suite.NoError(err)
+}
+
+func (suite *LimiterTestSuite) TestAdaptiveController_Timing_Window() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	controller := limiter.NewAdaptiveController(limiter.AdaptiveConfig{
+		MinLimit:      1,
+		MaxLimit:      10,
+		TargetLatency: time.Millisecond * 200,
+		Window:        time.Second * 5,
+	}).WithClock(clock)
+
+	controller.Observe(time.Second, false)
+	clock.Advance(time.Second*5 - time.Millisecond)
+	controller.Observe(time.Second, false)
+	suite.Equal(10, controller.Limit())
+
+	clock.Advance(time.Millisecond)
+	controller.Observe(time.Second, false)
+	suite.Equal(5, controller.Limit())
+}
+
+func (suite *LimiterTestSuite) TestAdaptiveController_Timing_LimiterClock() {
+	conf := limiter.AdaptiveConfig{
+		MinLimit:      1,
+		MaxLimit:      10,
+		TargetLatency: time.Millisecond * 200,
+		Window:        time.Second * 5,
+	}
+
+	for name, setup := range map[string]func(clock limiter.Clock) *limiter.AdaptiveController{
+		"Should time the windows by the limiter clock set before the controller": func(clock limiter.Clock) *limiter.AdaptiveController {
+			controller := limiter.NewAdaptiveController(conf)
+			limiter.NewLimiter(suite.Config, nil).WithClock(clock).WithAdaptiveLimit(controller)
+			return controller
+		},
+		"Should time the windows by the limiter clock set after the controller": func(clock limiter.Clock) *limiter.AdaptiveController {
+			controller := limiter.NewAdaptiveController(conf)
+			limiter.NewLimiter(suite.Config, nil).WithAdaptiveLimit(controller).WithClock(clock)
+			return controller
+		},
+	} {
+		suite.Run(name, func() {
+			clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+			controller := setup(clock)
+
+			controller.Observe(time.Second, false)
+			suite.Equal(10, controller.Limit())
+
+			clock.Advance(time.Second * 5)
+			controller.Observe(time.Second, false)
+			suite.Equal(5, controller.Limit())
+		})
+	}
+}
+
+func (suite *LimiterTestSuite) TestLimiter_DecideRequest_Log() {
+	suite.Config.Name = "both"
+	suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+	l, repository, _ := suite.newTimedLimiter(suite.Config)
+	repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 1})
+
+	output := &bytes.Buffer{}
+	l.WithLogger(slog.New(slog.NewJSONHandler(output, nil)))
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+
+	// decode returns the last record logged
+	decode := func() map[string]any {
+		lines := bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n"))
+		var record map[string]any
+		suite.NoError(json.Unmarshal(lines[len(lines)-1], &record))
+		output.Reset()
+		return record
+	}
+
+	suite.Run("Should log the allowed decision along with the request, redacting the API Key", func() {
+		decision := l.DecideRequest(ctx, limiter.Request{ClientID: "192.168.0.1", APIKeyID: "goexpert-key", Cost: 1})
+		suite.True(decision.Allowed)
+		suite.NotContains(output.String(), "goexpert-key")
+
+		record := decode()
+		suite.Equal("INFO", record["level"])
+		suite.Equal("req-1", record[logging.REQUEST_ID_KEY])
+		suite.Equal("both", record["limiter"])
+		suite.Equal("192.168.0.1", record["client"])
+		suite.Equal("goex****", record["api_key"])
+		suite.Equal(true, record[logging.ALLOWED_KEY])
+		suite.Equal(float64(0), record["remaining"])
+	})
+
+	suite.Run("Should log the refused decision as a warning", func() {
+		decision := l.DecideRequest(ctx, limiter.Request{ClientID: "192.168.0.1", APIKeyID: "goexpert-key", Cost: 1})
+		suite.False(decision.Allowed)
+
+		record := decode()
+		suite.Equal("WARN", record["level"])
+		suite.Equal(false, record[logging.ALLOWED_KEY])
+		suite.Equal("max_requests", record["reason"])
+	})
+
+	suite.Run("Should log the failed decision as an error", func() {
+		decision := l.DecideRequest(ctx, limiter.Request{ClientID: "192.168.0.1", APIKeyID: "goexpert-key", Cost: 0})
+		suite.False(decision.Allowed)
+
+		record := decode()
+		suite.Equal("ERROR", record["level"])
+		suite.Equal("invalid_cost", record["reason"])
+	})
+}
+
+// RecordingEmitter records the events emitted
+type RecordingEmitter struct {
+	Events []limiter.Event
+}
+
+func (e *RecordingEmitter) Emit(event limiter.Event) {
+	e.Events = append(e.Events, event)
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Events() {
+	suite.Run("Should emit the client blocked once, until the block expires", func() {
+		suite.Config.Name = "ip"
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		l, _, clock := suite.newTimedLimiter(suite.Config)
+		emitter := &RecordingEmitter{}
+		l.WithEvents(emitter)
+
+		allowN(l, MaxRequests+3, "192.168.0.1", "")
+		suite.Equal([]limiter.Event{{
+			Type:      limiter.EVENT_CLIENT_BLOCKED,
+			Limiter:   "ip",
+			ClientID:  "192.168.0.1",
+			Dimension: limiter.DIMENSION_IP,
+			At:        clock.Now(),
+			ExpiresAt: clock.Now().Add(time.Second * ClientBlockTime),
+		}}, emitter.Events)
+	})
+
+	suite.Run("Should emit the quota exhausted by the last request it allows, redacting the API Key", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+		l, repository, clock := suite.newTimedLimiter(suite.Config)
+		repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10, DailyQuota: 2})
+		emitter := &RecordingEmitter{}
+		l.WithEvents(emitter)
+
+		suite.Equal(2, allowN(l, 4, "192.168.0.1", "goexpert-key"))
+		suite.Len(emitter.Events, 1)
+		suite.Equal(limiter.EVENT_QUOTA_EXHAUSTED, emitter.Events[0].Type)
+		suite.Equal("goex****", emitter.Events[0].APIKey)
+		suite.Equal("daily", emitter.Events[0].Period)
+		suite.Equal(2, emitter.Events[0].Count)
+		suite.Equal(time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), emitter.Events[0].ExpiresAt)
+		suite.Equal(clock.Now(), emitter.Events[0].At)
+	})
+
+	suite.Run("Should emit a spike of unknown API Keys once per window", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+		suite.Config.KeyNotFoundSpike = 3
+		suite.Config.KeyNotFoundWindow = time.Minute
+		l, _, clock := suite.newTimedLimiter(suite.Config)
+		emitter := &RecordingEmitter{}
+		l.WithEvents(emitter)
+
+		allowN(l, 2, "192.168.0.1", "guess-1")
+		suite.Empty(emitter.Events)
+
+		allowN(l, 5, "192.168.0.1", "guess-2")
+		suite.Len(emitter.Events, 1)
+		suite.Equal(limiter.EVENT_API_KEY_NOT_FOUND_SPIKE, emitter.Events[0].Type)
+		suite.Equal(3, emitter.Events[0].Count)
+		suite.Equal(clock.Now().Add(time.Minute), emitter.Events[0].ExpiresAt)
+
+		clock.Advance(time.Minute)
+		allowN(l, 3, "192.168.0.1", "guess-3")
+		suite.Len(emitter.Events, 2)
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Unblock() {
+	suite.Run("Should lift the block, allowing the client again", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		l, _, _ := suite.newTimedLimiter(suite.Config)
+
+		allowN(l, MaxRequests+1, "192.168.0.1", "")
+		allowed, err := l.AllowRequest("192.168.0.1", "")
+		suite.False(allowed)
+		suite.Equal(limiter.ErrMaxNumberRequestsReached, err)
+
+		client := l.Unblock("192.168.0.1")
+		suite.NotNil(client)
+		suite.True(client.Blocked)
+		allowed, err = l.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+		suite.Nil(err)
+	})
+
+	suite.Run("Should reset the rule counters and the offenses, lifting the block without penalty", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		suite.Config.Rules = []limiter.LimitRule{{MaxRequests: 2, Interval: time.Hour}}
+		suite.Config.BlockEscalationFactor = 2
+		suite.Config.OffenseLookback = time.Hour
+		l, _, _ := suite.newTimedLimiter(suite.Config)
+
+		suite.Equal(2, allowN(l, 3, "192.168.0.1", ""))
+		suite.NotNil(l.Unblock("192.168.0.1"))
+
+		decision := l.DecideRequest(context.Background(), limiter.Request{ClientID: "192.168.0.1", Cost: 1})
+		suite.True(decision.Allowed)
+		suite.NoError(decision.Err)
+
+		suite.Equal(1, allowN(l, 2, "192.168.0.1", ""))
+		decision = l.DecideRequest(context.Background(), limiter.Request{ClientID: "192.168.0.1", Cost: 1})
+		var limitErr *limiter.LimitError
+		suite.ErrorAs(decision.Err, &limitErr)
+		suite.Equal(1, limitErr.PenaltyLevel)
+	})
+
+	suite.Run("Should emit the end of the block lifted, telling an API Key apart from an IP", func() {
+		suite.Config.Name = "both"
+		suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+		l, repository, clock := suite.newTimedLimiter(suite.Config)
+		repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 1})
+		emitter := &RecordingEmitter{}
+		l.WithEvents(emitter)
+
+		allowN(l, MaxRequests+1, "192.168.0.1", "")
+		allowN(l, 2, "192.168.0.1", "goexpert-key")
+		emitter.Events = nil
+
+		suite.NotNil(l.Unblock("192.168.0.1"))
+		suite.NotNil(l.Unblock("goexpert-key"))
+		suite.Equal([]limiter.Event{
+			{Type: limiter.EVENT_CLIENT_UNBLOCKED, Limiter: "both", ClientID: "192.168.0.1", Dimension: limiter.DIMENSION_IP, At: clock.Now()},
+			{Type: limiter.EVENT_CLIENT_UNBLOCKED, Limiter: "both", APIKey: "goex****", APIKeyID: "goexpert-key", Dimension: limiter.DIMENSION_API_KEY, At: clock.Now()},
+		}, emitter.Events)
+	})
+
+	suite.Run("Should return nil if the client is not blocked", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		l, _, _ := suite.newTimedLimiter(suite.Config)
+
+		suite.Nil(l.Unblock("192.168.0.1"))
+		allowN(l, 1, "192.168.0.1", "")
+		suite.Nil(l.Unblock("192.168.0.1"))
+	})
+}
+
+func (suite *LimiterTestSuite) TestLimiter_Usage() {
+	suite.Run("Should record the allowed and refused requests of the IP per minute and hour", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		suite.Config.UsageRetention = limiter.UsageRetention{Minute: time.Hour, Hour: 24 * time.Hour}
+		l, _, clock := suite.newTimedLimiter(suite.Config)
+
+		allowN(l, MaxRequests+2, "192.168.0.1", "")
+		clock.Advance(time.Minute)
+		allowN(l, 1, "192.168.0.2", "")
+
+		from := clock.Now().Add(-time.Hour)
+		minutes := l.UsageHistory(limiter.DIMENSION_IP, "192.168.0.1", limiter.USAGE_MINUTE, from, clock.Now())
+		suite.Len(minutes, 1)
+		suite.Equal(MaxRequests, minutes[0].Allowed)
+		suite.Equal(2, minutes[0].Refused)
+		suite.Equal(MaxRequests, minutes[0].Cost)
+
+		hours := l.UsageHistory(limiter.DIMENSION_IP, "192.168.0.1", limiter.USAGE_HOUR, from, clock.Now())
+		suite.Len(hours, 1)
+		suite.Equal(MaxRequests+2, hours[0].Requests())
+
+		top := l.TopUsage(limiter.DIMENSION_IP, limiter.USAGE_MINUTE, time.Hour, 1)
+		suite.Len(top, 1)
+		suite.Equal("192.168.0.1", top[0].ID)
+	})
+
+	suite.Run("Should record the API Key, but not the unknown ones", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+		suite.Config.UsageRetention = limiter.UsageRetention{Minute: time.Hour}
+		l, repository, clock := suite.newTimedLimiter(suite.Config)
+		repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10})
+
+		allowN(l, 3, "192.168.0.1", "goexpert-key")
+		allowN(l, 3, "192.168.0.1", "unknown-key")
+
+		top := l.TopUsage(limiter.DIMENSION_API_KEY, limiter.USAGE_MINUTE, time.Hour, 10)
+		suite.Len(top, 1)
+		suite.Equal("goexpert-key", top[0].ID)
+		suite.Equal(3, top[0].Allowed)
+		suite.Empty(l.UsageHistory(limiter.DIMENSION_IP, "192.168.0.1", limiter.USAGE_HOUR, clock.Now().Add(-time.Hour), clock.Now()))
+		suite.Equal(6, l.TopUsage(limiter.DIMENSION_IP, limiter.USAGE_MINUTE, time.Hour, 1)[0].Requests())
+	})
+
+	suite.Run("Should not record the API Key of the limiters that do not check it", func() {
+		for _, checkType := range []int{limiter.CHECK_IP_ONLY, limiter.CHECK_GLOBAL} {
+			suite.Config.ClientCheckType = checkType
+			suite.Config.MaxGlobalRequests = 10
+			suite.Config.UsageRetention = limiter.UsageRetention{Minute: time.Hour}
+			l, _, _ := suite.newTimedLimiter(suite.Config)
+
+			allowN(l, 2, "192.168.0.1", "spoofed-key")
+
+			suite.Empty(l.TopUsage(limiter.DIMENSION_API_KEY, limiter.USAGE_MINUTE, time.Hour, 10))
+			suite.Equal(2, l.TopUsage(limiter.DIMENSION_IP, limiter.USAGE_MINUTE, time.Hour, 1)[0].Requests())
+		}
+	})
+
+	suite.Run("Should add the usage of every identity and bucket in a single repository call", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_OR_API_KEY
+		suite.Config.UsageRetention = limiter.UsageRetention{Minute: time.Hour, Hour: 24 * time.Hour}
+		l, memory, clock := suite.newTimedLimiter(suite.Config)
+		memory.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10})
+		repository := &usageRecorder{MemoryLimiterRepository: memory}
+		l.Repository = repository
+
+		allowN(l, 1, "192.168.0.1", "goexpert-key")
+
+		suite.Len(repository.calls, 1)
+		suite.ElementsMatch([]limiter.Usage{
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.1", Granularity: limiter.USAGE_MINUTE, Start: clock.Now(), Allowed: 1, Cost: 1, TTL: time.Hour},
+			{Kind: limiter.DIMENSION_API_KEY, ID: "goexpert-key", Granularity: limiter.USAGE_MINUTE, Start: clock.Now(), Allowed: 1, Cost: 1, TTL: time.Hour},
+			{Kind: limiter.DIMENSION_IP, ID: "192.168.0.1", Granularity: limiter.USAGE_HOUR, Start: clock.Now(), Allowed: 1, Cost: 1, TTL: 24 * time.Hour},
+			{Kind: limiter.DIMENSION_API_KEY, ID: "goexpert-key", Granularity: limiter.USAGE_HOUR, Start: clock.Now(), Allowed: 1, Cost: 1, TTL: 24 * time.Hour},
+		}, repository.calls[0])
+	})
+
+	suite.Run("Should not record the usage without retention", func() {
+		suite.Config.ClientCheckType = limiter.CHECK_IP_ONLY
+		suite.Config.UsageRetention = limiter.UsageRetention{}
+		l, _, _ := suite.newTimedLimiter(suite.Config)
+
+		allowN(l, 1, "192.168.0.1", "")
+		suite.Empty(l.TopUsage(limiter.DIMENSION_IP, limiter.USAGE_MINUTE, time.Hour, 1))
+	})
+}
+
+// usageRecorder records the usages added to the repository by each call
+type usageRecorder struct {
+	*database.MemoryLimiterRepository
+	calls [][]limiter.Usage
+}
+
+func (r *usageRecorder) AddUsage(usages ...limiter.Usage) {
+	r.calls = append(r.calls, usages)
+	r.MemoryLimiterRepository.AddUsage(usages...)
+}
@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// escalates reports whether the block time escalates for repeat offenders
+func (l *Limiter) escalates() bool {
+	return l.Config.BlockEscalationFactor > 1 && l.Config.OffenseLookback > 0
+}
+
+// blockClient blocks the client that reached its limit for the penalty block time,
+// returning the error that reports it. resetIn is how long until the limit reached is reset, 0 if unknown,
+// the client being able to request again once both the block and the limit are over.
+// A reservation blocks no one, the client being able to request again once its requests are reset
+func (l *Limiter) blockClient(c clientCheck, client Client, resetIn time.Duration) error {
+	if l.reserving {
+		resetIn = max(client.TTL, resetIn)
+		if resetIn <= 0 {
+			resetIn = l.Config.RequestsLimitInterval
+		}
+		return &LimitError{
+			Err:       ErrMaxNumberRequestsReached,
+			ResetAt:   l.now().Add(resetIn),
+			Dimension: c.Dimension,
+		}
+	}
+
+	blockTime, level := l.penalty(c.ClientID)
+
+	client.Blocked = true
+	client.TTL = blockTime
+	l.Repository.SaveClient(client)
+
+	l.logger().Warn("client blocked",
+		slog.String("dimension", c.kind),
+		slog.Duration("block_time", blockTime),
+		slog.Int("penalty_level", level),
+	)
+	l.emit(Event{
+		Type:         EVENT_CLIENT_BLOCKED,
+		Dimension:    c.kind,
+		PenaltyLevel: level,
+		ExpiresAt:    l.now().Add(blockTime),
+	})
+	if level == 0 && resetIn <= 0 {
+		return limitReachedError(c.Dimension)
+	}
+	return &LimitError{
+		Err:          ErrMaxNumberRequestsReached,
+		ResetAt:      l.now().Add(max(blockTime, resetIn)),
+		Dimension:    c.Dimension,
+		PenaltyLevel: level,
+	}
+}
+
+// blockedError returns the error of a client that is already blocked.
+// A reservation reports when the block is over if the repository tells the time left of the client
+func (l *Limiter) blockedError(c clientCheck, client Client) error {
+	l.logger().Debug("client already blocked", slog.String("dimension", c.kind))
+	if l.reserving && client.TTL > 0 {
+		return &LimitError{
+			Err:       ErrMaxNumberRequestsReached,
+			ResetAt:   l.now().Add(client.TTL),
+			Dimension: c.Dimension,
+		}
+	}
+	if !l.escalates() {
+		return limitReachedError(c.Dimension)
+	}
+
+	level := 0
+	if offense := l.Repository.Offense(c.ClientID); offense != nil {
+		level = offense.Count
+	}
+	return &LimitError{
+		Err:          ErrMaxNumberRequestsReached,
+		Dimension:    c.Dimension,
+		PenaltyLevel: level,
+	}
+}
+
+// penalty records a new offense of the client, returning its block time and penalty level.
+// The level is 0 if the block time does not escalate
+func (l *Limiter) penalty(clientID string) (time.Duration, int) {
+	if !l.escalates() {
+		return l.Config.ClientBlockTime, 0
+	}
+
+	now := l.now()
+	count := 0
+	if offense := l.Repository.Offense(clientID); offense != nil {
+		count = decayedOffenses(*offense, now, l.Config.OffenseLookback)
+	}
+	count++
+
+	l.Repository.SaveOffense(Offense{
+		ID:     clientID,
+		Count:  count,
+		LastAt: now,
+		TTL:    l.Config.OffenseLookback * time.Duration(count),
+	})
+
+	return escalatedBlockTime(
+		l.Config.ClientBlockTime,
+		l.Config.BlockEscalationFactor,
+		l.Config.MaxClientBlockTime,
+		count,
+	), count
+}
+
+// decayedOffenses returns the offenses count after decaying one per lookback since the last one
+func decayedOffenses(offense Offense, now time.Time, lookback time.Duration) int {
+	decayed := int(now.Sub(offense.LastAt) / lookback)
+	return max(offense.Count-decayed, 0)
+}
+
+// escalatedBlockTime multiplies the block time by the factor once per previous offense, up to maxBlockTime if set
+func escalatedBlockTime(blockTime time.Duration, factor int, maxBlockTime time.Duration, level int) time.Duration {
+	for i := 1; i < level; i++ {
+		blockTime *= time.Duration(factor)
+		if maxBlockTime > 0 && blockTime >= maxBlockTime {
+			return maxBlockTime
+		}
+	}
+
+	if maxBlockTime > 0 && blockTime > maxBlockTime {
+		return maxBlockTime
+	}
+	return blockTime
+}
+
+// Unblock resets the requests, the rule counters and the offenses of the client, as an IP or an API Key,
+// lifting its block without penalty. It returns the client as it was if it was blocked, nil otherwise, emitting the end of the block
+func (l *Limiter) Unblock(clientID string) *Client {
+	id := l.counterID(clientID)
+	client := l.Repository.Client(id)
+	if client == nil || !client.Blocked {
+		return nil
+	}
+
+	var rules []LimitRule
+	for _, counter := range l.ruleCounters(id, 0) {
+		rules = append(rules, counter.Rule)
+	}
+	l.Repository.DeleteClient(id, rules)
+	l.emitUnblocked(clientID)
+	return client
+}
+
+// emitUnblocked emits the end of the block of the client lifted before it expired,
+// telling whether the client is an IP, an API Key or an API Key used from an IP as `<API Key>@<IP>`
+func (l *Limiter) emitUnblocked(clientID string) {
+	if l.Events == nil {
+		return
+	}
+
+	req, dimension := Request{ClientID: clientID}, DIMENSION_IP
+	if apiKeyID, ip, found := strings.Cut(clientID, "@"); found {
+		req, dimension = Request{ClientID: ip, APIKeyID: apiKeyID}, DIMENSION_API_KEY_IP
+	} else if l.Repository.ApiKey(clientID) != nil {
+		req, dimension = Request{APIKeyID: clientID}, DIMENSION_API_KEY
+	}
+	l.decider(context.Background(), req).emit(Event{Type: EVENT_CLIENT_UNBLOCKED, Dimension: dimension})
+}
@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var priorityNames = map[int]string{
+	PRIORITY_LOW:      "low",
+	PRIORITY_NORMAL:   "normal",
+	PRIORITY_HIGH:     "high",
+	PRIORITY_CRITICAL: "critical",
+}
+
+// priority returns the request priority class. Requests without one take the API Key plan priority,
+// anonymous requests being PRIORITY_LOW. API Keys are only looked up if the priority is used
+func (l *Limiter) priority(req Request) int {
+	if req.Priority != PRIORITY_DEFAULT {
+		return req.Priority
+	}
+	if len(l.Config.PriorityShares) == 0 {
+		return PRIORITY_DEFAULT
+	}
+
+	if req.APIKeyID != "" {
+		if apiKey := l.Repository.ApiKey(req.APIKeyID); apiKey != nil {
+			if apiKey.Priority == PRIORITY_DEFAULT {
+				return PRIORITY_NORMAL
+			}
+			return apiKey.Priority
+		}
+	}
+	return PRIORITY_LOW
+}
+
+// priorityShare returns the part of maxRequests the priority class can use
+func (l *Limiter) priorityShare(maxRequests, priority int) int {
+	share, found := l.Config.PriorityShares[priority]
+	if !found || share >= 1 {
+		return maxRequests
+	}
+	return int(float64(maxRequests) * max(share, 0))
+}
+
+// PriorityName returns the name of the priority class, as "high"
+func PriorityName(priority int) string {
+	if name, found := priorityNames[priority]; found {
+		return name
+	}
+	return "default"
+}
+
+// ParsePriority parses a priority class name, as "high", reporting false if it is unknown
+func ParsePriority(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for priority, n := range priorityNames {
+		if n == name {
+			return priority, true
+		}
+	}
+	return PRIORITY_DEFAULT, false
+}
+
+// ParsePriorityShares parses a comma separated list of shares in the `<priority>=<share>` format,
+// as "low=0.6,normal=0.8,high=0.95"
+func ParsePriorityShares(shares string) (map[int]float64, error) {
+	parsed := map[int]float64{}
+	for _, share := range strings.Split(shares, ",") {
+		share = strings.TrimSpace(share)
+		if share == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(share, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid priority share %q, expected <priority>=<share>", share)
+		}
+
+		priority, ok := ParsePriority(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid priority on priority share %q", share)
+		}
+
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil || s < 0 || s > 1 {
+			return nil, fmt.Errorf("invalid share on priority share %q, expected a number from 0 to 1", share)
+		}
+		parsed[priority] = s
+	}
+	return parsed, nil
+}
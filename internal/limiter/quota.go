@@ -0,0 +1,111 @@
+package limiter
+
+import "time"
+
+// quotaLimit is the max requests a client can make within a quota period
+type quotaLimit struct {
+	Period      int
+	MaxRequests int
+}
+
+// ipQuotas returns the quotas configured for IP clients
+func (l *Limiter) ipQuotas() []quotaLimit {
+	return newQuotaLimits(l.Config.DailyQuota, l.Config.MonthlyQuota)
+}
+
+// apiKeyQuotas returns the quotas configured for the API Key
+func apiKeyQuotas(apiKey *APIKey) []quotaLimit {
+	return newQuotaLimits(apiKey.DailyQuota, apiKey.MonthlyQuota)
+}
+
+func newQuotaLimits(daily, monthly int) []quotaLimit {
+	var quotas []quotaLimit
+	if daily > 0 {
+		quotas = append(quotas, quotaLimit{Period: QUOTA_DAILY, MaxRequests: daily})
+	}
+	if monthly > 0 {
+		quotas = append(quotas, quotaLimit{Period: QUOTA_MONTHLY, MaxRequests: monthly})
+	}
+	return quotas
+}
+
+// checkQuotaRequests checks the client quotas alongside the clients requests limits.
+// The quotas are charged before the requests limits are checked, being refunded if the request is refused by them
+func (l *Limiter) checkQuotaRequests(clientID string, quotas []quotaLimit, cost int, checks ...clientCheck) (bool, error) {
+	for _, c := range checks {
+		l.setLimit(c.MaxRequests)
+	}
+
+	if clientID == "" || len(quotas) == 0 {
+		return l.checkClientRequests(cost, checks...)
+	}
+
+	for _, q := range quotas {
+		if cost > q.MaxRequests {
+			return false, costExceedsLimitError(cost, q.MaxRequests)
+		}
+	}
+
+	now := l.now()
+	counters := make([]QuotaCounter, 0, len(quotas))
+	for _, q := range quotas {
+		start, resetAt := quotaPeriod(q.Period, now, l.Config.QuotaLocation)
+		counters = append(counters, QuotaCounter{
+			Quota:       Quota{ID: clientID, Period: q.Period, Start: start, ResetAt: resetAt},
+			MaxRequests: q.MaxRequests,
+		})
+	}
+
+	// the quotas are charged ahead, so that concurrent requests can not all pass the check and exceed them
+	charged, requests := l.Repository.ConsumeQuotas(counters, cost)
+	if !charged {
+		var exhausted *Quota
+		for i, c := range counters {
+			if requests[i]+cost > c.MaxRequests && (exhausted == nil || c.Quota.ResetAt.After(exhausted.ResetAt)) {
+				exhausted = &counters[i].Quota
+			}
+		}
+		return false, &LimitError{Err: ErrQuotaExceeded, ResetAt: exhausted.ResetAt}
+	}
+
+	allowed, err := l.checkClientRequests(cost, checks...)
+	if !allowed {
+		l.Repository.ConsumeQuotas(counters, -cost)
+		return allowed, err
+	}
+
+	for i, c := range counters {
+		if requests[i] >= c.MaxRequests && requests[i]-cost < c.MaxRequests {
+			l.emit(Event{Type: EVENT_QUOTA_EXHAUSTED, Period: quotaPeriodName(c.Quota.Period), Count: c.MaxRequests, ExpiresAt: c.Quota.ResetAt})
+		}
+
+		// the rules left are unknown, so the quota left is not the requests left either
+		if l.remaining >= 0 {
+			l.setRemaining(max(c.MaxRequests-requests[i], 0))
+		}
+	}
+	return allowed, err
+}
+
+func quotaPeriodName(period int) string {
+	if period == QUOTA_MONTHLY {
+		return "monthly"
+	}
+	return "daily"
+}
+
+// quotaPeriod returns the calendar aligned start and end of the quota period containing now
+func quotaPeriod(period int, now time.Time, loc *time.Location) (time.Time, time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+
+	if period == QUOTA_MONTHLY {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
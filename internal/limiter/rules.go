@@ -0,0 +1,109 @@
+package limiter
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkClientRules checks the clients requests against every limit rule at once.
+// The rules are only charged if all of them allow the request
+func (l *Limiter) checkClientRules(checks []clientCheck, cost int) (bool, error) {
+	var counters []RuleCounter
+	var counterChecks []clientCheck
+	for _, c := range checks {
+		client := l.Repository.Client(c.ClientID)
+		if client != nil && client.Blocked {
+			return false, l.blockedError(c, *client)
+		}
+
+		for _, counter := range l.ruleCounters(c.ClientID, c.MaxRequests) {
+			if cost > counter.Rule.MaxRequests {
+				return false, costExceedsLimitError(cost, counter.Rule.MaxRequests)
+			}
+			counters = append(counters, counter)
+			counterChecks = append(counterChecks, c)
+		}
+	}
+
+	exceeded, resetIn := l.Repository.ConsumeRules(counters, cost)
+	if exceeded >= 0 {
+		c := counterChecks[exceeded]
+		rule := counters[exceeded].Rule
+		l.logger().Debug("limit rule reached",
+			slog.String("dimension", c.kind),
+			slog.Int("max", rule.MaxRequests),
+			slog.Duration("interval", rule.Interval),
+		)
+		return false, l.blockClient(c, Client{ID: c.ClientID}, resetIn)
+	}
+
+	for _, c := range checks {
+		l.logger().Debug("client requests allowed by the rules", slog.String("dimension", c.kind), slog.Int("rules", len(l.Config.Rules)+1))
+	}
+	return true, nil
+}
+
+// ruleCounters returns the client counters of the max requests per RequestsLimitInterval and of every configured rule.
+// The counters are kept by client and interval, so the rules sharing an interval are merged into the strictest one
+// rather than charging the same counter twice
+func (l *Limiter) ruleCounters(clientID string, maxRequests int) []RuleCounter {
+	counters := make([]RuleCounter, 0, len(l.Config.Rules)+1)
+	counters = append(counters, RuleCounter{
+		ClientID: clientID,
+		Rule: LimitRule{
+			MaxRequests: maxRequests,
+			Interval:    l.Config.RequestsLimitInterval,
+		},
+	})
+
+rules:
+	for _, rule := range l.Config.Rules {
+		for i := range counters {
+			if counters[i].Rule.Interval == rule.Interval {
+				counters[i].Rule.MaxRequests = min(counters[i].Rule.MaxRequests, rule.MaxRequests)
+				continue rules
+			}
+		}
+		counters = append(counters, RuleCounter{ClientID: clientID, Rule: rule})
+	}
+	return counters
+}
+
+// ParseLimitRules parses a comma separated list of rules in the `<max requests>/<interval>` format,
+// as "300/1m,5000/1h". Each rule must have its own interval
+func ParseLimitRules(rules string) ([]LimitRule, error) {
+	var parsed []LimitRule
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		maxRequests, interval, found := strings.Cut(rule, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid limit rule %q, expected <max requests>/<interval>", rule)
+		}
+
+		max, err := strconv.Atoi(maxRequests)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid max requests on limit rule %q", rule)
+		}
+
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid interval on limit rule %q", rule)
+		}
+
+		for _, p := range parsed {
+			if p.Interval == duration {
+				return nil, fmt.Errorf("duplicated interval on limit rule %q", rule)
+			}
+		}
+
+		parsed = append(parsed, LimitRule{MaxRequests: max, Interval: duration})
+	}
+	return parsed, nil
+}
@@ -0,0 +1,227 @@
+package limiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LimitOverride replaces the limiter limits while its schedule entry is active, 0 keeps the configured limit
+type LimitOverride struct {
+	MaxIPRequests       int
+	MaxGlobalRequests   int
+	MaxAPIKeyIPRequests int
+
+	// APIKeyFactor multiplies the max requests of every API Key
+	APIKeyFactor float64
+}
+
+// ScheduleEntry overrides the limits during the minutes matched by a cron expression
+type ScheduleEntry struct {
+	Cron     *CronExpression
+	Override LimitOverride
+}
+
+// LimitSchedule holds the limit overrides of a limiter by time of day and calendar
+type LimitSchedule struct {
+	Entries []ScheduleEntry
+
+	// Location is the timezone the cron expressions are evaluated in, UTC if nil
+	Location *time.Location
+}
+
+// Active returns the override of the first entry matching the time, or no override if none does
+func (s *LimitSchedule) Active(now time.Time) (LimitOverride, bool) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	now = now.In(loc)
+	for _, entry := range s.Entries {
+		if entry.Cron.Matches(now) {
+			return entry.Override, true
+		}
+	}
+	return LimitOverride{}, false
+}
+
+// ParseLimitSchedule parses a semicolon separated list of entries in the `<cron expression>|<overrides>` format,
+// the overrides being a comma separated list of ip, global, api_key_ip and api_key_factor values,
+// as "* 1-3 * * *|ip=10,api_key_factor=2;* 9-17 * * 1-5|ip=2"
+func ParseLimitSchedule(schedule string, loc *time.Location) (*LimitSchedule, error) {
+	parsed := &LimitSchedule{Location: loc}
+	for _, entry := range strings.Split(schedule, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		expression, overrides, found := strings.Cut(entry, "|")
+		if !found {
+			return nil, fmt.Errorf("invalid schedule entry %q, expected <cron expression>|<overrides>", entry)
+		}
+
+		cron, err := ParseCron(expression)
+		if err != nil {
+			return nil, err
+		}
+
+		override, err := parseLimitOverride(overrides)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overrides on schedule entry %q: %w", entry, err)
+		}
+		parsed.Entries = append(parsed.Entries, ScheduleEntry{Cron: cron, Override: override})
+	}
+	return parsed, nil
+}
+
+func parseLimitOverride(overrides string) (LimitOverride, error) {
+	var override LimitOverride
+	for _, o := range strings.Split(overrides, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(o, "=")
+		if !found {
+			return LimitOverride{}, fmt.Errorf("expected <limit>=<value>, got %q", o)
+		}
+
+		if name == "api_key_factor" {
+			factor, err := strconv.ParseFloat(value, 64)
+			if err != nil || factor <= 0 {
+				return LimitOverride{}, fmt.Errorf("invalid api_key_factor %q", value)
+			}
+			override.APIKeyFactor = factor
+			continue
+		}
+
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return LimitOverride{}, fmt.Errorf("invalid %s limit %q", name, value)
+		}
+
+		switch name {
+		case "ip":
+			override.MaxIPRequests = limit
+		case "global":
+			override.MaxGlobalRequests = limit
+		case "api_key_ip":
+			override.MaxAPIKeyIPRequests = limit
+		default:
+			return LimitOverride{}, fmt.Errorf("unknown limit %q", name)
+		}
+	}
+	return override, nil
+}
+
+// CronExpression matches times by minute, hour, day of month, month and day of week, as the cron format.
+// Both days match either one if neither is `*`, as on cron
+type CronExpression struct {
+	minutes     []bool
+	hours       []bool
+	daysOfMonth []bool
+	months      []bool
+	daysOfWeek  []bool
+
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// cronFields are the bounds of each cron field
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a 5 field cron expression, each field being `*` or a comma separated list
+// of values or `a-b` ranges, optionally stepped by `/n`, as "*/15 9-17 * * 1-5"
+func ParseCron(expression string) (*CronExpression, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", expression)
+	}
+
+	parsed := make([][]bool, len(fields))
+	for i, field := range fields {
+		values, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s on cron expression %q: %w", cronFields[i].name, expression, err)
+		}
+		parsed[i] = values
+	}
+
+	return &CronExpression{
+		minutes:       parsed[0],
+		hours:         parsed[1],
+		daysOfMonth:   parsed[2],
+		months:        parsed[3],
+		daysOfWeek:    parsed[4],
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}, nil
+}
+
+// Matches reports whether the minute of the time is matched, in the time location
+func (c *CronExpression) Matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	// sunday is either 0 or 7
+	dayOfWeek := c.daysOfWeek[int(t.Weekday())] || (t.Weekday() == time.Sunday && c.daysOfWeek[7])
+	if !c.anyDayOfMonth && !c.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+func parseCronField(field string, min, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = s
+		}
+
+		from, to := min, max
+		if rangePart != "*" {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(start); err != nil {
+				return nil, fmt.Errorf("invalid value %q", start)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(end); err != nil {
+					return nil, fmt.Errorf("invalid value %q", end)
+				}
+			} else if stepped {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%q out of the %d-%d range", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
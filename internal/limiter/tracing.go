@@ -0,0 +1,47 @@
+package limiter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+
+// startDecisionSpan starts the span of a decision, the client identity is left out of it
+func startDecisionSpan(ctx context.Context, conf LimiterConfig, req Request) (context.Context, trace.Span) {
+	name := conf.Name
+	if name == "" {
+		name = "default"
+	}
+
+	return otel.Tracer(TRACER_NAME).Start(ctx, "Limiter.Decide", trace.WithAttributes(
+		attribute.String("limiter.policy", name),
+		attribute.Int("limiter.check_type", conf.ClientCheckType),
+		attribute.Bool("limiter.dry_run", conf.DryRun),
+		attribute.Int("limiter.cost", req.Cost),
+		attribute.Bool("limiter.api_key", req.APIKeyID != ""),
+	))
+}
+
+// endDecisionSpan records the decision on its span, refusals being recorded as errors
+func endDecisionSpan(span trace.Span, decision Decision) {
+	span.SetAttributes(
+		attribute.Bool("limiter.allowed", decision.Allowed),
+		attribute.Bool("limiter.shadowed", decision.Shadowed),
+	)
+	if decision.Remaining >= 0 {
+		span.SetAttributes(attribute.Int("limiter.remaining", decision.Remaining))
+	}
+
+	if decision.Err != nil {
+		span.SetAttributes(attribute.String("limiter.reason", Reason(decision.Err)))
+		span.RecordError(decision.Err)
+		if !decision.Allowed {
+			span.SetStatus(codes.Error, decision.Err.Error())
+		}
+	}
+}
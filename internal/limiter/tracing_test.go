@@ -0,0 +1,111 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	Exporter *tracetest.InMemoryExporter
+	Limiter  *limiter.Limiter
+}
+
+func TestTracingSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
+
+func (suite *TracingTestSuite) SetupSuite() {
+	suite.Exporter = tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(suite.Exporter)))
+}
+
+func (suite *TracingTestSuite) SetupTest() {
+	suite.Exporter.Reset()
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = limiter.NewLimiter(limiter.LimiterConfig{
+		Name:                  "ip",
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Second * ClientBlockTime,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		MaxIPRequests:         2,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)
+}
+
+// attributes returns the attributes of the span
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func (suite *TracingTestSuite) TestLimiter_Decide_Span() {
+	suite.Run("Should record the allowed decision", func() {
+		suite.Exporter.Reset()
+		allowed, err := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+		suite.NoError(err)
+
+		spans := suite.Exporter.GetSpans()
+		suite.Len(spans, 1)
+		suite.Equal("Limiter.Decide", spans[0].Name)
+		suite.Equal(codes.Unset, spans[0].Status.Code)
+
+		attrs := attributes(spans[0])
+		suite.Equal("ip", attrs["limiter.policy"].AsString())
+		suite.True(attrs["limiter.allowed"].AsBool())
+		suite.Equal(int64(1), attrs["limiter.remaining"].AsInt64())
+	})
+
+	suite.Run("Should record the refused decision as an error", func() {
+		suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.Exporter.Reset()
+
+		allowed, err := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.False(allowed)
+		suite.Equal(limiter.ErrMaxNumberRequestsReached, err)
+
+		spans := suite.Exporter.GetSpans()
+		suite.Len(spans, 1)
+		suite.Equal(codes.Error, spans[0].Status.Code)
+		suite.Len(spans[0].Events, 1)
+
+		attrs := attributes(spans[0])
+		suite.False(attrs["limiter.allowed"].AsBool())
+		suite.Equal(int64(0), attrs["limiter.remaining"].AsInt64())
+		suite.Equal("max_requests", attrs["limiter.reason"].AsString())
+	})
+
+	suite.Run("Should leave the client identity out of the span", func() {
+		suite.Exporter.Reset()
+		suite.Limiter.AllowRequest("192.168.0.2", "")
+
+		for _, kv := range suite.Exporter.GetSpans()[0].Attributes {
+			suite.NotEqual("192.168.0.2", kv.Value.Emit())
+		}
+	})
+}
+
+func (suite *TracingTestSuite) TestLimiter_DecideRequest_ParentSpan() {
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	suite.Limiter.DecideRequest(ctx, limiter.Request{ClientID: "192.168.0.1", Cost: 1})
+	parent.End()
+
+	spans := suite.Exporter.GetSpans()
+	suite.Len(spans, 2)
+	suite.Equal("Limiter.Decide", spans[0].Name)
+	suite.Equal(parent.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+	suite.Equal(parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+}
@@ -0,0 +1,79 @@
+package limiter
+
+import "time"
+
+// recordUsage adds the decision to the usage buckets of the request IP and API Key, in a single repository call.
+// The API Key is only recorded once the client check found it, as the ones not checked could be anything
+func (l *Limiter) recordUsage(req Request, decision Decision) {
+	retention := l.Config.UsageRetention
+	if retention == (UsageRetention{}) {
+		return
+	}
+
+	usage := Usage{Limiter: l.Config.Name, Refused: 1}
+	if decision.Allowed {
+		usage = Usage{Limiter: l.Config.Name, Allowed: 1, Cost: req.Cost}
+	}
+
+	var identities []Usage
+	if req.ClientID != "" {
+		ip := usage
+		ip.Kind, ip.ID = DIMENSION_IP, req.ClientID
+		identities = append(identities, ip)
+	}
+	if l.apiKey != nil {
+		apiKey := usage
+		apiKey.Kind, apiKey.ID = DIMENSION_API_KEY, l.apiKey.ID
+		identities = append(identities, apiKey)
+	}
+
+	now := l.now()
+	var usages []Usage
+	for _, bucket := range []struct {
+		granularity time.Duration
+		retention   time.Duration
+	}{
+		{USAGE_MINUTE, retention.Minute},
+		{USAGE_HOUR, retention.Hour},
+	} {
+		if bucket.retention <= 0 {
+			continue
+		}
+		for _, identity := range identities {
+			identity.Granularity = bucket.granularity
+			identity.Start = now.Truncate(bucket.granularity)
+			identity.TTL = bucket.retention
+			usages = append(usages, identity)
+		}
+	}
+
+	if len(usages) > 0 {
+		l.Repository.AddUsage(usages...)
+	}
+}
+
+// UsageHistory returns the usage buckets of the identity started within [from, to), the oldest first.
+// Buckets without requests are left out
+func (l *Limiter) UsageHistory(kind, id string, granularity time.Duration, from, to time.Time) []Usage {
+	return l.Repository.UsageHistory(UsageQuery{
+		Limiter:     l.Config.Name,
+		Kind:        kind,
+		ID:          id,
+		Granularity: granularity,
+		From:        from.Truncate(granularity),
+		To:          to,
+	})
+}
+
+// TopUsage returns the n identities of the kind with the most requests, allowed or refused,
+// within the buckets of the granularity of the window ending now, as the top talkers of the last hour
+func (l *Limiter) TopUsage(kind string, granularity, window time.Duration, n int) []Usage {
+	now := l.now()
+	return l.Repository.TopUsage(UsageQuery{
+		Limiter:     l.Config.Name,
+		Kind:        kind,
+		Granularity: granularity,
+		From:        now.Add(-window).Truncate(granularity),
+		To:          now.Truncate(granularity).Add(granularity),
+	}, n)
+}
@@ -0,0 +1,87 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MIN_RETRY_DELAY is the least a refused request waits before being retried
+const MIN_RETRY_DELAY = time.Millisecond * 10
+
+// Reserve checks the request like Decide, also returning how long to wait before retrying it if it was refused.
+// Refusing a reservation blocks no one nor escalates the client penalty, the delay being the time left until the limit is reset.
+// Requests that would never be allowed by retrying, as of denied clients, return no delay
+func (l *Limiter) Reserve(clientID, apiKeyID string, cost int) (Decision, time.Duration) {
+	return l.ReserveRequest(context.Background(), Request{ClientID: clientID, APIKeyID: apiKeyID, Cost: cost})
+}
+
+// ReserveRequest reserves the request like Reserve, along with its priority
+func (l *Limiter) ReserveRequest(ctx context.Context, req Request) (Decision, time.Duration) {
+	decision := l.decideRequest(ctx, req, true)
+	if decision.Allowed {
+		return decision, 0
+	}
+	return decision, l.RetryDelay(decision.Err)
+}
+
+// Wait retries the request until it is allowed, the context is done or the next retry would be after the context deadline.
+// The retries are reservations, so waiting does not penalize the client.
+// A request refused because the context is done reports both the limit and the context errors.
+// Waiting always runs on real time: the delays are told by the limiter clock, but slept on the wall clock
+// and compared with the context deadline, which is a wall clock time
+func (l *Limiter) Wait(ctx context.Context, clientID, apiKeyID string, cost int) Decision {
+	return l.WaitRequest(ctx, Request{ClientID: clientID, APIKeyID: apiKeyID, Cost: cost})
+}
+
+// WaitRequest waits for the request like Wait, along with its priority.
+// Only the final decision is observed, recorded on the usage and logged, not every retry
+func (l *Limiter) WaitRequest(ctx context.Context, req Request) Decision {
+	for {
+		start := time.Now()
+		decision, decider := l.evaluate(ctx, req, true)
+		elapsed := time.Since(start)
+
+		var delay time.Duration
+		if !decision.Allowed {
+			delay = l.RetryDelay(decision.Err)
+		}
+		deadline, ok := ctx.Deadline()
+		if delay == 0 || (ok && time.Now().Add(delay).After(deadline)) {
+			decider.report(ctx, req, decision, elapsed)
+			return decision
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			decision.Err = fmt.Errorf("%w: %w", decision.Err, ctx.Err())
+			decider.report(ctx, req, decision, elapsed)
+			return decision
+		case <-timer.C:
+		}
+	}
+}
+
+// RetryDelay returns how long a request refused with err waits until it can be allowed, 0 if it never can.
+// It is the time left until the limit is reset if the error reports it
+func (l *Limiter) RetryDelay(err error) time.Duration {
+	if !errors.Is(err, ErrMaxNumberRequestsReached) &&
+		!errors.Is(err, ErrQuotaExceeded) &&
+		!errors.Is(err, ErrGlobalLimitReached) {
+		return 0
+	}
+
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) && !limitErr.ResetAt.IsZero() {
+		return max(limitErr.ResetAt.Sub(l.now()), MIN_RETRY_DELAY)
+	}
+
+	// the client was just blocked, or the repository does not tell the time left of its block
+	if l.Config.ClientBlockTime > 0 {
+		return l.Config.ClientBlockTime
+	}
+	return max(l.Config.RequestsLimitInterval, MIN_RETRY_DELAY)
+}
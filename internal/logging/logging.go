@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+const FORMAT_JSON = "json"
+const FORMAT_TEXT = "text"
+
+// ALLOWED_KEY is the attribute allowed requests are logged with, the records sampled by the SamplingHandler
+const ALLOWED_KEY = "allowed"
+
+// REQUEST_ID_KEY is the attribute the request ID is logged with
+const REQUEST_ID_KEY = "request_id"
+
+var ErrInvalidLogFormat = errors.New("the log format is invalid")
+var ErrInvalidLogLevel = errors.New("the log level is invalid")
+
+type Options struct {
+	// Format is either FORMAT_JSON or FORMAT_TEXT
+	Format string
+
+	Level slog.Level
+
+	// AllowedSampling logs one of every AllowedSampling allowed requests, every one of them if 1 or less
+	AllowedSampling int
+}
+
+// NewLogger returns a logger writing to w on the format and level of the options,
+// sampling the allowed requests
+func NewLogger(w io.Writer, opts Options) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case FORMAT_JSON, "":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	case FORMAT_TEXT:
+		handler = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("%w: %q, expected %s or %s", ErrInvalidLogFormat, opts.Format, FORMAT_JSON, FORMAT_TEXT)
+	}
+
+	if opts.AllowedSampling > 1 {
+		handler = NewSamplingHandler(handler, opts.AllowedSampling)
+	}
+	return slog.New(handler), nil
+}
+
+// ParseLevel parses a level name, as "debug", "info", "warn" or "error"
+func ParseLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidLogLevel, level)
+	}
+	return parsed, nil
+}
+
+// SamplingHandler logs one of every n records of allowed requests, the ones with ALLOWED_KEY set to true.
+// Every other record is logged
+type SamplingHandler struct {
+	handler slog.Handler
+	n       uint64
+	count   *atomic.Uint64
+}
+
+func NewSamplingHandler(handler slog.Handler, n int) *SamplingHandler {
+	return &SamplingHandler{
+		handler: handler,
+		n:       uint64(max(n, 1)),
+		count:   &atomic.Uint64{},
+	}
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if isAllowed(record) && (h.count.Add(1)-1)%h.n != 0 {
+		return nil
+	}
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{handler: h.handler.WithAttrs(attrs), n: h.n, count: h.count}
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{handler: h.handler.WithGroup(name), n: h.n, count: h.count}
+}
+
+func isAllowed(record slog.Record) bool {
+	allowed := false
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == ALLOWED_KEY {
+			allowed = attr.Value.Kind() == slog.KindBool && attr.Value.Bool()
+			return false
+		}
+		return true
+	})
+	return allowed
+}
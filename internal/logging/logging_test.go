@@ -0,0 +1,132 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+	Output *bytes.Buffer
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
+
+func (suite *LoggingTestSuite) SetupTest() {
+	suite.Output = &bytes.Buffer{}
+}
+
+// lines returns the lines logged
+func (suite *LoggingTestSuite) lines() []string {
+	return strings.Split(strings.TrimSpace(suite.Output.String()), "\n")
+}
+
+func (suite *LoggingTestSuite) TestNewLogger() {
+	suite.Run("Should log JSON by default", func() {
+		suite.Output.Reset()
+		logger, err := logging.NewLogger(suite.Output, logging.Options{})
+		suite.NoError(err)
+
+		logger.Info("decision", slog.Int("cost", 2))
+		var record map[string]any
+		suite.NoError(json.Unmarshal(suite.Output.Bytes(), &record))
+		suite.Equal("decision", record["msg"])
+		suite.Equal(float64(2), record["cost"])
+	})
+
+	suite.Run("Should log text", func() {
+		suite.Output.Reset()
+		logger, err := logging.NewLogger(suite.Output, logging.Options{Format: "text"})
+		suite.NoError(err)
+
+		logger.Info("decision", slog.Int("cost", 2))
+		suite.Contains(suite.Output.String(), "msg=decision cost=2")
+	})
+
+	suite.Run("Should not log below the level", func() {
+		suite.Output.Reset()
+		logger, err := logging.NewLogger(suite.Output, logging.Options{Level: slog.LevelWarn})
+		suite.NoError(err)
+
+		logger.Info("allowed")
+		logger.Warn("refused")
+		suite.Len(suite.lines(), 1)
+		suite.Contains(suite.Output.String(), "refused")
+	})
+
+	suite.Run("Should return error if the format is unknown", func() {
+		_, err := logging.NewLogger(suite.Output, logging.Options{Format: "xml"})
+		suite.ErrorIs(err, logging.ErrInvalidLogFormat)
+	})
+}
+
+func (suite *LoggingTestSuite) TestParseLevel() {
+	type TestCase struct {
+		Input    string
+		Expected slog.Level
+	}
+
+	for _, t := range []TestCase{
+		{Input: "debug", Expected: slog.LevelDebug},
+		{Input: "INFO", Expected: slog.LevelInfo},
+		{Input: " warn ", Expected: slog.LevelWarn},
+		{Input: "error", Expected: slog.LevelError},
+	} {
+		level, err := logging.ParseLevel(t.Input)
+		suite.NoError(err)
+		suite.Equal(t.Expected, level)
+	}
+
+	_, err := logging.ParseLevel("verbose")
+	suite.ErrorIs(err, logging.ErrInvalidLogLevel)
+}
+
+func (suite *LoggingTestSuite) TestSamplingHandler() {
+	logger, err := logging.NewLogger(suite.Output, logging.Options{AllowedSampling: 3})
+	suite.NoError(err)
+
+	for i := 0; i < 7; i++ {
+		logger.Info("decision", slog.Bool(logging.ALLOWED_KEY, true), slog.Int("i", i))
+	}
+	logger.Warn("decision", slog.Bool(logging.ALLOWED_KEY, false))
+	logger.With(slog.String("limiter", "ip")).Info("decision", slog.Bool(logging.ALLOWED_KEY, true), slog.Int("i", 7))
+
+	lines := suite.lines()
+	suite.Len(lines, 4)
+	suite.Contains(lines[0], `"i":0`)
+	suite.Contains(lines[1], `"i":3`)
+	suite.Contains(lines[2], `"i":6`)
+	suite.Contains(lines[3], `"allowed":false`)
+}
+
+func (suite *LoggingTestSuite) TestRedact() {
+	suite.Equal("", logging.Redact(""))
+	suite.Equal("****", logging.Redact("short"))
+	suite.Equal("goex****", logging.Redact("goexpert-key"))
+
+	logger, err := logging.NewLogger(suite.Output, logging.Options{})
+	suite.NoError(err)
+	logger.Info("decision", slog.Any("api_key", logging.Secret("goexpert-key")))
+	suite.Contains(suite.Output.String(), `"api_key":"goex****"`)
+	suite.NotContains(suite.Output.String(), "goexpert-key")
+}
+
+func (suite *LoggingTestSuite) TestRequestID() {
+	suite.Equal("", logging.RequestID(context.Background()))
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	suite.Equal("req-1", logging.RequestID(ctx))
+
+	id := logging.NewRequestID()
+	suite.Len(id, 16)
+	suite.NotEqual(id, logging.NewRequestID())
+}
@@ -0,0 +1,25 @@
+package logging
+
+import "log/slog"
+
+// REDACTED_PREFIX is how many leading characters of a secret are logged
+const REDACTED_PREFIX = 4
+
+// Secret is a value, as an API Key, logged with all but its first characters masked
+type Secret string
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(Redact(string(s)))
+}
+
+// Redact masks all but the first REDACTED_PREFIX characters of the secret,
+// masking all of it if it is too short for the prefix not to give it away
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= REDACTED_PREFIX*2 {
+		return "****"
+	}
+	return secret[:REDACTED_PREFIX] + "****"
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// Decision results
+const (
+	RESULT_ALLOWED  = "allowed"
+	RESULT_REJECTED = "rejected"
+	RESULT_ERRORED  = "errored"
+
+	// RESULT_SHADOWED is a request a limiter on dry run would have rejected
+	RESULT_SHADOWED = "shadowed"
+)
+
+var checkTypeNames = map[int]string{
+	limiter.CHECK_IP_ONLY:        "ip",
+	limiter.CHECK_API_KEY_ONLY:   "api_key",
+	limiter.CHECK_IP_OR_API_KEY:  "ip_or_api_key",
+	limiter.CHECK_IP_AND_API_KEY: "ip_and_api_key",
+	limiter.CHECK_GLOBAL:         "global",
+}
+
+// Metrics holds the limiter collectors, registered on their own registry.
+// Labels only take values out of bounded sets, never client IPs or API Keys
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	Decisions          *prometheus.CounterVec
+	DecisionDuration   *prometheus.HistogramVec
+	RepositoryDuration *prometheus.HistogramVec
+
+	blocked *blockedClients
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		Decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "limiter_decisions_total",
+			Help: "Requests checked by the limiters, by limiter, check type, identity kind, result and reason.",
+		}, []string{"limiter", "check_type", "identity", "result", "reason"}),
+		DecisionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "limiter_decision_duration_seconds",
+			Help:    "Time taken to check a request, by limiter and check type.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+		}, []string{"limiter", "check_type"}),
+		RepositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "limiter_repository_duration_seconds",
+			Help:    "Time taken by the limiter repository calls, by operation.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+		}, []string{"operation"}),
+		blocked: newBlockedClients(),
+	}
+
+	m.Registry.MustRegister(
+		m.Decisions,
+		m.DecisionDuration,
+		m.RepositoryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "limiter_blocked_clients",
+			Help: "Clients currently blocked by this instance.",
+		}, func() float64 { return float64(m.blocked.Count(time.Now())) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// ObserveDecision records the decision of a limiter and how long it took, as a limiter.DecisionObserver
+func (m *Metrics) ObserveDecision(conf limiter.LimiterConfig, req limiter.Request, decision limiter.Decision, elapsed time.Duration) {
+	name := limiterName(conf)
+	checkType := CheckTypeName(conf.ClientCheckType)
+
+	m.Decisions.WithLabelValues(name, checkType, identityKind(req), result(decision), limiter.Reason(decision.Err)).Inc()
+	m.DecisionDuration.WithLabelValues(name, checkType).Observe(elapsed.Seconds())
+}
+
+// ObserveAdaptive exposes the current limit of the adaptive controller, by controller name.
+// A controller whose name was already observed is not exposed again
+func (m *Metrics) ObserveAdaptive(controller *limiter.AdaptiveController) {
+	name := controller.Config.Name
+	if name == "" {
+		name = "default"
+	}
+
+	err := m.Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "limiter_adaptive_limit",
+		Help:        "Current limit of the adaptive controllers, by controller.",
+		ConstLabels: prometheus.Labels{"controller": name},
+	}, func() float64 { return float64(controller.Limit()) }))
+
+	var registered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &registered) {
+		panic(err)
+	}
+}
+
+// CheckTypeName returns the name of a limiter check type, as "ip_or_api_key"
+func CheckTypeName(checkType int) string {
+	if name, found := checkTypeNames[checkType]; found {
+		return name
+	}
+	return "unknown"
+}
+
+func limiterName(conf limiter.LimiterConfig) string {
+	if conf.Name == "" {
+		return "default"
+	}
+	return conf.Name
+}
+
+// identityKind returns how the client identified itself, never the identity itself
+func identityKind(req limiter.Request) string {
+	switch {
+	case req.APIKeyID != "":
+		return "api_key"
+	case req.ClientID != "":
+		return "ip"
+	default:
+		return "none"
+	}
+}
+
+func result(decision limiter.Decision) string {
+	switch {
+	case decision.Shadowed:
+		return RESULT_SHADOWED
+	case decision.Allowed:
+		return RESULT_ALLOWED
+	case limiter.IsLimitError(decision.Err):
+		return RESULT_REJECTED
+	default:
+		return RESULT_ERRORED
+	}
+}
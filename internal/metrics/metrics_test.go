@@ -0,0 +1,123 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/metrics"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	Metrics *metrics.Metrics
+	Limiter *limiter.Limiter
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+func (suite *MetricsTestSuite) SetupTest() {
+	suite.Metrics = metrics.NewMetrics()
+	repository := metrics.NewInstrumentedRepository(database.NewMemoryLimiterRepository(limiter.SystemClock{}), suite.Metrics)
+	repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 1})
+
+	suite.Limiter = limiter.NewLimiter(limiter.LimiterConfig{
+		Name:                  "ip-apikey",
+		ClientCheckType:       limiter.CHECK_IP_OR_API_KEY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         2,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, repository).WithObserver(suite.Metrics)
+}
+
+func (suite *MetricsTestSuite) TestMetrics_ObserveDecision() {
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "goexpert-key")
+	suite.Limiter.AllowRequest("", "")
+
+	expected := `
+# HELP limiter_decisions_total Requests checked by the limiters, by limiter, check type, identity kind, result and reason.
+# TYPE limiter_decisions_total counter
+limiter_decisions_total{check_type="ip_or_api_key",identity="api_key",limiter="ip-apikey",reason="",result="allowed"} 1
+limiter_decisions_total{check_type="ip_or_api_key",identity="ip",limiter="ip-apikey",reason="",result="allowed"} 2
+limiter_decisions_total{check_type="ip_or_api_key",identity="ip",limiter="ip-apikey",reason="max_requests",result="rejected"} 1
+limiter_decisions_total{check_type="ip_or_api_key",identity="none",limiter="ip-apikey",reason="invalid_client",result="errored"} 1
+`
+	suite.NoError(testutil.CollectAndCompare(suite.Metrics.Decisions, strings.NewReader(expected)))
+	suite.Equal(1, testutil.CollectAndCount(suite.Metrics.DecisionDuration))
+}
+
+func (suite *MetricsTestSuite) TestMetrics_ObserveDecision_Shadowed() {
+	suite.Limiter.Config.DryRun = true
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+
+	expected := `
+# HELP limiter_decisions_total Requests checked by the limiters, by limiter, check type, identity kind, result and reason.
+# TYPE limiter_decisions_total counter
+limiter_decisions_total{check_type="ip_or_api_key",identity="ip",limiter="ip-apikey",reason="",result="allowed"} 2
+limiter_decisions_total{check_type="ip_or_api_key",identity="ip",limiter="ip-apikey",reason="max_requests",result="shadowed"} 1
+`
+	suite.NoError(testutil.CollectAndCompare(suite.Metrics.Decisions, strings.NewReader(expected)))
+}
+
+func (suite *MetricsTestSuite) TestMetrics_ObserveAdaptive() {
+	controller := limiter.NewAdaptiveController(limiter.AdaptiveConfig{Name: "/adaptive", MinLimit: 1, MaxLimit: 10, TargetLatency: time.Millisecond * 200, Window: time.Hour})
+	suite.Metrics.ObserveAdaptive(controller)
+	suite.NotPanics(func() {
+		suite.Metrics.ObserveAdaptive(limiter.NewAdaptiveController(limiter.AdaptiveConfig{Name: "/adaptive", MaxLimit: 5}))
+	})
+
+	controller.Observe(time.Second, false)
+	controller.Evaluate()
+
+	expected := `
+# HELP limiter_adaptive_limit Current limit of the adaptive controllers, by controller.
+# TYPE limiter_adaptive_limit gauge
+limiter_adaptive_limit{controller="/adaptive"} 5
+`
+	suite.NoError(testutil.GatherAndCompare(suite.Metrics.Registry, strings.NewReader(expected), "limiter_adaptive_limit"))
+}
+
+func (suite *MetricsTestSuite) TestMetrics_NoClientIdentityOnLabels() {
+	suite.Limiter.AllowRequest("192.168.0.1", "goexpert-key")
+	suite.Limiter.AllowRequest("192.168.0.1", "goexpert-key")
+
+	families, err := suite.Metrics.Registry.Gather()
+	suite.NoError(err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				suite.NotContains(label.GetValue(), "192.168.0.1", family.GetName())
+				suite.NotContains(label.GetValue(), "goexpert-key", family.GetName())
+			}
+		}
+	}
+}
+
+func (suite *MetricsTestSuite) TestInstrumentedRepository() {
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.2", "")
+
+	expected := `
+# HELP limiter_blocked_clients Clients currently blocked by this instance.
+# TYPE limiter_blocked_clients gauge
+limiter_blocked_clients 1
+`
+	suite.NoError(testutil.GatherAndCompare(suite.Metrics.Registry, strings.NewReader(expected), "limiter_blocked_clients"))
+
+	count, err := testutil.GatherAndCount(suite.Metrics.Registry, "limiter_repository_duration_seconds")
+	suite.NoError(err)
+	suite.Equal(3, count) // save_api_key, client and save_client
+}
@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// Repository is a limiter and concurrency limiter repository, as the RedisLimiterRepository
+type Repository interface {
+	limiter.LimiterRepositoryInterface
+	limiter.ConcurrencyRepositoryInterface
+}
+
+// InstrumentedRepository times every call to the repository it wraps,
+// keeping track of the clients it blocks
+type InstrumentedRepository struct {
+	Repository Repository
+	Metrics    *Metrics
+}
+
+func NewInstrumentedRepository(repository Repository, metrics *Metrics) *InstrumentedRepository {
+	return &InstrumentedRepository{
+		Repository: repository,
+		Metrics:    metrics,
+	}
+}
+
+// WithContext returns a copy of the repository whose wrapped repository runs its calls within ctx,
+// if it supports it
+func (r *InstrumentedRepository) WithContext(ctx context.Context) limiter.LimiterRepositoryInterface {
+	contextRepository, ok := r.Repository.(limiter.ContextRepository)
+	if !ok {
+		return r
+	}
+
+	repository, ok := contextRepository.WithContext(ctx).(Repository)
+	if !ok {
+		return r
+	}
+	return &InstrumentedRepository{Repository: repository, Metrics: r.Metrics}
+}
+
+// observe records the duration of the operation started at start
+func (r *InstrumentedRepository) observe(operation string, start time.Time) {
+	r.Metrics.RepositoryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (r *InstrumentedRepository) ApiKey(id string) *limiter.APIKey {
+	defer r.observe("api_key", time.Now())
+	return r.Repository.ApiKey(id)
+}
+
+func (r *InstrumentedRepository) Client(id string) *limiter.Client {
+	defer r.observe("client", time.Now())
+	return r.Repository.Client(id)
+}
+
+func (r *InstrumentedRepository) Quota(id string, period int, start time.Time) *limiter.Quota {
+	defer r.observe("quota", time.Now())
+	return r.Repository.Quota(id, period, start)
+}
+
+func (r *InstrumentedRepository) AccessEntries() []limiter.AccessEntry {
+	defer r.observe("access_entries", time.Now())
+	return r.Repository.AccessEntries()
+}
+
+func (r *InstrumentedRepository) Offense(id string) *limiter.Offense {
+	defer r.observe("offense", time.Now())
+	return r.Repository.Offense(id)
+}
+
+func (r *InstrumentedRepository) ConsumeRules(counters []limiter.RuleCounter, cost int) (int, time.Duration) {
+	defer r.observe("consume_rules", time.Now())
+	return r.Repository.ConsumeRules(counters, cost)
+}
+
+func (r *InstrumentedRepository) AcquireSlots(lease limiter.Lease, ttl time.Duration) int {
+	defer r.observe("acquire_slots", time.Now())
+	return r.Repository.AcquireSlots(lease, ttl)
+}
+
+func (r *InstrumentedRepository) RenewSlots(lease limiter.Lease, ttl time.Duration) {
+	defer r.observe("renew_slots", time.Now())
+	r.Repository.RenewSlots(lease, ttl)
+}
+
+func (r *InstrumentedRepository) ReleaseSlots(lease limiter.Lease) {
+	defer r.observe("release_slots", time.Now())
+	r.Repository.ReleaseSlots(lease)
+}
+
+func (r *InstrumentedRepository) SaveApiKey(apiKey limiter.APIKey) {
+	defer r.observe("save_api_key", time.Now())
+	r.Repository.SaveApiKey(apiKey)
+}
+
+func (r *InstrumentedRepository) SaveClient(client limiter.Client) {
+	defer r.observe("save_client", time.Now())
+	r.Repository.SaveClient(client)
+
+	if client.Blocked {
+		now := time.Now()
+		r.Metrics.blocked.Block(client.ID, now.Add(client.TTL), now)
+	}
+}
+
+func (r *InstrumentedRepository) ConsumeQuotas(counters []limiter.QuotaCounter, cost int) (bool, []int) {
+	defer r.observe("consume_quotas", time.Now())
+	return r.Repository.ConsumeQuotas(counters, cost)
+}
+
+func (r *InstrumentedRepository) SaveOffense(offense limiter.Offense) {
+	defer r.observe("save_offense", time.Now())
+	r.Repository.SaveOffense(offense)
+}
+
+func (r *InstrumentedRepository) SaveAccessEntry(entry limiter.AccessEntry) {
+	defer r.observe("save_access_entry", time.Now())
+	r.Repository.SaveAccessEntry(entry)
+}
+
+func (r *InstrumentedRepository) DeleteAccessEntry(entry limiter.AccessEntry) {
+	defer r.observe("delete_access_entry", time.Now())
+	r.Repository.DeleteAccessEntry(entry)
+}
+
+func (r *InstrumentedRepository) DeleteApiKey(id string) {
+	defer r.observe("delete_api_key", time.Now())
+	r.Repository.DeleteApiKey(id)
+}
+
+func (r *InstrumentedRepository) DeleteClient(id string, rules []limiter.LimitRule) {
+	defer r.observe("delete_client", time.Now())
+	r.Repository.DeleteClient(id, rules)
+	r.Metrics.blocked.Unblock(id)
+}
+
+func (r *InstrumentedRepository) AddUsage(usages ...limiter.Usage) {
+	defer r.observe("add_usage", time.Now())
+	r.Repository.AddUsage(usages...)
+}
+
+func (r *InstrumentedRepository) UsageHistory(query limiter.UsageQuery) []limiter.Usage {
+	defer r.observe("usage_history", time.Now())
+	return r.Repository.UsageHistory(query)
+}
+
+func (r *InstrumentedRepository) TopUsage(query limiter.UsageQuery, n int) []limiter.Usage {
+	defer r.observe("top_usage", time.Now())
+	return r.Repository.TopUsage(query, n)
+}
+
+// MIN_BLOCKED_PRUNE is the least amount of blocked clients kept before the unblocked ones are forgotten on a block
+const MIN_BLOCKED_PRUNE = 1024
+
+// blockedClients keeps when each blocked client is unblocked, until it is.
+// The unblocked clients are forgotten on Count, and on Block once the clients kept double since they last were,
+// so that they do not pile up when the metrics are not scraped
+type blockedClients struct {
+	mu      sync.Mutex
+	clients map[string]time.Time
+	pruneAt int
+}
+
+func newBlockedClients() *blockedClients {
+	return &blockedClients{clients: map[string]time.Time{}, pruneAt: MIN_BLOCKED_PRUNE}
+}
+
+func (b *blockedClients) Block(clientID string, until, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[clientID] = until
+
+	if len(b.clients) >= b.pruneAt {
+		b.prune(now)
+		b.pruneAt = max(len(b.clients)*2, MIN_BLOCKED_PRUNE)
+	}
+}
+
+func (b *blockedClients) Unblock(clientID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, clientID)
+}
+
+// Count returns how many clients are still blocked at now, forgetting the others
+func (b *blockedClients) Count(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(now)
+	return len(b.clients)
+}
+
+// prune forgets the clients unblocked at now
+func (b *blockedClients) prune(now time.Time) {
+	for id, until := range b.clients {
+		if !until.After(now) {
+			delete(b.clients, id)
+		}
+	}
+}
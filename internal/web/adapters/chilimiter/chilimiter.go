@@ -0,0 +1,66 @@
+package chilimiter
+
+import (
+	"net/http"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// ErrorHandler answers the refused request
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// Limiter limits the requests of a chi router, deciding them like middleware.LimiterMiddleware.
+// The request keeps its chi route context, so the URL params can be read by the cost and priority functions
+type Limiter struct {
+	Middleware *middleware.LimiterMiddleware
+
+	// ErrorHandler answers the refused requests, with the error as plain text if nil
+	ErrorHandler ErrorHandler
+}
+
+func New(m *middleware.LimiterMiddleware) *Limiter {
+	return &Limiter{
+		Middleware: m,
+	}
+}
+
+// WithErrorHandler sets how the refused requests are answered, as rendering them in the API error format
+func (l *Limiter) WithErrorHandler(handler ErrorHandler) *Limiter {
+	l.ErrorHandler = handler
+	return l
+}
+
+// Handler returns the chi middleware, to be set with Router.Use or Router.With
+func (l *Limiter) Handler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, result := l.Middleware.Check(r)
+			result.SetHeader(w.Header())
+			if !result.Allowed {
+				l.errorHandler()(w, r, result.Status, result.Err)
+				return
+			}
+
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			l.Middleware.Handle(func() int {
+				next.ServeHTTP(ww, r)
+				if ww.Status() == 0 {
+					return http.StatusOK
+				}
+				return ww.Status()
+			})
+		})
+	}
+}
+
+func (l *Limiter) errorHandler() ErrorHandler {
+	if l.ErrorHandler == nil {
+		return defaultErrorHandler
+	}
+	return l.ErrorHandler
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
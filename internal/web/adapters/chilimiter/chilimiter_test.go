@@ -0,0 +1,100 @@
+package chilimiter_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/adapters/chilimiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 4
+
+type ChiLimiterTestSuite struct {
+	suite.Suite
+	Limiter   *chilimiter.Limiter
+	Handled   int
+	RequestID string
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(ChiLimiterTestSuite))
+}
+
+func (suite *ChiLimiterTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = chilimiter.New(middleware.NewLimiterMiddleware(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)))
+	suite.Handled, suite.RequestID = 0, ""
+}
+
+// router returns a router limiting /api/batches/{size}, each batch costing its size
+func (suite *ChiLimiterTestSuite) router() chi.Router {
+	suite.Limiter.Middleware.WithCost(func(r *http.Request) (int, error) {
+		return strconv.Atoi(chi.URLParam(r, "size"))
+	})
+
+	router := chi.NewRouter()
+	router.Route("/api/batches/{size}", func(r chi.Router) {
+		r.Use(suite.Limiter.Handler())
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			suite.Handled++
+			suite.RequestID = logging.RequestID(r.Context())
+			w.Write([]byte("ok"))
+		})
+	})
+	return router
+}
+
+func (suite *ChiLimiterTestSuite) request(router chi.Router, size int) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api/batches/"+strconv.Itoa(size)+"/", nil)
+	req.RemoteAddr = "192.168.0.1:5000"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func (suite *ChiLimiterTestSuite) TestHandler() {
+	router := suite.router()
+
+	suite.Run("Should charge the cost taken from the route params, passing the request ID on the request context", func() {
+		res := suite.request(router, 3)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("1", res.Header.Get("X-RateLimit-Remaining"))
+		suite.NotEmpty(res.Header.Get(middleware.REQUEST_ID_HEADER))
+		suite.Equal(res.Header.Get(middleware.REQUEST_ID_HEADER), suite.RequestID)
+	})
+
+	suite.Run("Should refuse the requests over the limit without passing them on", func() {
+		suite.Equal(http.StatusOK, suite.request(router, 1).StatusCode)
+		suite.Equal(http.StatusTooManyRequests, suite.request(router, 1).StatusCode)
+		suite.Equal(2, suite.Handled)
+	})
+}
+
+func (suite *ChiLimiterTestSuite) TestHandler_ErrorHandler() {
+	suite.Limiter.WithErrorHandler(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		w.WriteHeader(status)
+		w.Write([]byte(chi.URLParam(r, "size") + ": " + limiter.Reason(err)))
+	})
+	router := suite.router()
+
+	res := suite.request(router, MaxRequests+1)
+	body, _ := io.ReadAll(res.Body)
+	suite.Equal(http.StatusBadRequest, res.StatusCode)
+	suite.Equal("5: "+limiter.Reason(limiter.ErrCostExceedsLimit), string(body))
+	suite.Zero(suite.Handled)
+}
@@ -0,0 +1,94 @@
+package echolimiter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// ErrorHandler returns the error the refused request is answered with by the echo HTTPErrorHandler
+type ErrorHandler func(c echo.Context, status int, err error) error
+
+// Limiter limits the requests of an echo server, deciding them like middleware.LimiterMiddleware
+type Limiter struct {
+	Middleware *middleware.LimiterMiddleware
+
+	// Skipper skips the limit on the requests it returns true for, as the health checks
+	Skipper echomiddleware.Skipper
+
+	// ErrorHandler returns the error of the refused requests, an *echo.HTTPError of the status wrapping the limiter error if nil
+	ErrorHandler ErrorHandler
+}
+
+func New(m *middleware.LimiterMiddleware) *Limiter {
+	return &Limiter{
+		Middleware: m,
+		Skipper:    echomiddleware.DefaultSkipper,
+	}
+}
+
+// WithSkipper sets the requests the limit is skipped on
+func (l *Limiter) WithSkipper(skipper echomiddleware.Skipper) *Limiter {
+	l.Skipper = skipper
+	return l
+}
+
+// WithErrorHandler sets the error the refused requests are answered with
+func (l *Limiter) WithErrorHandler(handler ErrorHandler) *Limiter {
+	l.ErrorHandler = handler
+	return l
+}
+
+// Handler returns the echo middleware. The refused requests are not passed on,
+// their error being returned for the echo HTTPErrorHandler to answer them
+func (l *Limiter) Handler() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if l.Skipper != nil && l.Skipper(c) {
+				return next(c)
+			}
+
+			r, result := l.Middleware.Check(c.Request())
+			c.SetRequest(r)
+			result.SetHeader(c.Response().Header())
+			if !result.Allowed {
+				return l.errorHandler()(c, result.Status, result.Err)
+			}
+
+			var err error
+			l.Middleware.Handle(func() int {
+				err = next(c)
+				return status(c, err)
+			})
+			return err
+		}
+	}
+}
+
+func (l *Limiter) errorHandler() ErrorHandler {
+	if l.ErrorHandler == nil {
+		return defaultErrorHandler
+	}
+	return l.ErrorHandler
+}
+
+func defaultErrorHandler(c echo.Context, status int, err error) error {
+	return echo.NewHTTPError(status, err.Error()).SetInternal(err)
+}
+
+// status returns the status the request is answered with, the one of the error if the handler failed
+// as the echo HTTPErrorHandler only answers once the middlewares return
+func status(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
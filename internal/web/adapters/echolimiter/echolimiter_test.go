@@ -0,0 +1,120 @@
+package echolimiter_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/adapters/echolimiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 2
+
+type EchoLimiterTestSuite struct {
+	suite.Suite
+	Limiter   *echolimiter.Limiter
+	Handled   int
+	RequestID string
+	Err       error
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(EchoLimiterTestSuite))
+}
+
+func (suite *EchoLimiterTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = echolimiter.New(middleware.NewLimiterMiddleware(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)))
+	suite.Handled, suite.RequestID, suite.Err = 0, "", nil
+}
+
+// server returns a server limiting its routes, recording the errors its HTTPErrorHandler answers
+func (suite *EchoLimiterTestSuite) server() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		suite.Err = err
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+	e.Use(suite.Limiter.Handler())
+	e.GET("/api", func(c echo.Context) error {
+		suite.Handled++
+		suite.RequestID = logging.RequestID(c.Request().Context())
+		return c.String(http.StatusOK, "ok")
+	})
+	e.GET("/health", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	return e
+}
+
+func (suite *EchoLimiterTestSuite) request(e *echo.Echo, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "192.168.0.1:5000"
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *EchoLimiterTestSuite) TestHandler() {
+	e := suite.server()
+
+	suite.Run("Should allow the requests within the limit, passing the request ID on the request context", func() {
+		rec := suite.request(e, "/api")
+		suite.Equal(http.StatusOK, rec.Code)
+		suite.Equal("1", rec.Header().Get("X-RateLimit-Remaining"))
+		suite.NotEmpty(rec.Header().Get(middleware.REQUEST_ID_HEADER))
+		suite.Equal(rec.Header().Get(middleware.REQUEST_ID_HEADER), suite.RequestID)
+		suite.Equal(http.StatusOK, suite.request(e, "/api").Code)
+	})
+
+	suite.Run("Should return the refusal to the HTTPErrorHandler as an HTTPError wrapping the limiter error", func() {
+		rec := suite.request(e, "/api")
+		suite.Equal(http.StatusTooManyRequests, rec.Code)
+		suite.Equal("0", rec.Header().Get("X-RateLimit-Remaining"))
+		suite.JSONEq(`{"message":"`+limiter.ErrMaxNumberRequestsReached.Error()+`"}`, rec.Body.String())
+		suite.True(errors.Is(suite.Err, limiter.ErrMaxNumberRequestsReached))
+		suite.Equal(MaxRequests, suite.Handled)
+	})
+}
+
+func (suite *EchoLimiterTestSuite) TestHandler_Skipper() {
+	suite.Limiter.WithSkipper(func(c echo.Context) bool {
+		return c.Path() == "/health"
+	})
+	e := suite.server()
+
+	for i := 0; i < MaxRequests*2; i++ {
+		rec := suite.request(e, "/health")
+		suite.Equal(http.StatusNoContent, rec.Code)
+		suite.Empty(rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	suite.Equal(http.StatusOK, suite.request(e, "/api").Code)
+}
+
+func (suite *EchoLimiterTestSuite) TestHandler_ErrorHandler() {
+	suite.Limiter.WithErrorHandler(func(c echo.Context, status int, err error) error {
+		return c.JSON(status, map[string]string{"error": limiter.Reason(err)})
+	})
+	e := suite.server()
+	for i := 0; i < MaxRequests; i++ {
+		suite.request(e, "/api")
+	}
+
+	rec := suite.request(e, "/api")
+	suite.Equal(http.StatusTooManyRequests, rec.Code)
+	suite.JSONEq(`{"error":"max_requests"}`, rec.Body.String())
+	suite.Nil(suite.Err)
+}
@@ -0,0 +1,117 @@
+package fiberlimiter
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// ErrorHandler returns the error the refused request is answered with by the fiber ErrorHandler
+type ErrorHandler func(c *fiber.Ctx, status int, err error) error
+
+// Limiter limits the requests of a fiber app, deciding them like middleware.LimiterMiddleware.
+// The requests are converted to *http.Request for the decision, so the cost and priority functions are shared with net/http
+type Limiter struct {
+	Middleware *middleware.LimiterMiddleware
+
+	// Next skips the limit on the requests it returns true for, as the health checks
+	Next func(c *fiber.Ctx) bool
+
+	// ErrorHandler returns the error of the refused requests, a *fiber.Error of the status wrapping the limiter error if nil
+	ErrorHandler ErrorHandler
+}
+
+func New(m *middleware.LimiterMiddleware) *Limiter {
+	return &Limiter{
+		Middleware: m,
+	}
+}
+
+// WithNext sets the requests the limit is skipped on
+func (l *Limiter) WithNext(next func(c *fiber.Ctx) bool) *Limiter {
+	l.Next = next
+	return l
+}
+
+// WithErrorHandler sets the error the refused requests are answered with
+func (l *Limiter) WithErrorHandler(handler ErrorHandler) *Limiter {
+	l.ErrorHandler = handler
+	return l
+}
+
+// Handler returns the fiber middleware. The refused requests are not passed on,
+// their error being returned for the fiber ErrorHandler to answer them.
+// The request ID and trace of the request are set on its user context
+func (l *Limiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if l.Next != nil && l.Next(c) {
+			return c.Next()
+		}
+
+		r, err := adaptor.ConvertRequest(c, true)
+		if err != nil {
+			return err
+		}
+		r, result := l.Middleware.Check(r.WithContext(c.UserContext()))
+		c.SetUserContext(r.Context())
+		for name, values := range result.Header {
+			if name != middleware.SHADOW_HEADER {
+				c.Response().Header.Del(name)
+			}
+			for _, value := range values {
+				c.Response().Header.Add(name, value)
+			}
+		}
+		if !result.Allowed {
+			return l.errorHandler()(c, result.Status, result.Err)
+		}
+
+		l.Middleware.Handle(func() int {
+			err = c.Next()
+			return status(c, err)
+		})
+		return err
+	}
+}
+
+func (l *Limiter) errorHandler() ErrorHandler {
+	if l.ErrorHandler == nil {
+		return defaultErrorHandler
+	}
+	return l.ErrorHandler
+}
+
+func defaultErrorHandler(c *fiber.Ctx, status int, err error) error {
+	return &Error{Err: fiber.NewError(status, err.Error()), Cause: err}
+}
+
+// Error is the error of a refused request, a *fiber.Error for the fiber ErrorHandler
+// that also unwraps to the limiter error
+type Error struct {
+	Err   *fiber.Error
+	Cause error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Err, e.Cause}
+}
+
+// status returns the status the request is answered with, the one of the error if the handler failed
+// as the fiber ErrorHandler only answers once the middlewares return
+func status(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
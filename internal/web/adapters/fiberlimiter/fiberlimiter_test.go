@@ -0,0 +1,124 @@
+package fiberlimiter_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/adapters/fiberlimiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 2
+
+type FiberLimiterTestSuite struct {
+	suite.Suite
+	Limiter   *fiberlimiter.Limiter
+	Handled   int
+	RequestID string
+	Err       error
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(FiberLimiterTestSuite))
+}
+
+func (suite *FiberLimiterTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = fiberlimiter.New(middleware.NewLimiterMiddleware(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)))
+	suite.Handled, suite.RequestID, suite.Err = 0, "", nil
+}
+
+// app returns an app limiting its routes, recording the errors its ErrorHandler answers
+func (suite *FiberLimiterTestSuite) app() *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			suite.Err = err
+			return fiber.DefaultErrorHandler(c, err)
+		},
+	})
+	app.Use(suite.Limiter.Handler())
+	app.Get("/api", func(c *fiber.Ctx) error {
+		suite.Handled++
+		suite.RequestID = logging.RequestID(c.UserContext())
+		return c.SendString("ok")
+	})
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app
+}
+
+func (suite *FiberLimiterTestSuite) request(app *fiber.App, path string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Real-Ip", "192.168.0.1")
+	res, err := app.Test(req)
+	suite.NoError(err)
+	return res
+}
+
+func (suite *FiberLimiterTestSuite) TestHandler() {
+	app := suite.app()
+
+	suite.Run("Should allow the requests within the limit, passing the request ID on the user context", func() {
+		res := suite.request(app, "/api")
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("1", res.Header.Get("X-RateLimit-Remaining"))
+		suite.NotEmpty(res.Header.Get(middleware.REQUEST_ID_HEADER))
+		suite.Equal(res.Header.Get(middleware.REQUEST_ID_HEADER), suite.RequestID)
+		suite.Equal(http.StatusOK, suite.request(app, "/api").StatusCode)
+	})
+
+	suite.Run("Should return the refusal to the ErrorHandler as a fiber error wrapping the limiter error", func() {
+		res := suite.request(app, "/api")
+		body, _ := io.ReadAll(res.Body)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Equal("0", res.Header.Get("X-RateLimit-Remaining"))
+		suite.Equal(limiter.ErrMaxNumberRequestsReached.Error(), string(body))
+		suite.True(errors.Is(suite.Err, limiter.ErrMaxNumberRequestsReached))
+		suite.Equal(MaxRequests, suite.Handled)
+	})
+}
+
+func (suite *FiberLimiterTestSuite) TestHandler_Next() {
+	suite.Limiter.WithNext(func(c *fiber.Ctx) bool {
+		return c.Path() == "/health"
+	})
+	app := suite.app()
+
+	for i := 0; i < MaxRequests*2; i++ {
+		res := suite.request(app, "/health")
+		suite.Equal(http.StatusNoContent, res.StatusCode)
+		suite.Empty(res.Header.Get("X-RateLimit-Remaining"))
+	}
+	suite.Equal(http.StatusOK, suite.request(app, "/api").StatusCode)
+}
+
+func (suite *FiberLimiterTestSuite) TestHandler_ErrorHandler() {
+	suite.Limiter.WithErrorHandler(func(c *fiber.Ctx, status int, err error) error {
+		return c.Status(status).JSON(fiber.Map{"error": limiter.Reason(err)})
+	})
+	app := suite.app()
+	for i := 0; i < MaxRequests; i++ {
+		suite.request(app, "/api")
+	}
+
+	res := suite.request(app, "/api")
+	body, _ := io.ReadAll(res.Body)
+	suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+	suite.JSONEq(`{"error":"max_requests"}`, string(body))
+	suite.Nil(suite.Err)
+}
@@ -0,0 +1,60 @@
+package ginlimiter
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// ErrorHandler answers the refused request, which is already aborted and carries the error on c.Errors
+type ErrorHandler func(c *gin.Context, status int, err error)
+
+// Limiter limits the requests of a gin router, deciding them like middleware.LimiterMiddleware
+type Limiter struct {
+	Middleware *middleware.LimiterMiddleware
+
+	// ErrorHandler answers the refused requests, with the error as plain text if nil
+	ErrorHandler ErrorHandler
+}
+
+func New(m *middleware.LimiterMiddleware) *Limiter {
+	return &Limiter{
+		Middleware: m,
+	}
+}
+
+// WithErrorHandler sets how the refused requests are answered, as rendering them in the API error format
+func (l *Limiter) WithErrorHandler(handler ErrorHandler) *Limiter {
+	l.ErrorHandler = handler
+	return l
+}
+
+// Handler returns the gin middleware, aborting the refused requests so that the next handlers are not run
+func (l *Limiter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, result := l.Middleware.Check(c.Request)
+		c.Request = r
+		result.SetHeader(c.Writer.Header())
+		if !result.Allowed {
+			c.Abort()
+			_ = c.Error(result.Err)
+			l.errorHandler()(c, result.Status, result.Err)
+			return
+		}
+
+		l.Middleware.Handle(func() int {
+			c.Next()
+			return c.Writer.Status()
+		})
+	}
+}
+
+func (l *Limiter) errorHandler() ErrorHandler {
+	if l.ErrorHandler == nil {
+		return defaultErrorHandler
+	}
+	return l.ErrorHandler
+}
+
+func defaultErrorHandler(c *gin.Context, status int, err error) {
+	c.String(status, err.Error())
+}
@@ -0,0 +1,106 @@
+package ginlimiter_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/adapters/ginlimiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 2
+
+type GinLimiterTestSuite struct {
+	suite.Suite
+	Limiter   *ginlimiter.Limiter
+	Handled   int
+	RequestID string
+	Errors    []*gin.Error
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(GinLimiterTestSuite))
+}
+
+func (suite *GinLimiterTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = ginlimiter.New(middleware.NewLimiterMiddleware(limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)))
+	suite.Handled, suite.RequestID, suite.Errors = 0, "", nil
+}
+
+// router returns a router limiting /api, recording the errors of the requests and what the handler sees
+func (suite *GinLimiterTestSuite) router() *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		suite.Errors = c.Errors
+	})
+	router.Use(suite.Limiter.Handler())
+	router.GET("/api", func(c *gin.Context) {
+		suite.Handled++
+		suite.RequestID = logging.RequestID(c.Request.Context())
+		c.String(http.StatusOK, "ok")
+	})
+	return router
+}
+
+func (suite *GinLimiterTestSuite) request(router *gin.Engine) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.RemoteAddr = "192.168.0.1:5000"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func (suite *GinLimiterTestSuite) TestHandler() {
+	router := suite.router()
+
+	suite.Run("Should allow the requests within the limit, passing the request ID on the request context", func() {
+		res := suite.request(router)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("1", res.Header.Get("X-RateLimit-Remaining"))
+		suite.NotEmpty(res.Header.Get(middleware.REQUEST_ID_HEADER))
+		suite.Equal(res.Header.Get(middleware.REQUEST_ID_HEADER), suite.RequestID)
+		suite.Equal(http.StatusOK, suite.request(router).StatusCode)
+		suite.Equal(MaxRequests, suite.Handled)
+	})
+
+	suite.Run("Should abort the refused requests, adding the limiter error to the context errors", func() {
+		res := suite.request(router)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Equal("0", res.Header.Get("X-RateLimit-Remaining"))
+		suite.Equal(MaxRequests, suite.Handled)
+		suite.Len(suite.Errors, 1)
+		suite.True(errors.Is(suite.Errors[0], limiter.ErrMaxNumberRequestsReached))
+	})
+}
+
+func (suite *GinLimiterTestSuite) TestHandler_ErrorHandler() {
+	suite.Limiter.WithErrorHandler(func(c *gin.Context, status int, err error) {
+		c.JSON(status, gin.H{"error": limiter.Reason(err)})
+	})
+	router := suite.router()
+	for i := 0; i < MaxRequests; i++ {
+		suite.request(router)
+	}
+
+	res := suite.request(router)
+	body, _ := io.ReadAll(res.Body)
+	suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+	suite.JSONEq(`{"error":"max_requests"}`, string(body))
+}
@@ -0,0 +1,155 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+var ErrInvalidAccess = errors.New("access must be allow or deny")
+
+// AccessEntryDTO is an allowlist or denylist entry as sent and returned by the admin API
+type AccessEntryDTO struct {
+	Value  string `json:"value"`
+	Access string `json:"access"`
+}
+
+// AccessHandler manages the allowlist and denylist at runtime, recording every change on the audit trail
+type AccessHandler struct {
+	AccessList *limiter.AccessList
+	Auditor    *audit.Auditor
+}
+
+func NewAccessHandler(accessList *limiter.AccessList, auditor *audit.Auditor) *AccessHandler {
+	return &AccessHandler{
+		AccessList: accessList,
+		Auditor:    auditor,
+	}
+}
+
+func (h *AccessHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /access", h.List)
+	mux.HandleFunc("POST /access", h.Save)
+	mux.HandleFunc("DELETE /access", h.Delete)
+}
+
+func (h *AccessHandler) List(w http.ResponseWriter, r *http.Request) {
+	entries := h.AccessList.Entries()
+	dtos := make([]AccessEntryDTO, 0, len(entries))
+	for _, e := range entries {
+		dtos = append(dtos, AccessEntryDTO{
+			Value:  e.Value,
+			Access: accessName(e.Access),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, dtos)
+}
+
+func (h *AccessHandler) Save(w http.ResponseWriter, r *http.Request) {
+	actor, err := actor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var dto AccessEntryDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	dto.Value = strings.TrimSpace(dto.Value)
+
+	access, err := parseAccess(dto.Access)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := limiter.AccessEntry{Value: dto.Value, Access: access}
+	var before any
+	if h.exists(entry) {
+		before = dto
+	}
+
+	err = h.AccessList.Save(entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditEntry := audit.Entry{Action: audit.ACTION_ACCESS_SAVED, Actor: actor, Target: dto.Value}
+	if !recordChange(w, r, h.Auditor, auditEntry, before, dto) {
+		return
+	}
+	writeJSON(w, http.StatusCreated, dto)
+}
+
+// Delete removes the entry sent on the `value` and `access` query params,
+// as a CIDR does not fit on a path segment. Only the removal of an existing entry is audited
+func (h *AccessHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	actor, err := actor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	access, err := parseAccess(r.URL.Query().Get("access"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := limiter.AccessEntry{Value: strings.TrimSpace(r.URL.Query().Get("value")), Access: access}
+	if !h.exists(entry) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.AccessList.Delete(entry)
+	auditEntry := audit.Entry{Action: audit.ACTION_ACCESS_DELETED, Actor: actor, Target: entry.Value}
+	before := AccessEntryDTO{Value: entry.Value, Access: accessName(entry.Access)}
+	if !recordChange(w, r, h.Auditor, auditEntry, before, nil) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exists reports whether the entry is on the access list
+func (h *AccessHandler) exists(entry limiter.AccessEntry) bool {
+	for _, e := range h.AccessList.Entries() {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func accessName(access int) string {
+	if access == limiter.ACCESS_DENY {
+		return "deny"
+	}
+	return "allow"
+}
+
+func parseAccess(access string) (int, error) {
+	switch access {
+	case "allow":
+		return limiter.ACCESS_ALLOW, nil
+	case "deny":
+		return limiter.ACCESS_DENY, nil
+	default:
+		return 0, ErrInvalidAccess
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,17 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrMissingActor = errors.New("the request has no authenticated principal to be audited as its actor")
+
+// actor returns who makes the request, as the principal of its token authenticated by Authenticator.Require
+func actor(r *http.Request) (string, error) {
+	actor := Principal(r.Context())
+	if actor == "" {
+		return "", ErrMissingActor
+	}
+	return actor, nil
+}
@@ -0,0 +1,406 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/admin"
+)
+
+const Token = "s3cr3t-token"
+const Principal = "admin@example.com"
+
+type AdminTestSuite struct {
+	suite.Suite
+	Clock      *limiter.FakeClock
+	Repository *database.MemoryLimiterRepository
+	Limiter    *limiter.Limiter
+	AccessList *limiter.AccessList
+	Auditor    *audit.Auditor
+	Handler    http.Handler
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
+
+func (suite *AdminTestSuite) SetupTest() {
+	suite.Clock = limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Repository = database.NewMemoryLimiterRepository(suite.Clock)
+	suite.AccessList = limiter.NewAccessList(suite.Repository)
+	suite.Auditor = audit.NewAuditor(audit.NewFileStore(filepath.Join(suite.T().TempDir(), "audit.jsonl"))).WithClock(suite.Clock)
+	suite.Limiter = limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_OR_API_KEY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         1,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		UsageRetention:        limiter.UsageRetention{Minute: time.Hour, Hour: time.Hour * 24},
+	}, suite.Repository).WithClock(suite.Clock)
+	limiters := map[string]*limiter.Limiter{"default": suite.Limiter}
+
+	mux := http.NewServeMux()
+	admin.NewAccessHandler(suite.AccessList, suite.Auditor).Register(mux)
+	admin.NewKeysHandler(suite.Repository, suite.Auditor).Register(mux)
+	admin.NewClientsHandler(limiters, suite.Auditor).Register(mux)
+	admin.NewUsageHandler(limiters).Register(mux)
+	admin.NewAuditHandler(suite.Auditor).Register(mux)
+	suite.Handler = admin.NewAuthenticator(admin.Token{Principal: Principal, Token: Token}).Require(mux)
+}
+
+// request sends an authenticated admin request, returning the response and its body
+func (suite *AdminTestSuite) request(method, target, body string, headers map[string]string) (*http.Response, string) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", admin.BEARER_PREFIX+Token)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	suite.Handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	resBody, _ := io.ReadAll(res.Body)
+	return res, string(resBody)
+}
+
+// auditTrail returns the recorded entries, the latest first
+func (suite *AdminTestSuite) auditTrail() []audit.Entry {
+	entries, err := suite.Auditor.Query(context.Background(), audit.Filter{})
+	suite.NoError(err)
+	return entries
+}
+
+func (suite *AdminTestSuite) TestAuthenticator() {
+	routes := []struct {
+		Method string
+		Target string
+	}{
+		{http.MethodGet, "/access"},
+		{http.MethodPost, "/access"},
+		{http.MethodDelete, "/access?access=deny&value=10.0.0.1"},
+		{http.MethodGet, "/keys/goexpert-key"},
+		{http.MethodPut, "/keys/goexpert-key"},
+		{http.MethodDelete, "/keys/goexpert-key"},
+		{http.MethodPost, "/clients/192.168.0.1/unblock"},
+		{http.MethodGet, "/usage/top"},
+		{http.MethodGet, "/usage/ip/192.168.0.1"},
+		{http.MethodGet, "/audit"},
+	}
+	authorizations := map[string]string{
+		"no token":       "",
+		"an invalid one": admin.BEARER_PREFIX + "wrong-token",
+		"another scheme": "Basic " + Token,
+		"an empty one":   admin.BEARER_PREFIX,
+	}
+
+	suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 5})
+	for _, route := range routes {
+		for name, authorization := range authorizations {
+			suite.Run("Should answer 401 "+route.Method+" "+route.Target+" with "+name, func() {
+				req := httptest.NewRequest(route.Method, route.Target, strings.NewReader(`{"value":"10.0.0.1","access":"deny","max_requests":1}`))
+				if authorization != "" {
+					req.Header.Set("Authorization", authorization)
+				}
+				rec := httptest.NewRecorder()
+				suite.Handler.ServeHTTP(rec, req)
+
+				suite.Equal(http.StatusUnauthorized, rec.Code)
+				suite.Contains(rec.Header().Get("WWW-Authenticate"), "Bearer")
+			})
+		}
+	}
+
+	suite.Empty(suite.AccessList.Entries())
+	suite.Equal(&limiter.APIKey{ID: "goexpert-key", MaxRequests: 5}, suite.Repository.ApiKey("goexpert-key"))
+	suite.Empty(suite.auditTrail())
+}
+
+func (suite *AdminTestSuite) TestParseTokens() {
+	tokens, err := admin.ParseTokens("alice@example.com:s3cr3t, ci:t0k:3n")
+	suite.NoError(err)
+	suite.Equal([]admin.Token{
+		{Principal: "alice@example.com", Token: "s3cr3t"},
+		{Principal: "ci", Token: "t0k:3n"},
+	}, tokens)
+
+	tokens, err = admin.ParseTokens("")
+	suite.NoError(err)
+	suite.Empty(tokens)
+
+	for _, invalid := range []string{"s3cr3t", ":s3cr3t", "alice:"} {
+		_, err := admin.ParseTokens(invalid)
+		suite.Error(err, invalid)
+	}
+}
+
+func (suite *AdminTestSuite) TestAccessHandler() {
+	suite.Run("Should save the entry, recording it", func() {
+		res, body := suite.request(http.MethodPost, "/access", `{"value":" 10.0.0.0/8","access":"deny"}`, nil)
+		suite.Equal(http.StatusCreated, res.StatusCode)
+		suite.JSONEq(`{"value":"10.0.0.0/8","access":"deny"}`, body)
+
+		entry := suite.auditTrail()[0]
+		suite.Equal(audit.ACTION_ACCESS_SAVED, entry.Action)
+		suite.Equal(Principal, entry.Actor)
+		suite.Equal("10.0.0.0/8", entry.Target)
+		suite.Empty(entry.Before)
+		suite.JSONEq(`{"value":"10.0.0.0/8","access":"deny"}`, string(entry.After))
+	})
+
+	suite.Run("Should answer 400 an invalid entry, recording nothing", func() {
+		for _, body := range []string{`{"value":"10.0.0.0/8","access":"block"}`, `{`, `{"value":"","access":"deny"}`} {
+			res, _ := suite.request(http.MethodPost, "/access", body, nil)
+			suite.Equal(http.StatusBadRequest, res.StatusCode, body)
+		}
+		suite.Len(suite.auditTrail(), 1)
+	})
+
+	suite.Run("Should list the entries", func() {
+		res, body := suite.request(http.MethodGet, "/access", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`[{"value":"10.0.0.0/8","access":"deny"}]`, body)
+	})
+
+	suite.Run("Should delete the entry, recording it", func() {
+		res, _ := suite.request(http.MethodDelete, "/access?access=deny&value=10.0.0.0/8", "", nil)
+		suite.Equal(http.StatusNoContent, res.StatusCode)
+		suite.Empty(suite.AccessList.Entries())
+
+		entry := suite.auditTrail()[0]
+		suite.Equal(audit.ACTION_ACCESS_DELETED, entry.Action)
+		suite.Equal(Principal, entry.Actor)
+		suite.Equal("10.0.0.0/8", entry.Target)
+		suite.JSONEq(`{"value":"10.0.0.0/8","access":"deny"}`, string(entry.Before))
+		suite.Empty(entry.After)
+	})
+
+	suite.Run("Should not record deleting an entry that is not on the list", func() {
+		res, _ := suite.request(http.MethodDelete, "/access?access=deny&value=10.0.0.0/8", "", nil)
+		suite.Equal(http.StatusNoContent, res.StatusCode)
+		suite.Len(suite.auditTrail(), 2)
+
+		res, _ = suite.request(http.MethodDelete, "/access?access=block&value=10.0.0.0/8", "", nil)
+		suite.Equal(http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func (suite *AdminTestSuite) TestActor() {
+	suite.Run("Should record the authenticated principal as the actor, not a header sent along", func() {
+		res, _ := suite.request(http.MethodPut, "/keys/partner-key", `{"max_requests":20}`, map[string]string{"X-Admin-Actor": "someone-else"})
+		suite.Equal(http.StatusCreated, res.StatusCode)
+		suite.Equal(Principal, suite.auditTrail()[0].Actor)
+	})
+
+	suite.Run("Should answer 401 the changes of handlers served without the authenticator", func() {
+		mux := http.NewServeMux()
+		admin.NewAccessHandler(suite.AccessList, suite.Auditor).Register(mux)
+		admin.NewKeysHandler(suite.Repository, suite.Auditor).Register(mux)
+		admin.NewClientsHandler(map[string]*limiter.Limiter{"default": suite.Limiter}, suite.Auditor).Register(mux)
+
+		for _, route := range []struct{ Method, Target string }{
+			{http.MethodPost, "/access"},
+			{http.MethodDelete, "/access?access=deny&value=10.0.0.1"},
+			{http.MethodPut, "/keys/partner-key"},
+			{http.MethodDelete, "/keys/partner-key"},
+			{http.MethodPost, "/clients/192.168.0.1/unblock"},
+		} {
+			req := httptest.NewRequest(route.Method, route.Target, strings.NewReader(`{"value":"10.0.0.1","access":"deny","max_requests":1}`))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			suite.Equal(http.StatusUnauthorized, rec.Code, route.Method+" "+route.Target)
+			suite.Contains(rec.Body.String(), admin.ErrMissingActor.Error())
+		}
+		suite.Len(suite.auditTrail(), 1)
+		suite.Empty(suite.AccessList.Entries())
+	})
+}
+
+func (suite *AdminTestSuite) TestKeysHandler() {
+	suite.Run("Should create the API Key, recording it", func() {
+		res, body := suite.request(http.MethodPut, "/keys/partner-key", `{"max_requests":20,"daily_quota":5000,"priority":"high"}`, nil)
+		suite.Equal(http.StatusCreated, res.StatusCode)
+		suite.JSONEq(`{"id":"partner-key","max_requests":20,"daily_quota":5000,"priority":"high"}`, body)
+		suite.Equal(&limiter.APIKey{ID: "partner-key", MaxRequests: 20, DailyQuota: 5000, Priority: limiter.PRIORITY_HIGH}, suite.Repository.ApiKey("partner-key"))
+		suite.Equal(audit.ACTION_API_KEY_CREATED, suite.auditTrail()[0].Action)
+	})
+
+	suite.Run("Should update the API Key, recording it", func() {
+		res, body := suite.request(http.MethodPut, "/keys/partner-key", `{"max_requests":30}`, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`{"id":"partner-key","max_requests":30}`, body)
+
+		entry := suite.auditTrail()[0]
+		suite.Equal(audit.ACTION_API_KEY_UPDATED, entry.Action)
+		suite.JSONEq(`{"id":"partner-key","max_requests":20,"daily_quota":5000,"priority":"high"}`, string(entry.Before))
+	})
+
+	suite.Run("Should get the API Key", func() {
+		res, body := suite.request(http.MethodGet, "/keys/partner-key", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`{"id":"partner-key","max_requests":30}`, body)
+	})
+
+	suite.Run("Should answer 400 an invalid API Key", func() {
+		for _, body := range []string{`{`, `{"max_requests":0}`, `{"max_requests":1,"priority":"urgent"}`} {
+			res, _ := suite.request(http.MethodPut, "/keys/partner-key", body, nil)
+			suite.Equal(http.StatusBadRequest, res.StatusCode, body)
+		}
+	})
+
+	suite.Run("Should revoke the API Key, recording it", func() {
+		res, _ := suite.request(http.MethodDelete, "/keys/partner-key", "", nil)
+		suite.Equal(http.StatusNoContent, res.StatusCode)
+		suite.Nil(suite.Repository.ApiKey("partner-key"))
+		suite.Equal(audit.ACTION_API_KEY_REVOKED, suite.auditTrail()[0].Action)
+	})
+
+	suite.Run("Should answer 404 an unknown API Key", func() {
+		res, _ := suite.request(http.MethodGet, "/keys/partner-key", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+		res, _ = suite.request(http.MethodDelete, "/keys/partner-key", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func (suite *AdminTestSuite) TestClientsHandler() {
+	suite.Run("Should answer 404 a client that is not blocked", func() {
+		res, body := suite.request(http.MethodPost, "/clients/192.168.0.1/unblock", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+		suite.Contains(body, admin.ErrClientNotBlocked.Error())
+	})
+
+	suite.Run("Should answer 404 an unknown limiter", func() {
+		res, body := suite.request(http.MethodPost, "/clients/192.168.0.1/unblock?limiter=unknown", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+		suite.Contains(body, admin.ErrUnknownLimiter.Error())
+	})
+
+	suite.Run("Should unblock the client, recording it", func() {
+		suite.Limiter.AllowRequest("192.168.0.1", "")
+		allowed, _ := suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.False(allowed)
+
+		res, body := suite.request(http.MethodPost, "/clients/192.168.0.1/unblock?limiter=default", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`{"id":"192.168.0.1","limiter":"default","current_requests":0,"blocked":false}`, body)
+
+		allowed, _ = suite.Limiter.AllowRequest("192.168.0.1", "")
+		suite.True(allowed)
+
+		entry := suite.auditTrail()[0]
+		suite.Equal(audit.ACTION_CLIENT_UNBLOCKED, entry.Action)
+		suite.Equal("192.168.0.1", entry.Target)
+		suite.Equal("default", entry.Limiter)
+	})
+}
+
+func (suite *AdminTestSuite) TestAuditHandler() {
+	suite.request(http.MethodPut, "/keys/partner-key", `{"max_requests":20}`, nil)
+	suite.Clock.Advance(time.Minute)
+	suite.request(http.MethodDelete, "/keys/partner-key", "", nil)
+
+	res, body := suite.request(http.MethodGet, "/audit?target=partner-key&limit=1", "", nil)
+	suite.Equal(http.StatusOK, res.StatusCode)
+	var entries []audit.Entry
+	suite.NoError(json.Unmarshal([]byte(body), &entries))
+	suite.Len(entries, 1)
+	suite.Equal(audit.ACTION_API_KEY_REVOKED, entries[0].Action)
+
+	res, body = suite.request(http.MethodGet, "/audit?target=other-key", "", nil)
+	suite.Equal(http.StatusOK, res.StatusCode)
+	suite.JSONEq(`[]`, body)
+
+	for _, invalid := range []string{"from=yesterday", "to=today", "limit=-1"} {
+		res, _ := suite.request(http.MethodGet, "/audit?"+invalid, "", nil)
+		suite.Equal(http.StatusBadRequest, res.StatusCode, invalid)
+	}
+}
+
+func (suite *AdminTestSuite) TestUsageHandler() {
+	suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 5})
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.1", "")
+	suite.Limiter.AllowRequest("192.168.0.2", "")
+	suite.Limiter.AllowRequest("192.168.0.3", "goexpert-key")
+	suite.Limiter.AllowRequest("192.168.0.3", "goexpert-key")
+
+	suite.Run("Should return the usage history of the IP", func() {
+		res, body := suite.request(http.MethodGet, "/usage/ip/192.168.0.1?from=2024-05-10T11:00:00Z&to=2024-05-10T13:00:00Z", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`[{"limiter":"default","kind":"ip","id":"192.168.0.1","start":"2024-05-10T12:00:00Z","granularity":"minute","allowed":1,"refused":1,"cost":1,"requests":2}]`, body)
+	})
+
+	suite.Run("Should return the usage history of the API Key by the hour", func() {
+		res, body := suite.request(http.MethodGet, "/usage/api_key/goexpert-key?granularity=hour&from=2024-05-10T00:00:00Z&to=2024-05-10T13:00:00Z", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`[{"limiter":"default","kind":"api_key","id":"goexpert-key","start":"2024-05-10T12:00:00Z","granularity":"hour","allowed":2,"refused":0,"cost":2,"requests":2}]`, body)
+	})
+
+	suite.Run("Should return an empty history of an identity without usage", func() {
+		res, body := suite.request(http.MethodGet, "/usage/api_key/unknown-key?from=2024-05-10T11:00:00Z&to=2024-05-10T13:00:00Z", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.JSONEq(`[]`, body)
+	})
+
+	suite.Run("Should return the top n identities of the kind", func() {
+		res, body := suite.request(http.MethodGet, "/usage/top?kind=ip&granularity=hour&window=1h&n=2", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+
+		var top []admin.UsageDTO
+		suite.NoError(json.Unmarshal([]byte(body), &top))
+		suite.Len(top, 2)
+		suite.ElementsMatch([]string{"192.168.0.1", "192.168.0.3"}, []string{top[0].ID, top[1].ID})
+
+		res, body = suite.request(http.MethodGet, "/usage/top?kind=api_key", "", nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.NoError(json.Unmarshal([]byte(body), &top))
+		suite.Len(top, 1)
+		suite.Equal("goexpert-key", top[0].ID)
+		suite.Equal(2, top[0].Allowed)
+	})
+
+	suite.Run("Should return the usage as CSV if asked for", func() {
+		res, body := suite.request(http.MethodGet, "/usage/ip/192.168.0.2?from=2024-05-10T11:00:00Z&to=2024-05-10T13:00:00Z", "", map[string]string{"Accept": "text/csv"})
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("text/csv", res.Header.Get("Content-Type"))
+		suite.Equal("limiter,kind,id,start,granularity,allowed,refused,cost,requests\ndefault,ip,192.168.0.2,2024-05-10T12:00:00Z,minute,1,0,1,1\n", body)
+	})
+
+	invalidTargets := map[string]string{
+		"an invalid kind":            "/usage/device/192.168.0.1",
+		"an invalid granularity":     "/usage/ip/192.168.0.1?granularity=day",
+		"an invalid from":            "/usage/ip/192.168.0.1?from=yesterday",
+		"an invalid to":              "/usage/ip/192.168.0.1?to=now",
+		"an invalid top kind":        "/usage/top?kind=device",
+		"an invalid top granularity": "/usage/top?granularity=day",
+		"an invalid window":          "/usage/top?window=an-hour",
+		"a non positive window":      "/usage/top?window=0s",
+		"an invalid n":               "/usage/top?n=ten",
+		"a non positive n":           "/usage/top?n=0",
+	}
+	for name, target := range invalidTargets {
+		suite.Run("Should answer 400 on "+name, func() {
+			res, _ := suite.request(http.MethodGet, target, "", nil)
+			suite.Equal(http.StatusBadRequest, res.StatusCode)
+		})
+	}
+
+	suite.Run("Should answer 404 on an unknown limiter", func() {
+		res, _ := suite.request(http.MethodGet, "/usage/ip/192.168.0.1?limiter=reports", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+
+		res, _ = suite.request(http.MethodGet, "/usage/top?limiter=reports", "", nil)
+		suite.Equal(http.StatusNotFound, res.StatusCode)
+	})
+}
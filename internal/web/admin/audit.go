@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+)
+
+// AuditHandler queries the audit trail
+type AuditHandler struct {
+	Auditor *audit.Auditor
+}
+
+func NewAuditHandler(auditor *audit.Auditor) *AuditHandler {
+	return &AuditHandler{
+		Auditor: auditor,
+	}
+}
+
+func (h *AuditHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /audit", h.Query)
+}
+
+// Query returns the entries selected by the `target`, `action`, `from`, `to` and `limit` query params,
+// the latest first. `from` and `to` are RFC 3339 times
+func (h *AuditHandler) Query(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.Auditor.Query(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func parseFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Target: query.Get("target"),
+		Action: query.Get("action"),
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from: %s", err.Error())
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to: %s", err.Error())
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit: %q", limit)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const BEARER_PREFIX = "Bearer "
+
+var ErrUnauthorized = errors.New("a valid admin token is required")
+
+// Token is an admin API token and the principal it authenticates
+type Token struct {
+	Principal string
+	Token     string
+}
+
+type principalKey struct{}
+
+// Authenticator requires every admin request to carry one of the admin tokens as `Authorization: Bearer <token>`
+type Authenticator struct {
+	tokens []hashedToken
+}
+
+type hashedToken struct {
+	principal string
+	hash      [sha256.Size]byte
+}
+
+func NewAuthenticator(tokens ...Token) *Authenticator {
+	a := &Authenticator{}
+	for _, t := range tokens {
+		a.tokens = append(a.tokens, hashedToken{principal: t.Principal, hash: sha256.Sum256([]byte(t.Token))})
+	}
+	return a
+}
+
+// Require answers 401 the requests without a valid token, passing the others on with their principal
+func (a *Authenticator) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := a.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// authenticate returns the principal of the request token. Every token is compared in constant time,
+// so that the time taken tells nothing about them
+func (a *Authenticator) authenticate(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, BEARER_PREFIX) {
+		return "", false
+	}
+	hash := sha256.Sum256([]byte(strings.TrimPrefix(authorization, BEARER_PREFIX)))
+
+	principal, found := "", false
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(hash[:], t.hash[:]) == 1 {
+			principal, found = t.principal, true
+		}
+	}
+	return principal, found
+}
+
+// Principal returns who the request was authenticated as, empty if it was not
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// ParseTokens parses a comma separated list of tokens in the `<principal>:<token>` format,
+// as "alice@example.com:s3cr3t,ci:t0k3n"
+func ParseTokens(tokens string) ([]Token, error) {
+	var parsed []Token
+	for _, token := range strings.Split(tokens, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		principal, secret, found := strings.Cut(token, ":")
+		if !found || principal == "" || secret == "" {
+			return nil, fmt.Errorf("invalid admin token of %q, expected <principal>:<token>", principal)
+		}
+		parsed = append(parsed, Token{Principal: principal, Token: secret})
+	}
+	return parsed, nil
+}
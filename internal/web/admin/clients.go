@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+var ErrUnknownLimiter = errors.New("there is no limiter with this name")
+var ErrClientNotBlocked = errors.New("the client is not blocked")
+
+// ClientDTO is a client counter as returned by the admin API
+type ClientDTO struct {
+	ID              string `json:"id"`
+	Limiter         string `json:"limiter"`
+	CurrentRequests int    `json:"current_requests"`
+	Blocked         bool   `json:"blocked"`
+}
+
+// ClientsHandler lifts client blocks, recording every unblock on the audit trail
+type ClientsHandler struct {
+	// Limiters are the limiters clients can be unblocked on, by name. Limiters sharing a name share their clients
+	Limiters map[string]*limiter.Limiter
+	Auditor  *audit.Auditor
+}
+
+func NewClientsHandler(limiters map[string]*limiter.Limiter, auditor *audit.Auditor) *ClientsHandler {
+	return &ClientsHandler{
+		Limiters: limiters,
+		Auditor:  auditor,
+	}
+}
+
+func (h *ClientsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /clients/{id}/unblock", h.Unblock)
+}
+
+// Unblock lifts the block of the client, an IP or an API Key, on the limiter sent on the `limiter` query param,
+// the limiter without a name if not sent
+func (h *ClientsHandler) Unblock(w http.ResponseWriter, r *http.Request) {
+	actor, err := actor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	name, l, found := lookupLimiter(h.Limiters, r)
+	if !found {
+		http.Error(w, ErrUnknownLimiter.Error(), http.StatusNotFound)
+		return
+	}
+
+	clientID := r.PathValue("id")
+	client := l.Unblock(clientID)
+	if client == nil {
+		http.Error(w, ErrClientNotBlocked.Error(), http.StatusNotFound)
+		return
+	}
+
+	entry := audit.Entry{Action: audit.ACTION_CLIENT_UNBLOCKED, Actor: actor, Target: clientID, Limiter: name}
+	before := ClientDTO{ID: clientID, Limiter: name, CurrentRequests: client.CurrentRequests, Blocked: true}
+	after := ClientDTO{ID: clientID, Limiter: name}
+	if !recordChange(w, r, h.Auditor, entry, before, after) {
+		return
+	}
+	writeJSON(w, http.StatusOK, after)
+}
+
+// lookupLimiter returns the limiter named on the `limiter` query param, "default" if not sent
+func lookupLimiter(limiters map[string]*limiter.Limiter, r *http.Request) (string, *limiter.Limiter, bool) {
+	name := r.URL.Query().Get("limiter")
+	if name == "" {
+		name = "default"
+	}
+	l, found := limiters[name]
+	return name, l, found
+}
@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/audit"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+var ErrInvalidPriority = errors.New("priority must be low, normal, high or critical")
+var ErrInvalidMaxRequests = errors.New("max_requests must be greater than zero")
+
+// APIKeyDTO is an API Key as sent and returned by the admin API
+type APIKeyDTO struct {
+	ID           string `json:"id"`
+	MaxRequests  int    `json:"max_requests"`
+	DailyQuota   int    `json:"daily_quota,omitempty"`
+	MonthlyQuota int    `json:"monthly_quota,omitempty"`
+	Priority     string `json:"priority,omitempty"`
+}
+
+// KeysHandler creates, updates and revokes API Keys, recording every change on the audit trail
+type KeysHandler struct {
+	Repository limiter.LimiterRepositoryInterface
+	Auditor    *audit.Auditor
+}
+
+func NewKeysHandler(repository limiter.LimiterRepositoryInterface, auditor *audit.Auditor) *KeysHandler {
+	return &KeysHandler{
+		Repository: repository,
+		Auditor:    auditor,
+	}
+}
+
+func (h *KeysHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /keys/{id}", h.Get)
+	mux.HandleFunc("PUT /keys/{id}", h.Save)
+	mux.HandleFunc("DELETE /keys/{id}", h.Revoke)
+}
+
+func (h *KeysHandler) Get(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.Repository.ApiKey(r.PathValue("id"))
+	if apiKey == nil {
+		http.Error(w, limiter.ErrApiKeyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAPIKeyDTO(*apiKey))
+}
+
+// Save creates the API Key, or replaces it if it exists
+func (h *KeysHandler) Save(w http.ResponseWriter, r *http.Request) {
+	actor, err := actor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var dto APIKeyDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	dto.ID = r.PathValue("id")
+
+	apiKey, err := fromAPIKeyDTO(dto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := audit.Entry{Action: audit.ACTION_API_KEY_CREATED, Actor: actor, Target: apiKey.ID}
+	status := http.StatusCreated
+	var before any
+	if previous := h.Repository.ApiKey(apiKey.ID); previous != nil {
+		entry.Action = audit.ACTION_API_KEY_UPDATED
+		status = http.StatusOK
+		before = toAPIKeyDTO(*previous)
+	}
+
+	h.Repository.SaveApiKey(apiKey)
+	if !h.record(w, r, entry, before, toAPIKeyDTO(apiKey)) {
+		return
+	}
+	writeJSON(w, status, toAPIKeyDTO(apiKey))
+}
+
+// Revoke deletes the API Key, its next requests being refused as of unknown keys
+func (h *KeysHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	actor, err := actor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	apiKey := h.Repository.ApiKey(r.PathValue("id"))
+	if apiKey == nil {
+		http.Error(w, limiter.ErrApiKeyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.Repository.DeleteApiKey(apiKey.ID)
+	entry := audit.Entry{Action: audit.ACTION_API_KEY_REVOKED, Actor: actor, Target: apiKey.ID}
+	if !h.record(w, r, entry, toAPIKeyDTO(*apiKey), nil) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// record records the change, answering 500 if it could not be recorded
+func (h *KeysHandler) record(w http.ResponseWriter, r *http.Request, entry audit.Entry, before, after any) bool {
+	return recordChange(w, r, h.Auditor, entry, before, after)
+}
+
+// recordChange records the change already made, answering 500 if it could not be recorded
+// so that the admin knows the change is missing from the audit trail
+func recordChange(w http.ResponseWriter, r *http.Request, auditor *audit.Auditor, entry audit.Entry, before, after any) bool {
+	if err := auditor.Record(r.Context(), entry, before, after); err != nil {
+		slog.Error("audit entry not recorded",
+			slog.String("action", entry.Action),
+			slog.String("actor", entry.Actor),
+			slog.String("error", err.Error()),
+		)
+		http.Error(w, fmt.Sprintf("the change was made but not audited: %s", err.Error()), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func toAPIKeyDTO(apiKey limiter.APIKey) APIKeyDTO {
+	dto := APIKeyDTO{
+		ID:           apiKey.ID,
+		MaxRequests:  apiKey.MaxRequests,
+		DailyQuota:   apiKey.DailyQuota,
+		MonthlyQuota: apiKey.MonthlyQuota,
+	}
+	if apiKey.Priority != limiter.PRIORITY_DEFAULT {
+		dto.Priority = limiter.PriorityName(apiKey.Priority)
+	}
+	return dto
+}
+
+func fromAPIKeyDTO(dto APIKeyDTO) (limiter.APIKey, error) {
+	if dto.MaxRequests <= 0 {
+		return limiter.APIKey{}, ErrInvalidMaxRequests
+	}
+
+	apiKey := limiter.APIKey{
+		ID:           dto.ID,
+		MaxRequests:  dto.MaxRequests,
+		DailyQuota:   max(dto.DailyQuota, 0),
+		MonthlyQuota: max(dto.MonthlyQuota, 0),
+	}
+	if dto.Priority != "" {
+		priority, ok := limiter.ParsePriority(dto.Priority)
+		if !ok {
+			return limiter.APIKey{}, ErrInvalidPriority
+		}
+		apiKey.Priority = priority
+	}
+	return apiKey, nil
+}
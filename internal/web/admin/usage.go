@@ -0,0 +1,223 @@
+package admin
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// DEFAULT_TOP_USAGE is how many identities the top usage report returns if not asked for
+const DEFAULT_TOP_USAGE = 10
+
+var ErrInvalidUsageKind = errors.New("kind must be ip or api_key")
+var ErrInvalidGranularity = errors.New("granularity must be minute or hour")
+
+var usageCSVHeader = []string{"limiter", "kind", "id", "start", "granularity", "allowed", "refused", "cost", "requests"}
+
+// UsageDTO is the usage of an identity within a bucket, or within the report window, as returned by the admin API
+type UsageDTO struct {
+	Limiter     string    `json:"limiter"`
+	Kind        string    `json:"kind"`
+	ID          string    `json:"id"`
+	Start       time.Time `json:"start"`
+	Granularity string    `json:"granularity"`
+	Allowed     int       `json:"allowed"`
+	Refused     int       `json:"refused"`
+	Cost        int       `json:"cost"`
+	Requests    int       `json:"requests"`
+}
+
+// UsageHandler reports the usage recorded by the limiters, as JSON or as CSV if asked for
+type UsageHandler struct {
+	// Limiters are the limiters the usage can be reported of, by name
+	Limiters map[string]*limiter.Limiter
+}
+
+func NewUsageHandler(limiters map[string]*limiter.Limiter) *UsageHandler {
+	return &UsageHandler{
+		Limiters: limiters,
+	}
+}
+
+func (h *UsageHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /usage/top", h.Top)
+	mux.HandleFunc("GET /usage/{kind}/{id}", h.History)
+}
+
+// History returns the usage buckets of the identity, by the `granularity` (minute or hour) and within the
+// `from` and `to` RFC 3339 times. It defaults to the minutes of the last hour, or the hours of the last day
+func (h *UsageHandler) History(w http.ResponseWriter, r *http.Request) {
+	name, l, found := lookupLimiter(h.Limiters, r)
+	if !found {
+		http.Error(w, ErrUnknownLimiter.Error(), http.StatusNotFound)
+		return
+	}
+
+	kind, err := parseUsageKind(r.PathValue("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	granularity, err := parseGranularity(query.Get("granularity"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-defaultUsageWindow(granularity))
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeUsage(w, r, name, l.UsageHistory(kind, r.PathValue("id"), granularity, from, to))
+}
+
+// Top returns the `n` identities of the `kind` with the most requests within the `window` ending now,
+// by default the top 10 IPs of the last hour
+func (h *UsageHandler) Top(w http.ResponseWriter, r *http.Request) {
+	name, l, found := lookupLimiter(h.Limiters, r)
+	if !found {
+		http.Error(w, ErrUnknownLimiter.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	kind := limiter.DIMENSION_IP
+	if value := query.Get("kind"); value != "" {
+		var err error
+		if kind, err = parseUsageKind(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	granularity, err := parseGranularity(query.Get("granularity"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	window := time.Hour
+	if value := query.Get("window"); value != "" {
+		if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+			http.Error(w, fmt.Sprintf("invalid window: %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	n := DEFAULT_TOP_USAGE
+	if value := query.Get("n"); value != "" {
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid n: %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeUsage(w, r, name, l.TopUsage(kind, granularity, window, n))
+}
+
+// writeUsage writes the usage as CSV if asked for on the `format` query param or the Accept header, as JSON otherwise
+func writeUsage(w http.ResponseWriter, r *http.Request, limiterName string, usages []limiter.Usage) {
+	dtos := make([]UsageDTO, 0, len(usages))
+	for _, u := range usages {
+		dtos = append(dtos, toUsageDTO(limiterName, u))
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "csv" && !(format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv")) {
+		writeJSON(w, http.StatusOK, dtos)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	writer.Write(usageCSVHeader)
+	for _, dto := range dtos {
+		writer.Write([]string{
+			dto.Limiter,
+			dto.Kind,
+			dto.ID,
+			dto.Start.Format(time.RFC3339),
+			dto.Granularity,
+			strconv.Itoa(dto.Allowed),
+			strconv.Itoa(dto.Refused),
+			strconv.Itoa(dto.Cost),
+			strconv.Itoa(dto.Requests),
+		})
+	}
+	writer.Flush()
+}
+
+func toUsageDTO(limiterName string, usage limiter.Usage) UsageDTO {
+	return UsageDTO{
+		Limiter:     limiterName,
+		Kind:        usage.Kind,
+		ID:          usage.ID,
+		Start:       usage.Start,
+		Granularity: granularityName(usage.Granularity),
+		Allowed:     usage.Allowed,
+		Refused:     usage.Refused,
+		Cost:        usage.Cost,
+		Requests:    usage.Requests(),
+	}
+}
+
+func parseUsageKind(value string) (string, error) {
+	switch value {
+	case limiter.DIMENSION_IP, limiter.DIMENSION_API_KEY:
+		return value, nil
+	default:
+		return "", ErrInvalidUsageKind
+	}
+}
+
+// parseGranularity parses the usage granularity, minute if empty
+func parseGranularity(value string) (time.Duration, error) {
+	switch value {
+	case "", "minute":
+		return limiter.USAGE_MINUTE, nil
+	case "hour":
+		return limiter.USAGE_HOUR, nil
+	default:
+		return 0, ErrInvalidGranularity
+	}
+}
+
+// granularityName returns the bucket granularity name, or the span of a summed up usage
+func granularityName(granularity time.Duration) string {
+	switch granularity {
+	case limiter.USAGE_MINUTE:
+		return "minute"
+	case limiter.USAGE_HOUR:
+		return "hour"
+	default:
+		return granularity.String()
+	}
+}
+
+// defaultUsageWindow is how far back the usage history goes if not asked for
+func defaultUsageWindow(granularity time.Duration) time.Duration {
+	if granularity == limiter.USAGE_HOUR {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
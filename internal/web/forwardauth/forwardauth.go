@@ -0,0 +1,105 @@
+package forwardauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// Headers the edge proxies tell the original request by. nginx auth_request sends the X-Original-* ones,
+// as set on its config, and Traefik ForwardAuth the X-Forwarded-* ones
+const (
+	ORIGINAL_URI_HEADER     = "X-Original-URI"
+	ORIGINAL_METHOD_HEADER  = "X-Original-Method"
+	FORWARDED_URI_HEADER    = "X-Forwarded-Uri"
+	FORWARDED_METHOD_HEADER = "X-Forwarded-Method"
+	FORWARDED_HOST_HEADER   = "X-Forwarded-Host"
+)
+
+var ErrMissingOriginalURI = errors.New("the original request URI must be sent on " + ORIGINAL_URI_HEADER + " or " + FORWARDED_URI_HEADER)
+
+// Policy is the limiters checking the original requests matching its pattern
+type Policy struct {
+	// Pattern is the http.ServeMux pattern the original request is matched against, as `POST /api/`
+	Pattern string
+
+	// Limiters check the matching requests, the first one being the outermost
+	Limiters []*middleware.LimiterMiddleware
+}
+
+// Handler answers whether the original request of an edge proxy is allowed, without serving it.
+// The request is checked by the policy matching it, being answered 200 if allowed, as are the requests of no policy,
+// and with the status of the limiter middleware otherwise, as 429. The rate limit headers are set either way.
+// The client is identified as by the middleware, from the X-Real-Ip or X-Forwarded-For the edge proxy sends
+type Handler struct {
+	Policies []Policy
+
+	mux *http.ServeMux
+}
+
+func NewHandler(policies ...Policy) *Handler {
+	allow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux := http.NewServeMux()
+	for _, p := range policies {
+		mux.Handle(p.Pattern, middleware.Chain(allow, p.Limiters...))
+	}
+
+	return &Handler{
+		Policies: policies,
+		mux:      mux,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	original, err := originalRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	handler, pattern := h.mux.Handler(original)
+	if pattern == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	handler.ServeHTTP(w, original)
+}
+
+// originalRequest returns the request as the one the edge proxy is asking about,
+// with its method, URI and host, along with the headers of the auth request
+func originalRequest(r *http.Request) (*http.Request, error) {
+	uri := firstHeader(r, ORIGINAL_URI_HEADER, FORWARDED_URI_HEADER)
+	if uri == "" {
+		return nil, ErrMissingOriginalURI
+	}
+	originalURL, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	original := r.Clone(r.Context())
+	original.URL = originalURL
+	original.RequestURI = uri
+	if method := firstHeader(r, ORIGINAL_METHOD_HEADER, FORWARDED_METHOD_HEADER); method != "" {
+		original.Method = method
+	}
+	if host := r.Header.Get(FORWARDED_HOST_HEADER); host != "" {
+		original.Host = host
+	}
+	return original, nil
+}
+
+// firstHeader returns the value of the first header of the names that is set
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package forwardauth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/forwardauth"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 2
+
+type ForwardAuthTestSuite struct {
+	suite.Suite
+	Handler *forwardauth.Handler
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(ForwardAuthTestSuite))
+}
+
+func (suite *ForwardAuthTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	repository := database.NewMemoryLimiterRepository(clock)
+	newLimiter := func(name string, maxRequests int) *middleware.LimiterMiddleware {
+		return middleware.NewLimiterMiddleware(limiter.NewLimiter(limiter.LimiterConfig{
+			Name:                  name,
+			ClientCheckType:       limiter.CHECK_IP_ONLY,
+			ClientBlockTime:       time.Second,
+			MaxIPRequests:         maxRequests,
+			RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+		}, repository).WithClock(clock))
+	}
+
+	suite.Handler = forwardauth.NewHandler(
+		forwardauth.Policy{Pattern: "POST /api/", Limiters: []*middleware.LimiterMiddleware{newLimiter("writes", MaxRequests)}},
+		forwardauth.Policy{Pattern: "/api/", Limiters: []*middleware.LimiterMiddleware{newLimiter("", MaxRequests*2)}},
+	)
+}
+
+// ask asks whether the original request is allowed, with the headers of the edge proxy
+func (suite *ForwardAuthTestSuite) ask(headers map[string]string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	suite.Handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func (suite *ForwardAuthTestSuite) TestHandler_Traefik() {
+	headers := map[string]string{
+		forwardauth.FORWARDED_METHOD_HEADER: http.MethodPost,
+		forwardauth.FORWARDED_URI_HEADER:    "/api/orders?page=2",
+		forwardauth.FORWARDED_HOST_HEADER:   "shop.example.com",
+		"X-Forwarded-For":                   "192.168.0.1",
+	}
+
+	suite.Run("Should allow the requests within the limit of the matching policy, telling the remaining", func() {
+		res := suite.ask(headers)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("1", res.Header.Get("X-RateLimit-Remaining"))
+		suite.Equal(http.StatusOK, suite.ask(headers).StatusCode)
+	})
+
+	suite.Run("Should answer 429 with the rate limit headers once the limit is reached", func() {
+		res := suite.ask(headers)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Equal("0", res.Header.Get("X-RateLimit-Remaining"))
+	})
+
+	suite.Run("Should count the other clients apart", func() {
+		headers := map[string]string{
+			forwardauth.FORWARDED_METHOD_HEADER: http.MethodPost,
+			forwardauth.FORWARDED_URI_HEADER:    "/api/orders",
+			"X-Forwarded-For":                   "192.168.0.2",
+		}
+		suite.Equal(http.StatusOK, suite.ask(headers).StatusCode)
+	})
+}
+
+func (suite *ForwardAuthTestSuite) TestHandler_Nginx() {
+	headers := map[string]string{
+		forwardauth.ORIGINAL_URI_HEADER:    "/api/orders",
+		forwardauth.ORIGINAL_METHOD_HEADER: http.MethodGet,
+		"X-Real-Ip":                        "192.168.0.1",
+	}
+
+	suite.Run("Should check the request by the policy of its method", func() {
+		for i := 0; i < MaxRequests*2; i++ {
+			suite.Equal(http.StatusOK, suite.ask(headers).StatusCode)
+		}
+		suite.Equal(http.StatusTooManyRequests, suite.ask(headers).StatusCode)
+	})
+
+	suite.Run("Should allow the requests of no policy", func() {
+		res := suite.ask(map[string]string{forwardauth.ORIGINAL_URI_HEADER: "/health", "X-Real-Ip": "192.168.0.1"})
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Empty(res.Header.Get("X-RateLimit-Remaining"))
+	})
+
+	suite.Run("Should answer 400 without the original URI", func() {
+		suite.Equal(http.StatusBadRequest, suite.ask(map[string]string{"X-Real-Ip": "192.168.0.1"}).StatusCode)
+	})
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+type ConcurrencyMiddleware struct {
+	Limiter *limiter.ConcurrencyLimiter
+
+	// Logger logs the slots acquired, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+func NewConcurrencyMiddleware(limiter *limiter.ConcurrencyLimiter) *ConcurrencyMiddleware {
+	return &ConcurrencyMiddleware{
+		Limiter: limiter,
+	}
+}
+
+// WithLogger sets the logger the slots acquired are logged by
+func (m *ConcurrencyMiddleware) WithLogger(logger *slog.Logger) *ConcurrencyMiddleware {
+	m.Logger = logger
+	return m
+}
+
+// Limit holds a slot while the request is handled, releasing it once the handler returns or panics
+func (m *ConcurrencyMiddleware) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(w.Header(), r)
+		apiKey := r.Header.Get("API_KEY")
+		clientIP := GetIP(r)
+		lease, err := m.Limiter.Acquire(clientIP, apiKey)
+
+		logger := requestLogger(r.Context(), m.Logger, clientIP, apiKey).With(slog.Int("check_type", m.Limiter.Config.ClientCheckType))
+		if err != nil {
+			logger.Warn("slot refused", slog.String("reason", limiter.Reason(err)))
+			writeError(w, err)
+			return
+		}
+		logger.Debug("slot acquired")
+
+		done := make(chan struct{})
+		defer func() {
+			close(done)
+			m.Limiter.Release(lease)
+		}()
+		go m.renew(lease, done)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// renew keeps the lease of a slow request until it is done
+func (m *ConcurrencyMiddleware) renew(lease *limiter.Lease, done <-chan struct{}) {
+	ticker := time.NewTicker(m.Limiter.LeaseTTL() / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m.Limiter.Renew(lease)
+		}
+	}
+}
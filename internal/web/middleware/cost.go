@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var ErrInvalidCostHeader = errors.New("the request cost header is invalid")
+
+// CostFunc returns how many requests a request costs to the limiter
+type CostFunc func(r *http.Request) (int, error)
+
+// FixedCost charges the same cost for every request, as a per route setting
+func FixedCost(cost int) CostFunc {
+	return func(r *http.Request) (int, error) {
+		return cost, nil
+	}
+}
+
+// HeaderCost charges the cost sent on the request header, as a batch size.
+// Requests without the header cost defaultCost
+func HeaderCost(header string, defaultCost int) CostFunc {
+	return func(r *http.Request) (int, error) {
+		value := r.Header.Get(header)
+		if value == "" {
+			return defaultCost, nil
+		}
+
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost < 1 {
+			return 0, fmt.Errorf("%w: %s must be a positive number", ErrInvalidCostHeader, header)
+		}
+		return cost, nil
+	}
+}
@@ -1,16 +1,44 @@
 package middleware
 
 import (
+	"context"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
 )
 
+// SHADOW_HEADER reports the limiters on dry run that would have refused the request
+const SHADOW_HEADER = "X-Ratelimit-Shadow"
+
 type LimiterMiddleware struct {
 	Limiter *limiter.Limiter
+
+	// Cost returns how many requests a request costs, each request costs 1 if nil
+	Cost CostFunc
+
+	// Priority returns the request priority class, taken from the API Key if nil
+	Priority PriorityFunc
+
+	// MaxDelay is how long a refused request is held waiting to be allowed, requests are refused right away if 0
+	MaxDelay time.Duration
+
+	// MaxQueue is how many requests can be held waiting at once, the next ones are refused right away
+	MaxQueue int
+
+	// Logger logs the held requests, slog.Default() if nil. The decisions are logged by the limiter
+	Logger *slog.Logger
+
+	queued atomic.Int64
 }
 
 func NewLimiterMiddleware(limiter *limiter.Limiter) *LimiterMiddleware {
@@ -19,50 +47,266 @@ func NewLimiterMiddleware(limiter *limiter.Limiter) *LimiterMiddleware {
 	}
 }
 
+// WithCost sets how many requests a request costs to the limiter
+func (m *LimiterMiddleware) WithCost(cost CostFunc) *LimiterMiddleware {
+	m.Cost = cost
+	return m
+}
+
+// WithPriority sets how the request priority class is taken, as from the route or a header
+func (m *LimiterMiddleware) WithPriority(priority PriorityFunc) *LimiterMiddleware {
+	m.Priority = priority
+	return m
+}
+
+// WithLogger sets the logger the held requests are logged by
+func (m *LimiterMiddleware) WithLogger(logger *slog.Logger) *LimiterMiddleware {
+	m.Logger = logger
+	return m
+}
+
+// WithWait holds the refused requests up to maxDelay until they are allowed, instead of refusing them,
+// as long as there are less than maxQueue requests held. The requests are reserved instead of decided,
+// so the refusals are reported along with when the limit is reset but neither block the client nor escalate its penalty
+func (m *LimiterMiddleware) WithWait(maxDelay time.Duration, maxQueue int) *LimiterMiddleware {
+	m.MaxDelay = maxDelay
+	m.MaxQueue = maxQueue
+	return m
+}
+
 func (m *LimiterMiddleware) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("API_KEY")
-		clientIP := GetIP(r)
-		allowed, err := m.Limiter.AllowRequest(clientIP, apiKey)
-		log.Printf(
-			"IP: %s | ApiKey: %s | Req Allowed: %v | Config: %v (0 - IP Only | 1 - ApiKey Only | 2 - IP or API Key)\n\n",
-			clientIP,
-			apiKey,
-			allowed,
-			m.Limiter.Config.ClientCheckType,
-		)
-		if allowed {
-			next.ServeHTTP(w, r)
+		r, result := m.Check(r)
+		result.SetHeader(w.Header())
+		if !result.Allowed {
+			http.Error(w, result.Err.Error(), result.Status)
 			return
 		}
+		m.serve(next, w, r)
+	})
+}
 
-		if errors.Is(err, limiter.ErrMaxNumberRequestsReached) {
-			http.Error(
-				w,
-				err.Error(),
-				http.StatusTooManyRequests,
-			)
-			return
+// Result is how to answer a checked request, for Limit and the adapters of other frameworks to render it alike
+type Result struct {
+	Allowed bool
+
+	// Header holds the headers to answer with, whether the request is allowed or not
+	Header http.Header
+
+	// Status is the status code the refused request is answered with
+	Status int
+
+	// Err is why the request was refused
+	Err error
+}
+
+// SetHeader sets the result headers on the response header. The shadow limiters are added to the ones
+// already reported, so that every shadow limiter on the route is
+func (r Result) SetHeader(header http.Header) {
+	for name, values := range r.Header {
+		if name == SHADOW_HEADER {
+			header[name] = append(header[name], values...)
+			continue
 		}
+		header[name] = values
+	}
+}
 
-		if errors.Is(err, limiter.ErrInvalidClient) ||
-			errors.Is(err, limiter.ErrApiKeyNotFound) {
-			http.Error(
-				w,
-				err.Error(),
-				http.StatusBadRequest,
-			)
-			return
+// Check decides whether the request is allowed, returning it along with its request ID and the trace propagated by the caller.
+// The allowed request must be handled through Handle for the adaptive limit to follow it
+func (m *LimiterMiddleware) Check(r *http.Request) (*http.Request, Result) {
+	result := Result{Header: http.Header{}}
+	r = withRequestID(result.Header, r.WithContext(extractTraceContext(r)))
+	ctx, span := startLimitSpan(r, m.Limiter.Config.Name)
+	defer span.End()
+
+	cost := 1
+	if m.Cost != nil {
+		var err error
+		cost, err = m.Cost(r)
+		if err != nil {
+			endLimitSpan(span, err)
+			result.Status, result.Err = http.StatusBadRequest, err
+			return r, result
 		}
+	}
+
+	req := limiter.Request{
+		ClientID: GetIP(r),
+		APIKeyID: r.Header.Get("API_KEY"),
+		Cost:     cost,
+	}
+	if m.Priority != nil {
+		req.Priority = m.Priority(r)
+	}
 
-		http.Error(
-			w,
-			err.Error(),
-			http.StatusInternalServerError,
-		)
+	decision := m.decide(ctx, req)
+	if decision.Shadowed {
+		result.Header.Set(SHADOW_HEADER, shadowHeader(m.Limiter.Config.Name, decision.Err))
+	}
+	if decision.Remaining >= 0 && !decision.Shadowed {
+		result.Header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	}
+	if decision.Allowed {
+		result.Allowed = true
+		return r, result
+	}
+
+	endLimitSpan(span, decision.Err)
+	result.Status, result.Err = errorStatus(result.Header, decision.Err), decision.Err
+	return r, result
+}
+
+// Handle runs the handling of an allowed request, observing its latency and the status handle returns if the limit is adaptive.
+// A panicking handler fails the request, the panic is left to the server
+func (m *LimiterMiddleware) Handle(handle func() int) {
+	controller := m.Limiter.Adaptive
+	if controller == nil {
+		handle()
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			controller.Observe(time.Since(start), true)
+			panic(p)
+		}
+	}()
+	status := handle()
+	controller.Observe(time.Since(start), status >= http.StatusInternalServerError)
+}
+
+// Chain returns next behind the limiters, the first one being the outermost
+func Chain(next http.Handler, limiters ...*LimiterMiddleware) http.Handler {
+	for i := len(limiters) - 1; i >= 0; i-- {
+		next = limiters[i].Limit(next)
+	}
+	return next
+}
+
+// serve handles the allowed request, recording the status it is answered with if the limit is adaptive
+func (m *LimiterMiddleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if m.Limiter.Adaptive == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	m.Handle(func() int {
+		next.ServeHTTP(recorder, r)
+		return recorder.status
 	})
 }
 
+// writeError answers the refused request with the status of the error,
+// telling the client when it can request again if the limit reports it
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(w.Header(), err))
+}
+
+// errorStatus returns the status code the error is answered with,
+// setting on header when the client can request again if the limit reports it
+func errorStatus(header http.Header, err error) int {
+	var limitErr *limiter.LimitError
+	if errors.As(err, &limitErr) {
+		if !limitErr.ResetAt.IsZero() {
+			setResetHeaders(header, limitErr.ResetAt)
+		}
+		if limitErr.Dimension != "" {
+			header.Set("X-RateLimit-Dimension", limitErr.Dimension)
+		}
+		if limitErr.PenaltyLevel > 0 {
+			header.Set("X-RateLimit-Penalty-Level", strconv.Itoa(limitErr.PenaltyLevel))
+		}
+	}
+
+	switch {
+	case errors.Is(err, limiter.ErrClientDenied):
+		return http.StatusForbidden
+	case errors.Is(err, limiter.ErrGlobalLimitReached):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, limiter.ErrMaxNumberRequestsReached),
+		errors.Is(err, limiter.ErrMaxConcurrentRequests),
+		errors.Is(err, limiter.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, limiter.ErrInvalidClient),
+		errors.Is(err, limiter.ErrInvalidCost),
+		errors.Is(err, limiter.ErrCostExceedsLimit),
+		errors.Is(err, limiter.ErrApiKeyNotFound):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// decide checks the request, holding it while it can be allowed within the MaxDelay.
+// The requests beyond the MaxQueue are only reserved, so that they are allowed if they can be right away
+func (m *LimiterMiddleware) decide(ctx context.Context, req limiter.Request) limiter.Decision {
+	if m.MaxDelay <= 0 {
+		return m.Limiter.DecideRequest(ctx, req)
+	}
+
+	if m.queued.Add(1) > int64(m.MaxQueue) {
+		m.queued.Add(-1)
+		decision, delay := m.Limiter.ReserveRequest(ctx, req)
+		if delay > 0 && delay <= m.MaxDelay {
+			m.logger(ctx, req).Warn("wait queue full", slog.Int("max_queue", m.MaxQueue))
+		}
+		return decision
+	}
+	defer m.queued.Add(-1)
+
+	// the request context is done if the client disconnects
+	ctx, cancel := context.WithTimeout(ctx, m.MaxDelay)
+	defer cancel()
+
+	start := time.Now()
+	decision := m.Limiter.WaitRequest(ctx, req)
+	if held := time.Since(start); held >= limiter.MIN_RETRY_DELAY {
+		m.logger(ctx, req).Info("request held", slog.Duration("held", held), slog.Bool("allowed_after_wait", decision.Allowed))
+	}
+	return decision
+}
+
+// logger returns the logger of the request, API Keys being redacted
+func (m *LimiterMiddleware) logger(ctx context.Context, req limiter.Request) *slog.Logger {
+	return requestLogger(ctx, m.Logger, req.ClientID, req.APIKeyID)
+}
+
+// requestLogger returns the logger, slog.Default() if nil, logging along with the request
+func requestLogger(ctx context.Context, logger *slog.Logger, clientID, apiKeyID string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With(slog.String(logging.REQUEST_ID_KEY, logging.RequestID(ctx)), slog.String("client", clientID))
+	if apiKeyID != "" {
+		logger = logger.With(slog.Any("api_key", logging.Secret(apiKeyID)))
+	}
+	return logger
+}
+
+// shadowHeader reports the limiter on dry run and why it would have refused the request, as `<name>=<reason>`
+func shadowHeader(name string, err error) string {
+	if name == "" {
+		name = "default"
+	}
+	return name + "=" + limiter.Reason(err)
+}
+
+// setResetHeaders tells the client when it will be able to request again
+func setResetHeaders(header http.Header, resetAt time.Time) {
+	retryAfter := int(math.Ceil(time.Until(resetAt).Seconds()))
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+}
+
+// GetIP returns the client IP of the request, from the X-Real-Ip or X-Forwarded-For headers or its remote address.
+// The port is dropped, IPv6 addresses being kept whole, and values that are not an IP are returned without the port if any
 func GetIP(r *http.Request) string {
 	ip := r.Header.Get("X-Real-Ip")
 	if ip == "" {
@@ -72,9 +316,15 @@ func GetIP(r *http.Request) string {
 		ip = r.RemoteAddr
 	}
 
-	i := strings.Index(ip, ":")
-	if i > -1 {
-		ip = ip[:i]
+	ip = strings.TrimSpace(ip)
+	if addrPort, err := netip.ParseAddrPort(ip); err == nil {
+		return addrPort.Addr().Unmap().String()
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")); err == nil {
+		return addr.Unmap().String()
+	}
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		return host
 	}
 	return ip
 }
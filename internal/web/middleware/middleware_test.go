@@ -0,0 +1,610 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+const MaxRequests = 5
+const CostHeader = "X-Cost"
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+	Clock      *limiter.FakeClock
+	Repository *database.MemoryLimiterRepository
+	Config     limiter.LimiterConfig
+	Handled    int
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareTestSuite))
+}
+
+func (suite *MiddlewareTestSuite) SetupTest() {
+	suite.Clock = limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Repository = database.NewMemoryLimiterRepository(suite.Clock)
+	suite.Config = limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Minute,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}
+	suite.Handled = 0
+}
+
+// limiterMiddleware returns a middleware of a limiter of the suite config
+func (suite *MiddlewareTestSuite) limiterMiddleware() *middleware.LimiterMiddleware {
+	return middleware.NewLimiterMiddleware(limiter.NewLimiter(suite.Config, suite.Repository).WithClock(suite.Clock))
+}
+
+// handler returns a handler counting the requests it handles
+func (suite *MiddlewareTestSuite) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Handled++
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// request sends a request from the client IP with the headers, returning the response and its body
+func (suite *MiddlewareTestSuite) request(handler http.Handler, headers map[string]string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.0.1:5000"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	body, _ := io.ReadAll(res.Body)
+	return res, strings.TrimSpace(string(body))
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_HeaderCost() {
+	handler := suite.limiterMiddleware().WithCost(middleware.HeaderCost(CostHeader, 1)).Limit(suite.handler())
+
+	suite.Run("Should charge the cost of the header, 1 without it", func() {
+		res, _ := suite.request(handler, map[string]string{CostHeader: "3"})
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("2", res.Header.Get("X-RateLimit-Remaining"))
+
+		res, _ = suite.request(handler, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("1", res.Header.Get("X-RateLimit-Remaining"))
+	})
+
+	for _, invalid := range []string{"a", "0", "-2", "1.5"} {
+		suite.Run("Should answer 400 without charging an invalid cost header "+invalid, func() {
+			res, body := suite.request(handler, map[string]string{CostHeader: invalid})
+			suite.Equal(http.StatusBadRequest, res.StatusCode)
+			suite.Contains(body, middleware.ErrInvalidCostHeader.Error())
+			suite.Empty(res.Header.Get("X-RateLimit-Remaining"))
+		})
+	}
+
+	suite.Run("Should answer 400 a cost greater than the limit, as no request could ever be allowed with it", func() {
+		res, body := suite.request(handler, map[string]string{CostHeader: "6"})
+		suite.Equal(http.StatusBadRequest, res.StatusCode)
+		suite.Contains(body, limiter.ErrCostExceedsLimit.Error())
+	})
+
+	suite.Equal(2, suite.Handled)
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_FixedCost() {
+	testCases := []struct {
+		Name     string
+		Cost     int
+		Status   int
+		Error    error
+		Handled  int
+		Requests int
+	}{
+		{Name: "Should charge the fixed cost of every request", Cost: 2, Status: http.StatusTooManyRequests, Error: limiter.ErrMaxNumberRequestsReached, Handled: 2, Requests: 3},
+		{Name: "Should answer 400 a zero cost", Cost: 0, Status: http.StatusBadRequest, Error: limiter.ErrInvalidCost, Requests: 1},
+		{Name: "Should answer 400 a negative cost", Cost: -1, Status: http.StatusBadRequest, Error: limiter.ErrInvalidCost, Requests: 1},
+		{Name: "Should answer 400 a cost greater than the limit", Cost: MaxRequests + 1, Status: http.StatusBadRequest, Error: limiter.ErrCostExceedsLimit, Requests: 1},
+	}
+
+	for _, tc := range testCases {
+		suite.Run(tc.Name, func() {
+			suite.SetupTest()
+			handler := suite.limiterMiddleware().WithCost(middleware.FixedCost(tc.Cost)).Limit(suite.handler())
+
+			var res *http.Response
+			var body string
+			for i := 0; i < tc.Requests; i++ {
+				res, body = suite.request(handler, nil)
+			}
+			suite.Equal(tc.Status, res.StatusCode)
+			suite.Contains(body, tc.Error.Error())
+			suite.Equal(tc.Handled, suite.Handled)
+		})
+	}
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_Shadow() {
+	suite.Config.Name = "canary"
+	suite.Config.DryRun = true
+	handler := suite.limiterMiddleware().Limit(suite.handler())
+
+	suite.Run("Should not report the requests the limiter on dry run allows", func() {
+		for i := 0; i < MaxRequests; i++ {
+			res, _ := suite.request(handler, nil)
+			suite.Equal(http.StatusOK, res.StatusCode)
+			suite.Empty(res.Header.Get(middleware.SHADOW_HEADER))
+		}
+	})
+
+	suite.Run("Should allow the requests the limiter on dry run would refuse, reporting why", func() {
+		res, _ := suite.request(handler, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal("canary=max_requests", res.Header.Get(middleware.SHADOW_HEADER))
+		suite.Empty(res.Header.Get("Retry-After"))
+	})
+
+	suite.Run("Should report every limiter on dry run that would refuse the request", func() {
+		enforced := suite.Config
+		enforced.Name = ""
+		enforced.DryRun = false
+		enforced.MaxIPRequests = MaxRequests * 2
+		shadow := middleware.NewLimiterMiddleware(limiter.NewLimiter(enforced, suite.Repository).WithClock(suite.Clock))
+		suite.Config.Name = "strict"
+		suite.Config.MaxIPRequests = 1
+		handler := suite.limiterMiddleware().Limit(shadow.Limit(handler))
+
+		suite.request(handler, nil)
+		res, _ := suite.request(handler, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal([]string{"strict=max_requests", "canary=max_requests"}, res.Header.Values(middleware.SHADOW_HEADER))
+	})
+
+	suite.Equal(MaxRequests+3, suite.Handled)
+}
+
+// waitingMiddleware returns a middleware holding the refused requests, of a limiter of the suite config timed by the wall clock,
+// so that the held requests are allowed once the limit interval is over
+func (suite *MiddlewareTestSuite) waitingMiddleware(maxDelay time.Duration, maxQueue int) *middleware.LimiterMiddleware {
+	suite.Repository = database.NewMemoryLimiterRepository(limiter.SystemClock{})
+	suite.Config.MaxIPRequests = 1
+	suite.Config.RequestsLimitInterval = time.Millisecond * 100
+	return middleware.NewLimiterMiddleware(limiter.NewLimiter(suite.Config, suite.Repository)).WithWait(maxDelay, maxQueue)
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_Wait() {
+	suite.Run("Should hold the refused request until it is allowed, without blocking the client", func() {
+		suite.SetupTest()
+		handler := suite.waitingMiddleware(time.Second, 1).Limit(suite.handler())
+
+		res, _ := suite.request(handler, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+
+		start := time.Now()
+		res, _ = suite.request(handler, nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.GreaterOrEqual(time.Since(start), time.Millisecond*50)
+		suite.Equal(2, suite.Handled)
+		suite.False(suite.Repository.Client("192.168.0.1").Blocked)
+	})
+
+	suite.Run("Should record the usage and observe the held request once, as allowed", func() {
+		suite.SetupTest()
+		suite.Config.UsageRetention = limiter.UsageRetention{Minute: time.Hour}
+		m := suite.waitingMiddleware(time.Second, 1)
+		observer := &decisionCounter{}
+		m.Limiter.WithObserver(observer)
+		handler := m.Limit(suite.handler())
+
+		for i := 0; i < 2; i++ {
+			res, _ := suite.request(handler, nil)
+			suite.Equal(http.StatusOK, res.StatusCode)
+		}
+
+		usage := m.Limiter.TopUsage(limiter.DIMENSION_IP, limiter.USAGE_MINUTE, time.Hour, 1)
+		suite.Len(usage, 1)
+		suite.Equal(2, usage[0].Allowed)
+		suite.Zero(usage[0].Refused)
+		suite.Equal(2, observer.allowed)
+		suite.Zero(observer.refused)
+	})
+
+	suite.Run("Should refuse right away the request that would only be allowed after the max delay", func() {
+		suite.SetupTest()
+		handler := suite.waitingMiddleware(time.Millisecond*20, 1).Limit(suite.handler())
+		suite.request(handler, nil)
+
+		start := time.Now()
+		res, body := suite.request(handler, nil)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Contains(body, limiter.ErrMaxNumberRequestsReached.Error())
+		suite.Less(time.Since(start), time.Millisecond*20)
+		suite.NotEmpty(res.Header.Get("Retry-After"))
+		suite.False(suite.Repository.Client("192.168.0.1").Blocked)
+	})
+
+	suite.Run("Should refuse right away the requests beyond the max queue, holding the queued ones", func() {
+		suite.SetupTest()
+		handler := suite.waitingMiddleware(time.Second, 1).Limit(suite.handler())
+		suite.request(handler, nil)
+
+		var held *http.Response
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held, _ = suite.request(handler, nil)
+		}()
+		time.Sleep(time.Millisecond * 20)
+
+		start := time.Now()
+		res, body := suite.request(handler, nil)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Contains(body, limiter.ErrMaxNumberRequestsReached.Error())
+		suite.Less(time.Since(start), time.Millisecond*20)
+
+		wg.Wait()
+		suite.Equal(http.StatusOK, held.StatusCode)
+		suite.Equal(2, suite.Handled)
+	})
+
+	suite.Run("Should stop holding the request once the client disconnects", func() {
+		suite.SetupTest()
+		suite.Config.ClientBlockTime = time.Second
+		handler := suite.waitingMiddleware(time.Second, 1).Limit(suite.handler())
+		suite.request(handler, nil)
+		suite.Repository.SaveClient(limiter.Client{ID: "192.168.0.1", CurrentRequests: 1, TTL: time.Millisecond * 500})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*20, cancel)
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		req.RemoteAddr = "192.168.0.1:5000"
+		rec := httptest.NewRecorder()
+
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+		suite.Equal(http.StatusTooManyRequests, rec.Code)
+		suite.Contains(rec.Body.String(), context.Canceled.Error())
+		suite.Less(time.Since(start), time.Millisecond*200)
+		suite.Equal(1, suite.Handled)
+	})
+}
+
+// decisionCounter counts the decisions it is told of
+type decisionCounter struct {
+	mu      sync.Mutex
+	allowed int
+	refused int
+}
+
+func (c *decisionCounter) ObserveDecision(conf limiter.LimiterConfig, req limiter.Request, decision limiter.Decision, elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if decision.Allowed {
+		c.allowed++
+	} else {
+		c.refused++
+	}
+}
+
+// concurrencyMiddleware returns a middleware of a concurrency limiter of a single request in flight per client
+func (suite *MiddlewareTestSuite) concurrencyMiddleware() *middleware.ConcurrencyMiddleware {
+	return middleware.NewConcurrencyMiddleware(limiter.NewConcurrencyLimiter(limiter.ConcurrencyConfig{
+		ClientCheckType: limiter.CHECK_IP_OR_API_KEY,
+		MaxInFlight:     1,
+	}, suite.Repository))
+}
+
+func (suite *MiddlewareTestSuite) TestConcurrency_Release() {
+	suite.Run("Should release the slot of a handler that panics", func() {
+		suite.SetupTest()
+		m := suite.concurrencyMiddleware()
+		panicking := m.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("handler failed")
+		}))
+
+		suite.Panics(func() { suite.request(panicking, nil) })
+
+		res, _ := suite.request(m.Limit(suite.handler()), nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+	})
+
+	suite.Run("Should release the slot of a request whose client disconnects", func() {
+		suite.SetupTest()
+		m := suite.concurrencyMiddleware()
+		started := make(chan struct{})
+		waiting := m.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-r.Context().Done()
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		req.RemoteAddr = "192.168.0.1:5000"
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			waiting.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		<-started
+
+		res, body := suite.request(m.Limit(suite.handler()), nil)
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Contains(body, limiter.ErrMaxConcurrentRequests.Error())
+
+		cancel()
+		<-done
+		res, _ = suite.request(m.Limit(suite.handler()), nil)
+		suite.Equal(http.StatusOK, res.StatusCode)
+	})
+
+	suite.Run("Should lease the slots of the IP if the API Key sent is not found", func() {
+		suite.SetupTest()
+		suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10})
+		m := suite.concurrencyMiddleware()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		holding := m.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			suite.request(holding, map[string]string{"API_KEY": "spoofed-key"})
+		}()
+		<-started
+
+		res, _ := suite.request(m.Limit(suite.handler()), map[string]string{"API_KEY": "other-spoofed-key"})
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		res, _ = suite.request(m.Limit(suite.handler()), map[string]string{"API_KEY": "goexpert-key"})
+		suite.Equal(http.StatusOK, res.StatusCode)
+
+		close(release)
+		<-done
+	})
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_HeaderPriority() {
+	const PriorityHeader = "X-Priority"
+	testCases := []struct {
+		Name    string
+		Headers map[string]string
+		Allowed int
+	}{
+		{Name: "Should limit the priority of the header by its share", Headers: map[string]string{PriorityHeader: "high"}, Allowed: 8},
+		{Name: "Should parse the priority of the header regardless of its case", Headers: map[string]string{PriorityHeader: " Normal "}, Allowed: 5},
+		{Name: "Should fall back to the low priority of anonymous requests without the header", Allowed: 2},
+		{Name: "Should fall back to the low priority of anonymous requests on an unknown priority", Headers: map[string]string{PriorityHeader: "urgent"}, Allowed: 2},
+		{Name: "Should fall back to the API Key priority on an unknown priority", Headers: map[string]string{PriorityHeader: "urgent", "API_KEY": "goexpert-key"}, Allowed: 8},
+	}
+
+	for _, tc := range testCases {
+		suite.Run(tc.Name, func() {
+			suite.SetupTest()
+			suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10, Priority: limiter.PRIORITY_HIGH})
+			suite.Config.ClientCheckType = limiter.CHECK_GLOBAL
+			suite.Config.MaxGlobalRequests = 10
+			suite.Config.PriorityShares = map[int]float64{
+				limiter.PRIORITY_LOW:    0.2,
+				limiter.PRIORITY_NORMAL: 0.5,
+				limiter.PRIORITY_HIGH:   0.8,
+			}
+			handler := suite.limiterMiddleware().WithPriority(middleware.HeaderPriority(PriorityHeader)).Limit(suite.handler())
+
+			for i := 0; i < 10; i++ {
+				suite.request(handler, tc.Headers)
+			}
+			suite.Equal(tc.Allowed, suite.Handled)
+		})
+	}
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_ErrorStatus() {
+	limit := func(conf func(c *limiter.LimiterConfig), opts ...func(l *limiter.Limiter)) func() http.Handler {
+		return func() http.Handler {
+			conf(&suite.Config)
+			l := limiter.NewLimiter(suite.Config, suite.Repository).WithClock(suite.Clock)
+			for _, opt := range opts {
+				opt(l)
+			}
+			return middleware.NewLimiterMiddleware(l).Limit(suite.handler())
+		}
+	}
+	concurrency := func(conf limiter.ConcurrencyConfig) func() http.Handler {
+		return func() http.Handler {
+			return middleware.NewConcurrencyMiddleware(limiter.NewConcurrencyLimiter(conf, suite.Repository)).Limit(suite.handler())
+		}
+	}
+	testCases := []struct {
+		Name     string
+		Handler  func() http.Handler
+		NoClient bool
+		Headers  map[string]string
+		Requests int
+		Status   int
+		Err      error
+	}{
+		{
+			Name: "Should answer 403 to a denied client",
+			Handler: limit(func(c *limiter.LimiterConfig) {}, func(l *limiter.Limiter) {
+				l.AccessList = limiter.NewAccessList(suite.Repository)
+				suite.NoError(l.AccessList.Save(limiter.AccessEntry{Value: "192.168.0.1", Access: limiter.ACCESS_DENY}))
+			}),
+			Status: http.StatusForbidden,
+			Err:    limiter.ErrClientDenied,
+		},
+		{
+			Name: "Should answer 503 once the global limit is reached",
+			Handler: limit(func(c *limiter.LimiterConfig) {
+				c.ClientCheckType = limiter.CHECK_GLOBAL
+				c.MaxGlobalRequests = MaxRequests
+			}),
+			Requests: MaxRequests,
+			Status:   http.StatusServiceUnavailable,
+			Err:      limiter.ErrGlobalLimitReached,
+		},
+		{
+			Name:     "Should answer 429 once the requests limit is reached",
+			Handler:  limit(func(c *limiter.LimiterConfig) {}),
+			Requests: MaxRequests,
+			Status:   http.StatusTooManyRequests,
+			Err:      limiter.ErrMaxNumberRequestsReached,
+		},
+		{
+			Name: "Should answer 429 once the quota is exceeded",
+			Handler: limit(func(c *limiter.LimiterConfig) {
+				c.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+				suite.Repository.SaveApiKey(limiter.APIKey{ID: "goexpert-key", MaxRequests: 10, DailyQuota: 1})
+			}),
+			Headers:  map[string]string{"API_KEY": "goexpert-key"},
+			Requests: 1,
+			Status:   http.StatusTooManyRequests,
+			Err:      limiter.ErrQuotaExceeded,
+		},
+		{
+			Name:    "Should answer 429 once the requests in flight limit is reached",
+			Handler: concurrency(limiter.ConcurrencyConfig{ClientCheckType: limiter.CHECK_IP_ONLY}),
+			Status:  http.StatusTooManyRequests,
+			Err:     limiter.ErrMaxConcurrentRequests,
+		},
+		{
+			Name:     "Should answer 400 to a request without client",
+			Handler:  limit(func(c *limiter.LimiterConfig) {}),
+			NoClient: true,
+			Status:   http.StatusBadRequest,
+			Err:      limiter.ErrInvalidClient,
+		},
+		{
+			Name: "Should answer 400 to a request without cost",
+			Handler: func() http.Handler {
+				return suite.limiterMiddleware().WithCost(middleware.FixedCost(0)).Limit(suite.handler())
+			},
+			Status: http.StatusBadRequest,
+			Err:    limiter.ErrInvalidCost,
+		},
+		{
+			Name: "Should answer 400 to a request costing more than the limit",
+			Handler: func() http.Handler {
+				return suite.limiterMiddleware().WithCost(middleware.FixedCost(MaxRequests + 1)).Limit(suite.handler())
+			},
+			Status: http.StatusBadRequest,
+			Err:    limiter.ErrCostExceedsLimit,
+		},
+		{
+			Name: "Should answer 400 to an unknown API Key",
+			Handler: limit(func(c *limiter.LimiterConfig) {
+				c.ClientCheckType = limiter.CHECK_API_KEY_ONLY
+			}),
+			Headers: map[string]string{"API_KEY": "unknown-key"},
+			Status:  http.StatusBadRequest,
+			Err:     limiter.ErrApiKeyNotFound,
+		},
+		{
+			Name:    "Should answer 400 to an unknown API Key of the requests in flight limit",
+			Handler: concurrency(limiter.ConcurrencyConfig{ClientCheckType: limiter.CHECK_API_KEY_ONLY, MaxInFlight: 1}),
+			Headers: map[string]string{"API_KEY": "unknown-key"},
+			Status:  http.StatusBadRequest,
+			Err:     limiter.ErrApiKeyNotFound,
+		},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			suite.SetupTest()
+			handler := t.Handler()
+			for i := 0; i < t.Requests; i++ {
+				suite.request(handler, t.Headers)
+			}
+			suite.Handled = 0
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.168.0.1:5000"
+			if t.NoClient {
+				req.RemoteAddr = ""
+			}
+			for name, value := range t.Headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			suite.Equal(t.Status, rec.Code)
+			suite.Contains(rec.Body.String(), t.Err.Error())
+			suite.Zero(suite.Handled)
+		})
+	}
+}
+
+func (suite *MiddlewareTestSuite) TestGetIP() {
+	testCases := []struct {
+		Name       string
+		RemoteAddr string
+		Headers    map[string]string
+		Expected   string
+	}{
+		{Name: "Should drop the port of an IPv4 remote address", RemoteAddr: "192.168.0.1:5000", Expected: "192.168.0.1"},
+		{Name: "Should drop the port of an IPv6 remote address", RemoteAddr: "[2001:db8::1]:5000", Expected: "2001:db8::1"},
+		{Name: "Should keep an IPv6 address without port", Headers: map[string]string{"X-Real-Ip": "2001:db8::1"}, Expected: "2001:db8::1"},
+		{Name: "Should keep a bracketed IPv6 address without port", Headers: map[string]string{"X-Forwarded-For": "[2001:db8::1]"}, Expected: "2001:db8::1"},
+		{Name: "Should keep an IPv4 address without port", Headers: map[string]string{"X-Forwarded-For": "10.0.0.1"}, Expected: "10.0.0.1"},
+		{Name: "Should unmap an IPv4-mapped IPv6 address", RemoteAddr: "[::ffff:10.0.0.1]:5000", Expected: "10.0.0.1"},
+	}
+
+	for _, t := range testCases {
+		suite.Run(t.Name, func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = t.RemoteAddr
+			for name, value := range t.Headers {
+				req.Header.Set(name, value)
+			}
+			suite.Equal(t.Expected, middleware.GetIP(req))
+		})
+	}
+}
+
+func (suite *MiddlewareTestSuite) TestLimit_IPv6() {
+	request := func(handler http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	suite.Run("Should check the IPv6 clients against the IPv6 CIDR entries of the access list", func() {
+		suite.SetupTest()
+		l := limiter.NewLimiter(suite.Config, suite.Repository).WithClock(suite.Clock)
+		l.AccessList = limiter.NewAccessList(suite.Repository)
+		suite.NoError(l.AccessList.Save(limiter.AccessEntry{Value: "2001:db8:dead::/48", Access: limiter.ACCESS_DENY}))
+		suite.NoError(l.AccessList.Save(limiter.AccessEntry{Value: "2001:db8:beef::/48", Access: limiter.ACCESS_ALLOW}))
+		handler := middleware.NewLimiterMiddleware(l).Limit(suite.handler())
+
+		suite.Equal(http.StatusForbidden, request(handler, "[2001:db8:dead::1]:5000"))
+		for i := 0; i < MaxRequests+1; i++ {
+			suite.Equal(http.StatusOK, request(handler, "[2001:db8:beef::1]:5000"))
+		}
+	})
+
+	suite.Run("Should limit each IPv6 client on its own", func() {
+		suite.SetupTest()
+		handler := suite.limiterMiddleware().Limit(suite.handler())
+
+		for i := 0; i < MaxRequests; i++ {
+			suite.Equal(http.StatusOK, request(handler, "[2001:db8::1]:5000"))
+		}
+		suite.Equal(http.StatusTooManyRequests, request(handler, "[2001:db8::1]:5000"))
+		suite.Equal(http.StatusOK, request(handler, "[2001:db8::2]:5000"))
+	})
+}
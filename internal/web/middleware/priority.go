@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+)
+
+// PriorityFunc returns the request priority class, limiter.PRIORITY_DEFAULT to take it from the API Key
+type PriorityFunc func(r *http.Request) int
+
+// FixedPriority gives the same priority to every request, as a per route setting
+func FixedPriority(priority int) PriorityFunc {
+	return func(r *http.Request) int {
+		return priority
+	}
+}
+
+// HeaderPriority takes the priority name sent on the request header, as "high".
+// Requests without the header, or with an unknown priority, take the one of the API Key.
+// It must only be used on routes where the header is set by trusted callers
+func HeaderPriority(header string) PriorityFunc {
+	return func(r *http.Request) int {
+		priority, _ := limiter.ParsePriority(r.Header.Get(header))
+		return priority
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// MAX_REQUEST_ID_LENGTH is the longest request ID taken from the client, longer ones are replaced
+const MAX_REQUEST_ID_LENGTH = 128
+
+// withRequestID returns the request carrying its request ID, sent by the client or generated, and sets it on the response header.
+// A request that already carries one, as on the second limiter of a route, is returned as is
+func withRequestID(header http.Header, r *http.Request) *http.Request {
+	if logging.RequestID(r.Context()) != "" {
+		return r
+	}
+
+	id := r.Header.Get(REQUEST_ID_HEADER)
+	if !validRequestID(id) {
+		id = logging.NewRequestID()
+	}
+
+	header.Set(REQUEST_ID_HEADER, id)
+	return r.WithContext(logging.WithRequestID(r.Context(), id))
+}
+
+// validRequestID reports whether the client request ID is safe to log, as letters, digits, '-', '_' and '.' only
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
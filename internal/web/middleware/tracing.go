@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+
+// extractTraceContext returns the request context carrying the trace propagated by the caller,
+// unless the request is already traced by an outer handler
+func extractTraceContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+func startLimitSpan(r *http.Request, limiterName string) (context.Context, trace.Span) {
+	if limiterName == "" {
+		limiterName = "default"
+	}
+
+	return otel.Tracer(TRACER_NAME).Start(r.Context(), "LimiterMiddleware.Limit", trace.WithAttributes(
+		attribute.String("limiter.policy", limiterName),
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+	))
+}
+
+// endLimitSpan records why the request was refused
+func endLimitSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DEFAULT_HEALTH_PATH = "/"
+const DEFAULT_HEALTH_INTERVAL = 10 * time.Second
+const DEFAULT_HEALTH_TIMEOUT = 2 * time.Second
+
+// HealthChecker takes the upstreams that fail their health check out of rotation, until they pass it again.
+// An upstream passes if it answers the health path with a status below 500 within the timeout
+type HealthChecker struct {
+	Upstreams []*Upstream
+	Path      string
+	Interval  time.Duration
+	Timeout   time.Duration
+
+	// Client makes the health checks, http.DefaultClient if nil
+	Client *http.Client
+
+	// Logger logs the upstreams going in and out of rotation, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+// NewHealthChecker returns the health checker of every upstream of the routes
+func NewHealthChecker(routes ...*Route) *HealthChecker {
+	var upstreams []*Upstream
+	for _, route := range routes {
+		upstreams = append(upstreams, route.Upstreams...)
+	}
+
+	return &HealthChecker{
+		Upstreams: upstreams,
+		Path:      DEFAULT_HEALTH_PATH,
+		Interval:  DEFAULT_HEALTH_INTERVAL,
+		Timeout:   DEFAULT_HEALTH_TIMEOUT,
+	}
+}
+
+// WithPath sets the path the upstreams are checked on
+func (c *HealthChecker) WithPath(path string) *HealthChecker {
+	c.Path = path
+	return c
+}
+
+// WithInterval sets how often the upstreams are checked
+func (c *HealthChecker) WithInterval(interval time.Duration) *HealthChecker {
+	c.Interval = interval
+	return c
+}
+
+// WithClient sets the client the health checks are made by
+func (c *HealthChecker) WithClient(client *http.Client) *HealthChecker {
+	c.Client = client
+	return c
+}
+
+// WithLogger sets the logger the upstreams going in and out of rotation are logged by
+func (c *HealthChecker) WithLogger(logger *slog.Logger) *HealthChecker {
+	c.Logger = logger
+	return c
+}
+
+// Watch checks the upstreams every interval, DEFAULT_HEALTH_INTERVAL if not set, until ctx is done
+func (c *HealthChecker) Watch(ctx context.Context) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DEFAULT_HEALTH_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks every upstream at once, updating whether they are in rotation
+func (c *HealthChecker) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range c.Upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			healthy := c.check(ctx, u)
+			if u.unhealthy.Swap(!healthy) == healthy {
+				c.logger().Warn("upstream health changed", slog.String("upstream", u.URL.String()), slog.Bool("healthy", healthy))
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (c *HealthChecker) check(ctx context.Context, u *Upstream) bool {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL.JoinPath(c.Path).String(), nil)
+	if err != nil {
+		return false
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode < http.StatusInternalServerError
+}
+
+func (c *HealthChecker) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yamauthi/goexpert-rate-limiter/internal/logging"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+)
+
+// RATE_LIMIT_HEADER_PREFIX prefixes the headers the limiters answer with, as X-RateLimit-Remaining
+const RATE_LIMIT_HEADER_PREFIX = "X-Ratelimit-"
+
+var ErrInvalidRoute = errors.New("a route must be `<path>=<upstream URL>[,<upstream URL>...]`")
+var ErrNoHealthyUpstream = errors.New("there is no healthy upstream to forward the request to")
+
+// Upstream is a service the requests of a route are forwarded to
+type Upstream struct {
+	URL *url.URL
+
+	// unhealthy reports whether the upstream failed its last health check, as to take it out of rotation
+	unhealthy atomic.Bool
+}
+
+func NewUpstream(target *url.URL) *Upstream {
+	return &Upstream{
+		URL: target,
+	}
+}
+
+// Healthy reports whether the upstream passed its last health check, or was not checked yet
+func (u *Upstream) Healthy() bool {
+	return !u.unhealthy.Load()
+}
+
+// Route forwards the requests matching its pattern to its upstreams, round robin among the healthy ones
+type Route struct {
+	// Pattern is the http.ServeMux pattern of the route, as `/api/`
+	Pattern   string
+	Upstreams []*Upstream
+
+	// Limiters check the requests before they are forwarded, the first one being the outermost
+	Limiters []*middleware.LimiterMiddleware
+
+	next atomic.Uint64
+}
+
+func NewRoute(pattern string, upstreams ...*Upstream) *Route {
+	return &Route{
+		Pattern:   pattern,
+		Upstreams: upstreams,
+	}
+}
+
+// WithLimiters sets the limiters in front of the upstreams
+func (r *Route) WithLimiters(limiters ...*middleware.LimiterMiddleware) *Route {
+	r.Limiters = limiters
+	return r
+}
+
+// upstream returns the next healthy upstream, nil if there is none
+func (r *Route) upstream() *Upstream {
+	for range r.Upstreams {
+		u := r.Upstreams[(r.next.Add(1)-1)%uint64(len(r.Upstreams))]
+		if u.Healthy() {
+			return u
+		}
+	}
+	return nil
+}
+
+// Proxy forwards the requests of its routes to their upstreams, behind the route limiters
+type Proxy struct {
+	Routes []*Route
+
+	// Transport makes the upstream requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	// Logger logs the upstream failures, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+func NewProxy(routes ...*Route) *Proxy {
+	return &Proxy{
+		Routes: routes,
+	}
+}
+
+// WithTransport sets the transport the upstream requests are made by
+func (p *Proxy) WithTransport(transport http.RoundTripper) *Proxy {
+	p.Transport = transport
+	return p
+}
+
+// WithLogger sets the logger the upstream failures are logged by
+func (p *Proxy) WithLogger(logger *slog.Logger) *Proxy {
+	p.Logger = logger
+	return p
+}
+
+// Handler returns the handler of every route, answering 404 to the requests of no route
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range p.Routes {
+		mux.Handle(route.Pattern, middleware.Chain(p.forward(route), route.Limiters...))
+	}
+	return mux
+}
+
+// forward returns the handler forwarding the requests to the route upstreams.
+// The rate limit headers the limiters set are told to the upstream and replace the ones the upstream answers with
+func (p *Proxy) forward(route *Route) http.Handler {
+	reverseProxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			upstream := pr.In.Context().Value(upstreamKey{}).(*Upstream)
+			pr.SetURL(upstream.URL)
+			// SetXForwarded replaces the X-Forwarded-For of the client proxies, it is kept so that GetIP reads the same IP upstream
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(res *http.Response) error {
+			limited := res.Request.Context().Value(limitHeadersKey{}).(http.Header)
+			for name := range limited {
+				res.Header.Del(name)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.logger().Error("upstream request failed",
+				slog.String(logging.REQUEST_ID_KEY, logging.RequestID(r.Context())),
+				slog.String("route", route.Pattern),
+				slog.String("upstream", r.Context().Value(upstreamKey{}).(*Upstream).URL.String()),
+				slog.String("error", err.Error()),
+			)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+		Transport: p.Transport,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream := route.upstream()
+		if upstream == nil {
+			http.Error(w, ErrNoHealthyUpstream.Error(), http.StatusBadGateway)
+			return
+		}
+
+		limited := rateLimitHeaders(w.Header())
+		r = r.Clone(withProxyValues(r.Context(), upstream, limited))
+		for name, values := range limited {
+			r.Header[name] = values
+		}
+		if id := logging.RequestID(r.Context()); id != "" {
+			r.Header.Set(middleware.REQUEST_ID_HEADER, id)
+		}
+		reverseProxy.ServeHTTP(w, r)
+	})
+}
+
+func (p *Proxy) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+type upstreamKey struct{}
+type limitHeadersKey struct{}
+
+// withProxyValues returns the context carrying the upstream the request is forwarded to
+// and the rate limit headers the limiters answered with
+func withProxyValues(ctx context.Context, upstream *Upstream, limited http.Header) context.Context {
+	ctx = context.WithValue(ctx, upstreamKey{}, upstream)
+	return context.WithValue(ctx, limitHeadersKey{}, limited)
+}
+
+// rateLimitHeaders returns a copy of the rate limit headers, as set by the limiters
+func rateLimitHeaders(header http.Header) http.Header {
+	limited := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), RATE_LIMIT_HEADER_PREFIX) {
+			limited[name] = append([]string(nil), values...)
+		}
+	}
+	return limited
+}
+
+// ParseRoutes parses the routes, separated by `;`, as `<path>=<upstream URL>[,<upstream URL>...]`
+func ParseRoutes(value string) ([]*Route, error) {
+	var routes []*Route
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, targets, found := strings.Cut(entry, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !found || !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRoute, entry)
+		}
+
+		var upstreams []*Upstream
+		for _, target := range strings.Split(targets, ",") {
+			u, err := url.Parse(strings.TrimSpace(target))
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidRoute, entry)
+			}
+			upstreams = append(upstreams, NewUpstream(u))
+		}
+		routes = append(routes, NewRoute(pattern, upstreams...))
+	}
+	return routes, nil
+}
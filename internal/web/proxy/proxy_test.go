@@ -0,0 +1,225 @@
+package proxy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/database"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/limiter"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/middleware"
+	"github.com/yamauthi/goexpert-rate-limiter/internal/web/proxy"
+)
+
+const MaxRequests = 3
+
+type ProxyTestSuite struct {
+	suite.Suite
+	Limiter *limiter.Limiter
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(ProxyTestSuite))
+}
+
+func (suite *ProxyTestSuite) SetupTest() {
+	clock := limiter.NewFakeClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	suite.Limiter = limiter.NewLimiter(limiter.LimiterConfig{
+		ClientCheckType:       limiter.CHECK_IP_ONLY,
+		ClientBlockTime:       time.Second,
+		MaxIPRequests:         MaxRequests,
+		RequestsLimitInterval: limiter.REQUESTS_PER_SECOND,
+	}, database.NewMemoryLimiterRepository(clock)).WithClock(clock)
+}
+
+// newUpstream starts an upstream answering with its name, counting the requests it gets
+func (suite *ProxyTestSuite) newUpstream(name string, handler http.HandlerFunc) (*proxy.Upstream, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if handler != nil {
+			handler(w, r)
+			return
+		}
+		io.WriteString(w, name)
+	}))
+	suite.T().Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	suite.NoError(err)
+	return proxy.NewUpstream(target), &requests
+}
+
+func (suite *ProxyTestSuite) get(handler http.Handler, path string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "192.168.0.1:5000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func body(res *http.Response) string {
+	content, _ := io.ReadAll(res.Body)
+	return string(content)
+}
+
+func (suite *ProxyTestSuite) TestProxy_Forward() {
+	suite.Run("Should forward round robin to the upstreams", func() {
+		first, _ := suite.newUpstream("first", nil)
+		second, _ := suite.newUpstream("second", nil)
+		handler := proxy.NewProxy(proxy.NewRoute("/api/", first, second)).Handler()
+
+		suite.Equal("first", body(suite.get(handler, "/api/users")))
+		suite.Equal("second", body(suite.get(handler, "/api/users")))
+		suite.Equal("first", body(suite.get(handler, "/api/users")))
+		suite.Equal(http.StatusNotFound, suite.get(handler, "/other").StatusCode)
+	})
+
+	suite.Run("Should answer 502 if the upstream fails", func() {
+		upstream, _ := suite.newUpstream("down", nil)
+		upstream.URL.Host = "127.0.0.1:1"
+		handler := proxy.NewProxy(proxy.NewRoute("/", upstream)).Handler()
+
+		suite.Equal(http.StatusBadGateway, suite.get(handler, "/").StatusCode)
+	})
+}
+
+func (suite *ProxyTestSuite) TestProxy_Limiters() {
+	var upstreamRemaining, upstreamForwardedFor atomic.Value
+	upstream, requests := suite.newUpstream("", func(w http.ResponseWriter, r *http.Request) {
+		upstreamRemaining.Store(r.Header.Get("X-RateLimit-Remaining"))
+		upstreamForwardedFor.Store(r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-RateLimit-Remaining", "1000")
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		io.WriteString(w, "ok")
+	})
+	route := proxy.NewRoute("/", upstream).WithLimiters(middleware.NewLimiterMiddleware(suite.Limiter))
+	handler := proxy.NewProxy(route).Handler()
+
+	suite.Run("Should pass the limiter headers to the upstream and to the client in place of the upstream ones", func() {
+		res := suite.get(handler, "/")
+		suite.Equal(http.StatusOK, res.StatusCode)
+		suite.Equal([]string{"2"}, res.Header.Values("X-RateLimit-Remaining"))
+		suite.Equal("1000", res.Header.Get("X-RateLimit-Limit"))
+		suite.NotEmpty(res.Header.Get(middleware.REQUEST_ID_HEADER))
+		suite.Equal("2", upstreamRemaining.Load())
+		suite.Equal("192.168.0.1", upstreamForwardedFor.Load())
+	})
+
+	suite.Run("Should refuse the requests over the limit without forwarding them", func() {
+		suite.get(handler, "/")
+		suite.get(handler, "/")
+		res := suite.get(handler, "/")
+		suite.Equal(http.StatusTooManyRequests, res.StatusCode)
+		suite.Equal(int64(MaxRequests), requests.Load())
+	})
+}
+
+func (suite *ProxyTestSuite) TestHealthChecker_Check() {
+	var failing atomic.Bool
+	healthy, _ := suite.newUpstream("healthy", nil)
+	flaky, _ := suite.newUpstream("flaky", func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "flaky")
+	})
+	route := proxy.NewRoute("/", flaky, healthy)
+	handler := proxy.NewProxy(route).Handler()
+	checker := proxy.NewHealthChecker(route).WithPath("/health")
+
+	suite.Run("Should take the failing upstreams out of rotation", func() {
+		failing.Store(true)
+		checker.Check(context.Background())
+		suite.False(flaky.Healthy())
+		suite.True(healthy.Healthy())
+
+		suite.Equal("healthy", body(suite.get(handler, "/")))
+		suite.Equal("healthy", body(suite.get(handler, "/")))
+	})
+
+	suite.Run("Should put the upstreams back once they pass", func() {
+		failing.Store(false)
+		checker.Check(context.Background())
+		suite.True(flaky.Healthy())
+
+		bodies := []string{body(suite.get(handler, "/")), body(suite.get(handler, "/"))}
+		suite.ElementsMatch([]string{"flaky", "healthy"}, bodies)
+	})
+
+	suite.Run("Should answer 502 if no upstream is healthy", func() {
+		healthy.URL.Host = "127.0.0.1:1"
+		failing.Store(true)
+		checker.Check(context.Background())
+
+		res := suite.get(handler, "/")
+		suite.Equal(http.StatusBadGateway, res.StatusCode)
+		suite.Contains(body(res), proxy.ErrNoHealthyUpstream.Error())
+	})
+}
+
+func (suite *ProxyTestSuite) TestListenAndServe_Shutdown() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.NoError(err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	server := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- proxy.ListenAndServe(ctx, server, time.Second)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		responses <- body(res)
+	}()
+
+	<-started
+	cancel()
+	suite.Equal("done", <-responses, "the request in flight should be answered")
+	suite.NoError(<-served)
+}
+
+func (suite *ProxyTestSuite) TestParseRoutes() {
+	routes, err := proxy.ParseRoutes("/api/=http://api:8000, http://api2:8000; /reports=https://reports")
+	suite.NoError(err)
+	suite.Len(routes, 2)
+	suite.Equal("/api/", routes[0].Pattern)
+	suite.Len(routes[0].Upstreams, 2)
+	suite.Equal("api2:8000", routes[0].Upstreams[1].URL.Host)
+	suite.Equal("https://reports", routes[1].Upstreams[0].URL.String())
+
+	for _, invalid := range []string{"api=http://api", "/api", "/api=api:8000", "/api=http://api,"} {
+		_, err := proxy.ParseRoutes(invalid)
+		suite.ErrorIs(err, proxy.ErrInvalidRoute, invalid)
+	}
+}
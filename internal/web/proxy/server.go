@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// ListenAndServe serves until ctx is done, then shuts the server down gracefully,
+// waiting up to timeout for the requests in flight to be answered
+func ListenAndServe(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}